@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+)
+
+// runConfig inspects the layered configuration or migrates a config file
+func runConfig(args []string) error {
+	if len(args) > 0 && args[0] == "migrate" {
+		return runConfigMigrate(args[1:])
+	}
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner config show [-origin]")
+		fmt.Fprintln(os.Stderr, "       adb-cleaner config migrate [file]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	origin := fs.Bool("origin", false, "show which file, variable or flag each setting came from")
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+
+	if !*origin {
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, setting := range cfg.Settings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Origin)
+	}
+	return w.Flush()
+}
+
+// runConfigMigrate rewrites a config file in the current format, by default
+// the project file -config names
+func runConfigMigrate(args []string) error {
+	filename := config.ProjectFile(configFlags)
+	if len(args) > 0 {
+		filename = args[0]
+	}
+
+	version, err := config.MigrateFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if version == config.CurrentVersion {
+		fmt.Printf("%s is already at version %d\n", filename, version)
+		return nil
+	}
+	fmt.Printf("Migrated %s from version %d to %d (original saved as %s.bak)\n", filename, version, config.CurrentVersion, filename)
+	return nil
+}
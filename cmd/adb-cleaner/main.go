@@ -0,0 +1,325 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/adb-cleaner/adb-cleaner/internal/logging"
+	"github.com/adb-cleaner/adb-cleaner/internal/server"
+	"github.com/adb-cleaner/adb-cleaner/internal/theme"
+	"github.com/adb-cleaner/adb-cleaner/internal/ui"
+)
+
+// Version is set at build time
+var Version = "dev"
+
+// configFlags holds the settings given before the subcommand
+var configFlags *config.Flags
+
+// verbose mirrors the log to stderr in the command line modes
+var verbose *bool
+
+// Sessions recorded to or replayed from a fixture file, or played by a
+// simulated device, instead of only talking to adb
+var (
+	recordFile   *string
+	replayFile   *string
+	simulateFile *string
+	recording    *adb.RecordingTransport
+)
+
+func main() {
+	fs := flag.NewFlagSet("adb-cleaner", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner [flags] [verify|restore|serve|plan|apply|report|snapshot|config|version] [args]")
+		fs.PrintDefaults()
+	}
+	configFlags = config.RegisterFlags(fs)
+	verbose = fs.Bool("verbose", false, "mirror the log to stderr (not in the terminal UI)")
+	recordFile = fs.String("record", "", "record every adb command and its output to a fixture file")
+	replayFile = fs.String("replay", "", "serve adb commands from a fixture file instead of a device")
+	simulateFile = fs.String("simulate", "", "run against a simulated device played from a snapshot file")
+	fs.Parse(os.Args[1:])
+	args := fs.Args()
+
+	// Each interaction is written as it happens; closing only releases the file
+	defer func() {
+		if recording != nil {
+			recording.Close()
+		}
+	}()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "verify":
+			os.Exit(runVerify(args[1:]))
+		case "restore":
+			if err := runRestore(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "serve":
+			if err := runServe(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "plan":
+			if err := runPlan(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "apply":
+			if err := runApply(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "report":
+			if err := runReport(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "snapshot":
+			if err := runSnapshot(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "config":
+			if err := runConfig(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "version":
+			fmt.Printf("adb-cleaner %s\n", Version)
+			return
+		default:
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+
+	if err := runTUI(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// startLogging writes the log to the log directory, and to stderr as well
+// with -verbose when the command does not take over the terminal
+func startLogging(cfg *config.Config, command string, cli bool) (io.Closer, error) {
+	logs, err := logging.Setup(logging.Options{
+		Dir:    cfg.GetLogDir(),
+		Level:  cfg.LogLevel,
+		Format: cfg.LogFormat,
+		Stderr: cli && *verbose,
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("adb-cleaner started", "version", Version, "command", command)
+	return logs, nil
+}
+
+// runTUI starts the interactive terminal interface
+func runTUI() error {
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "tui", false)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	eng, err := newEngine(cfg, "")
+	if err != nil {
+		return err
+	}
+
+	t, err := theme.Load(cfg.Theme)
+	if err != nil {
+		return err
+	}
+
+	app := ui.NewApp(eng)
+	app.SetBackup(cfg.GetBackupDir(), cfg.BackupAPKs)
+	app.SetReports(cfg.GetLogDir(), cfg.GetReportFormats())
+	app.SetUserPack(cfg.UserPack)
+	app.SetIncludeRelated(cfg.IncludeRelated)
+	app.SetTheme(t)
+	if err := app.SetKeys(cfg.Keys); err != nil {
+		return fmt.Errorf("invalid configuration: %s: %w", cfg.Origin("keys"), err)
+	}
+	return app.Run()
+}
+
+// newClient creates the adb client, recording or replaying its session when
+// -record or -replay is given and simulating a device with -simulate
+func newClient(cfg *config.Config) (*adb.Client, error) {
+	given := 0
+	for _, file := range []string{*recordFile, *replayFile, *simulateFile} {
+		if file != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, fmt.Errorf("only one of -record, -replay and -simulate can be given")
+	}
+
+	if *simulateFile != "" {
+		snapshot, err := adb.LoadSnapshot(*simulateFile)
+		if err != nil {
+			return nil, err
+		}
+		return adb.NewClientWithTransport(adb.NewSimulator(snapshot)), nil
+	}
+
+	if *replayFile != "" {
+		replay, err := adb.LoadReplay(*replayFile)
+		if err != nil {
+			return nil, err
+		}
+		return adb.NewClientWithTransport(replay), nil
+	}
+
+	if *recordFile != "" {
+		var err error
+		recording, err = adb.NewRecordingTransport(&adb.ExecTransport{Path: cfg.ADBPath}, *recordFile)
+		if err != nil {
+			return nil, err
+		}
+		return adb.NewClientWithTransport(recording), nil
+	}
+
+	return adb.NewClient(cfg.ADBPath), nil
+}
+
+// newEngine connects to a device and applies the profile the config assigns
+// to it
+func newEngine(cfg *config.Config, serial string) (*engine.Engine, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsAvailable() {
+		return nil, fmt.Errorf("ADB not found. Please install ADB and add it to PATH.")
+	}
+	if serial != "" {
+		client = client.WithSerial(serial)
+	}
+
+	device, err := client.GetDevice()
+	if err != nil {
+		return nil, fmt.Errorf("No device found or device not authorized.")
+	}
+
+	return engine.ForDevice(client, device, cfg)
+}
+
+// runServe exposes the engine as a local HTTP API
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	serial := fs.String("device", "", "serial of the device to select at startup")
+	fs.Parse(args)
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "serve", true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	if !client.IsAvailable() {
+		return fmt.Errorf("ADB not found. Please install ADB and add it to PATH.")
+	}
+
+	srv := server.New(client, server.Options{
+		Config:        cfg,
+		BackupDir:     cfg.GetBackupDir(),
+		BackupAPKs:    cfg.BackupAPKs,
+		ReportDir:     cfg.GetLogDir(),
+		ReportFormats: cfg.GetReportFormats(),
+	})
+
+	// Select the requested device, or the first one if any is connected
+	if *serial == "" {
+		if serials, err := client.ListDevices(); err == nil && len(serials) > 0 {
+			*serial = serials[0]
+		}
+	}
+	if *serial != "" {
+		if err := srv.SelectDevice(*serial); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("ADB Cleaner web UI and API listening on http://%s\n", *addr)
+	return http.ListenAndServe(*addr, srv.Handler())
+}
+
+// runVerify checks backup archives against their manifest checksums
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner verify <archive>...")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		archive, err := backup.OpenArchive(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		problems, err := archive.Verify()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		if len(problems) == 0 {
+			fmt.Printf("%s: OK (%d packages, %d files)\n", path, len(archive.Manifest.Packages), len(archive.Manifest.Checksums))
+			continue
+		}
+
+		status = 1
+		fmt.Printf("%s: FAILED\n", path)
+		for _, problem := range problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+
+	return status
+}
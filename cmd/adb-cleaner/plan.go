@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+)
+
+// runPlan writes a plan file for a selection on one device
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	serial := fs.String("device", "", "serial of the device to plan for")
+	output := fs.String("o", "", "file to write the plan to (default stdout)")
+	profileName := fs.String("profile", "", "profile to plan with (default: the one matching the device)")
+	risk := fs.String("risk", "", "comma-separated risk levels to select in addition")
+	selectNames := fs.String("select", "", "comma-separated packages to select in addition")
+	from := fs.String("from", "", "backup archive or list to take the selection from")
+	includeRelated := fs.Bool("include-related", false, "also remove overlays and dependents of the selected packages")
+	fs.Parse(args)
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "plan", true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	eng, err := newEngine(cfg, *serial)
+	if err != nil {
+		return err
+	}
+
+	if *profileName != "" {
+		profile, err := cfg.Profile(*profileName)
+		if err != nil {
+			return err
+		}
+		if err := eng.ApplyProfile(*profileName, profile); err != nil {
+			return err
+		}
+	}
+
+	// Unrated packages are selected by -risk at the level of their score
+	if err := eng.ScorePackages(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: packages are not scored: %v\n", err)
+	}
+
+	manager := eng.Manager()
+	for _, level := range splitList(*risk) {
+		manager.SelectByRiskLevel(strings.ToUpper(level))
+	}
+	for _, name := range splitList(*selectNames) {
+		if !manager.SetSelected(name, true) {
+			return fmt.Errorf("package %s is not in the loaded packs", name)
+		}
+	}
+	if *from != "" {
+		unmatched, err := manager.LoadBackup(*from, eng.Device(), true)
+		if err != nil {
+			return err
+		}
+		if len(unmatched) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: not in the loaded packs, left out: %s\n", strings.Join(unmatched, ", "))
+		}
+	}
+
+	reportImpacts(eng, *includeRelated || cfg.IncludeRelated)
+	reportDefaults(eng)
+
+	plan, err := eng.PlanFile()
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return plan.Write(os.Stdout)
+	}
+	if err := plan.Save(*output); err != nil {
+		return err
+	}
+
+	fmt.Printf("Plan written to %s: %d to remove, %d skipped\n", *output, len(plan.Actions), len(plan.Skipped))
+	return nil
+}
+
+// runApply executes a plan file after checking it against the device
+func runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	serial := fs.String("device", "", "serial of the device (default: the one the plan was made for)")
+	dryRun := fs.Bool("dry-run", false, "report what would be removed without removing anything")
+	backupAPKs := fs.Bool("backup-apks", false, "back up APKs before removal")
+	force := fs.Bool("force", false, "remove default apps that no other app has taken over from")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner apply [flags] <plan.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	plan, err := engine.LoadPlan(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "apply", true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	if *serial == "" {
+		*serial = plan.Device.Serial
+	}
+	eng, err := newEngine(cfg, *serial)
+	if err != nil {
+		return err
+	}
+
+	opts := engine.Options{
+		DryRun:        *dryRun,
+		BackupAPKs:    *backupAPKs || cfg.BackupAPKs,
+		BackupDir:     cfg.GetBackupDir(),
+		ReportDir:     cfg.GetLogDir(),
+		ReportFormats: cfg.GetReportFormats(),
+		Force:         *force,
+	}
+
+	summary, err := eng.Apply(plan, opts, func(ev engine.Event) {
+		fmt.Println(ev.String())
+	})
+	if errors.Is(err, engine.ErrPlanMismatch) {
+		return fmt.Errorf("%w, make a new plan", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range summary.Archives {
+		fmt.Printf("Run recorded in %s\n", archive)
+	}
+	for _, file := range summary.Reports {
+		fmt.Printf("Report written to %s\n", file)
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d packages failed", summary.Failed)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// reportImpacts warns on stderr about the packages the selection affects,
+// selecting the ones that can go with it first when include is set
+func reportImpacts(eng *engine.Engine, include bool) {
+	impacts, err := eng.Impacts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: only the relations declared by the packs are checked: %v\n", err)
+	}
+
+	if include {
+		for _, name := range eng.IncludeRelated(impacts) {
+			fmt.Fprintf(os.Stderr, "Including related package %s\n", name)
+		}
+		impacts, _ = eng.Impacts()
+	}
+
+	for _, impact := range impacts {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", impact.Message)
+	}
+}
+
+// reportDefaults warns on stderr about the selected packages the target
+// users rely on as default apps
+func reportDefaults(eng *engine.Engine) {
+	conflicts, err := eng.DefaultConflicts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: default apps are not checked: %v\n", err)
+	}
+
+	for _, conflict := range conflicts {
+		if conflict.Replace {
+			fmt.Fprintf(os.Stderr, "Warning: %s, make another app the default before applying or apply with -force\n", conflict.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", conflict.Message)
+		}
+	}
+}
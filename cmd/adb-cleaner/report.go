@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/report"
+)
+
+// runReport writes the report of a past run from its backup archives, one
+// per target user
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", report.FormatMarkdown, "report format: "+strings.Join(report.Formats, ", "))
+	output := fs.String("o", "", "file to write the report to (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner report [flags] <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if report.Extension(*format) == "" {
+		return fmt.Errorf("unknown report format %q, expected %s", *format, strings.Join(report.Formats, ", "))
+	}
+
+	r, err := report.Load(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := r.Write(w, *format); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
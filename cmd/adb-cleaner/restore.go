@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+)
+
+// runRestore reinstalls every package a backup archive records as removed
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "restore onto a device other than the one the archive was taken on")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: adb-cleaner restore [flags] <archive>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	archive, err := backup.OpenArchive(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "restore", true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	if !client.IsAvailable() {
+		return fmt.Errorf("ADB not found. Please install ADB and add it to PATH.")
+	}
+
+	device, err := client.GetDevice()
+	if err != nil {
+		return fmt.Errorf("No device found or device not authorized.")
+	}
+	if err := archive.CheckDevice(device, *force); err != nil {
+		return err
+	}
+
+	failed := 0
+	err = archive.RestoreAll(client, archive.RemovedPackages(), archive.Manifest.UserID, func(pkg string, err error) {
+		if err != nil {
+			fmt.Printf("[FAIL] %s: %v\n", pkg, err)
+			failed++
+			return
+		}
+		fmt.Printf("[RESTORED] %s\n", pkg)
+	})
+	if err != nil && failed == 0 {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d packages failed", failed)
+	}
+	return nil
+}
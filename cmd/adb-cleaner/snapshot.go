@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+)
+
+// runSnapshot captures the state of a device into a snapshot file that
+// -simulate plays back
+func runSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	serial := fs.String("device", "", "serial of the device to capture")
+	users := fs.String("users", "0", "comma-separated users to capture")
+	output := fs.String("o", "", "file to write the snapshot to (default stdout)")
+	fs.Parse(args)
+
+	cfg, err := config.Load(configFlags)
+	if err != nil {
+		return err
+	}
+	logs, err := startLogging(cfg, "snapshot", true)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	if !client.IsAvailable() {
+		return fmt.Errorf("ADB not found. Please install ADB and add it to PATH.")
+	}
+	if *serial != "" {
+		client = client.WithSerial(*serial)
+	}
+
+	snapshot, err := client.Snapshot(splitList(*users))
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return snapshot.Write(os.Stdout)
+	}
+	if err := snapshot.Save(*output); err != nil {
+		return err
+	}
+
+	fmt.Printf("Snapshot of %s written to %s: %d packages\n", snapshot.Device.Serial, *output, len(snapshot.Packages))
+	return nil
+}
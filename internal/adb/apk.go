@@ -0,0 +1,162 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// signaturesPattern extracts the signature digests from a dumpsys
+// "signatures=PackageSignatures{...}" line
+var signaturesPattern = regexp.MustCompile(`\[([0-9a-fA-F, ]+)\]`)
+
+// hiddenSystemPackages starts the section of a dumpsys package output that
+// lists the factory versions of updated system apps
+const hiddenSystemPackages = "Hidden system packages:"
+
+// PackageDetails holds the dumpsys facts needed to restore a package
+type PackageDetails struct {
+	Name        string
+	VersionName string
+	VersionCode string
+	CodePath    string
+	Signatures  []string
+	Permissions []string // granted install and runtime permissions
+}
+
+// GetPackageDetails returns version, code path, signatures and granted
+// permissions of a package
+func (c *Client) GetPackageDetails(pkg string) (*PackageDetails, error) {
+	output, err := c.output("shell", "dumpsys", "package", pkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package info: %w", err)
+	}
+
+	return parsePackageDetails(pkg, output), nil
+}
+
+// parsePackageDetails parses "dumpsys package" output. Updated system apps
+// list their factory version again under "Hidden system packages", which is
+// not read, and only the first occurrence of each field is kept.
+func parsePackageDetails(pkg string, output []byte) *PackageDetails {
+	details := &PackageDetails{Name: pkg}
+	permissions := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == hiddenSystemPackages {
+			break
+		}
+
+		if details.VersionName == "" && strings.HasPrefix(line, "versionName=") {
+			details.VersionName = strings.TrimPrefix(line, "versionName=")
+		}
+		if details.VersionCode == "" && strings.HasPrefix(line, "versionCode=") {
+			// "versionCode=340 minSdk=28 targetSdk=33", cut short in truncated output
+			if fields := strings.Fields(strings.TrimPrefix(line, "versionCode=")); len(fields) > 0 {
+				details.VersionCode = fields[0]
+			}
+		}
+		if details.CodePath == "" && strings.HasPrefix(line, "codePath=") {
+			details.CodePath = strings.TrimPrefix(line, "codePath=")
+		}
+		if details.Signatures == nil && strings.HasPrefix(line, "signatures=") {
+			if match := signaturesPattern.FindStringSubmatch(line); match != nil {
+				for _, sig := range strings.Split(match[1], ",") {
+					if sig = strings.TrimSpace(sig); sig != "" {
+						details.Signatures = append(details.Signatures, sig)
+					}
+				}
+			}
+		}
+		if name, rest, ok := strings.Cut(line, ": granted="); ok && strings.HasPrefix(rest, "true") {
+			permissions[name] = true
+		}
+	}
+
+	for name := range permissions {
+		details.Permissions = append(details.Permissions, name)
+	}
+	sort.Strings(details.Permissions)
+
+	return details
+}
+
+// GetPackagePaths returns the device paths of a package's base and split APKs
+func (c *Client) GetPackagePaths(pkg string, userID string) ([]string, error) {
+	output, err := c.output("shell", "pm", "path", "--user", userID, pkg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package path: %w", err)
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package:") {
+			paths = append(paths, strings.TrimPrefix(line, "package:"))
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no APK found for %s", pkg)
+	}
+
+	return paths, nil
+}
+
+// Pull copies a file from the device to a local path
+func (c *Client) Pull(remote string, local string) error {
+	output, err := c.combinedOutput("pull", remote, local)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w: %s", remote, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// InstallExisting reinstalls a package that is still present on the device
+// for another user or on the system partition
+func (c *Client) InstallExisting(pkg string, userID string) (bool, error) {
+	output, err := c.combinedOutput("shell", "pm", "install-existing", "--user", userID, pkg)
+	if err != nil {
+		return false, fmt.Errorf("failed to install-existing %s: %w", pkg, err)
+	}
+
+	if !strings.Contains(string(output), "installed for user") {
+		return false, nil
+	}
+
+	c.markInstalled(pkg, userID)
+	return true, nil
+}
+
+// EnablePackage re-enables a package that was disabled for a user
+func (c *Client) EnablePackage(pkg string, userID string) error {
+	output, err := c.combinedOutput("shell", "pm", "enable", "--user", userID, pkg)
+	if err != nil || !strings.Contains(string(output), "new state: enabled") {
+		return fmt.Errorf("failed to enable %s: %s", pkg, strings.TrimSpace(string(output)))
+	}
+
+	if c.inventory != nil && c.inventory.UserID == userID {
+		if rec := c.inventory.Packages[pkg]; rec != nil {
+			rec.Enabled = true
+		}
+	}
+	return nil
+}
+
+// InstallAPKs installs a package from local base and split APK files
+func (c *Client) InstallAPKs(files []string, userID string) error {
+	args := append([]string{"install-multiple", "-r", "--user", userID}, files...)
+	output, err := c.combinedOutput(args...)
+	if err != nil || !strings.Contains(string(output), "Success") {
+		return fmt.Errorf("failed to install %s: %s", strings.Join(files, ", "), strings.TrimSpace(string(output)))
+	}
+
+	c.inventory = nil
+	return nil
+}
@@ -0,0 +1,85 @@
+package adb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePackageDetails(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   *PackageDetails
+	}{
+		{
+			name: "updated system app",
+			output: `Packages:
+  Package [com.example.notes] (6a4f2d1):
+    userId=10123
+    pkg=Package{98c1e5e com.example.notes}
+    codePath=/data/app/~~kRb3YQ2r9Vx0aLm7nPq1Zw==/com.example.notes-Hc8dT1uWm4sEoN2yQvB6xg==
+    versionCode=340 minSdk=28 targetSdk=33
+    versionName=3.4.0
+    signatures=PackageSignatures{1b2a3c4 version:2, signatures:[9f3c2a1b], past signatures:[]}
+    install permissions:
+      android.permission.INTERNET: granted=true
+      android.permission.WAKE_LOCK: granted=true
+    User 0: ceDataInode=4182 installed=true hidden=false suspended=false distractionFlags=0 stopped=false notLaunched=false enabled=0 instant=false virtual=false
+      runtime permissions:
+        android.permission.POST_NOTIFICATIONS: granted=true, flags=[ USER_SET|USER_SENSITIVE_WHEN_GRANTED|USER_SENSITIVE_WHEN_DENIED]
+        android.permission.CAMERA: granted=false, flags=[ USER_SENSITIVE_WHEN_GRANTED|USER_SENSITIVE_WHEN_DENIED]
+
+Hidden system packages:
+  Package [com.example.notes] (2e7d9b0):
+    codePath=/system/app/Notes
+    versionCode=1 minSdk=28 targetSdk=33
+    versionName=1.0
+    signatures=PackageSignatures{77c0a11 version:2, signatures:[0000aaaa], past signatures:[]}
+    install permissions:
+      android.permission.READ_CONTACTS: granted=true
+`,
+			want: &PackageDetails{
+				Name:        "com.example.notes",
+				VersionName: "3.4.0",
+				VersionCode: "340",
+				CodePath:    "/data/app/~~kRb3YQ2r9Vx0aLm7nPq1Zw==/com.example.notes-Hc8dT1uWm4sEoN2yQvB6xg==",
+				Signatures:  []string{"9f3c2a1b"},
+				Permissions: []string{"android.permission.INTERNET", "android.permission.POST_NOTIFICATIONS", "android.permission.WAKE_LOCK"},
+			},
+		},
+		{
+			name:   "truncated after versionCode",
+			output: "Packages:\n  Package [com.example.notes] (6a4f2d1):\n    codePath=/system/app/Notes\n    versionCode=",
+			want:   &PackageDetails{Name: "com.example.notes", CodePath: "/system/app/Notes"},
+		},
+		{
+			name:   "empty versionCode followed by more fields",
+			output: "    versionCode=\n    versionCode=12 minSdk=21\n    versionName=\n",
+			want:   &PackageDetails{Name: "com.example.notes", VersionCode: "12"},
+		},
+		{
+			name:   "carriage returns and no signatures",
+			output: "    versionCode=7 minSdk=21 targetSdk=30\r\n    versionName=7.0\r\n    signatures=PackageSignatures{0 version:0, signatures:[], past signatures:[]}\r\n",
+			want:   &PackageDetails{Name: "com.example.notes", VersionCode: "7", VersionName: "7.0"},
+		},
+		{
+			name:   "unknown package",
+			output: "Unable to find package: com.example.notes\n",
+			want:   &PackageDetails{Name: "com.example.notes"},
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   &PackageDetails{Name: "com.example.notes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePackageDetails("com.example.notes", []byte(tt.output))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePackageDetails() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package adb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/logging"
+)
+
+const (
+	batchBegin = "__ADB_CLEANER_BEGIN__"
+	batchEnd   = "__ADB_CLEANER_END__"
+)
+
+// packageNamePattern guards the generated script against anything that is not
+// a plain Android package name
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// Action is an operation applied to a package
+type Action string
+
+const (
+	ActionUninstall Action = "uninstall"
+	ActionDisable   Action = "disable"
+)
+
+// ParseAction parses an action name, defaulting to uninstall when empty
+func ParseAction(name string) (Action, error) {
+	switch Action(name) {
+	case "", ActionUninstall:
+		return ActionUninstall, nil
+	case ActionDisable:
+		return ActionDisable, nil
+	}
+	return "", fmt.Errorf("unknown action %q", name)
+}
+
+// command returns the device shell command performing the action
+func (a Action) command(pkg string, userID string) (string, error) {
+	switch a {
+	case ActionUninstall:
+		return fmt.Sprintf("pm uninstall --user %s %s", userID, pkg), nil
+	case ActionDisable:
+		return fmt.Sprintf("pm disable-user --user %s %s", userID, pkg), nil
+	}
+	return "", fmt.Errorf("unknown action %q", a)
+}
+
+// succeeded reports whether the command output indicates success
+func (a Action) succeeded(output string) bool {
+	switch a {
+	case ActionUninstall:
+		return strings.Contains(output, "Success")
+	case ActionDisable:
+		return strings.Contains(output, "new state: disabled")
+	}
+	return false
+}
+
+// BatchCommand is a single package operation in a batch
+type BatchCommand struct {
+	Package string
+	Action  Action
+}
+
+// BatchResult is the outcome of a single batch command
+type BatchResult struct {
+	Package  string
+	Action   Action
+	Success  bool
+	ExitCode int
+	Output   string
+	Err      error
+	Duration time.Duration // time from sending the command to its exit code
+}
+
+// RunBatch executes the commands in one adb shell session. Each command is
+// wrapped in begin/end markers so its output and exit code can be matched back
+// to the package; onResult, if set, is called as each result arrives.
+func (c *Client) RunBatch(userID string, cmds []BatchCommand, onResult func(BatchResult)) ([]BatchResult, error) {
+	results := make([]BatchResult, len(cmds))
+	pending := make(map[int]bool)
+
+	commands := make([]string, len(cmds))
+	for i, bc := range cmds {
+		results[i] = BatchResult{Package: bc.Package, Action: bc.Action, ExitCode: -1}
+
+		if !packageNamePattern.MatchString(bc.Package) {
+			results[i].Err = fmt.Errorf("invalid package name %q", bc.Package)
+			continue
+		}
+		command, err := bc.Action.command(bc.Package, userID)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		commands[i] = command
+		pending[i] = true
+	}
+
+	token := batchToken(commands)
+	begin := fmt.Sprintf("%s %s ", batchBegin, token)
+	end := fmt.Sprintf("%s %s ", batchEnd, token)
+	var script strings.Builder
+	for i, command := range commands {
+		if !pending[i] {
+			continue
+		}
+		fmt.Fprintf(&script, "echo %s%d\n", begin, i)
+		// The session carries the rest of the script on stdin, so commands
+		// must not read from it
+		fmt.Fprintf(&script, "%s </dev/null 2>&1\n", command)
+		fmt.Fprintf(&script, "echo %s%d $?\n", end, i)
+	}
+	script.WriteString("exit\n")
+
+	// Report the commands rejected up front before anything runs
+	for i := range results {
+		if results[i].Err == nil {
+			continue
+		}
+		logBatchResult(results[i], userID)
+		if onResult != nil {
+			onResult(results[i])
+		}
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	current := -1
+	var started time.Time
+	var output strings.Builder
+	demux := &lineWriter{fn: func(line string) {
+		// Output without a trailing newline runs into the end marker
+		if j := strings.Index(line, end); j > 0 {
+			if current >= 0 {
+				output.WriteString(line[:j])
+				output.WriteString("\n")
+			}
+			line = line[j:]
+		}
+
+		switch {
+		case strings.HasPrefix(line, begin):
+			if i, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, begin))); err == nil && pending[i] {
+				current = i
+				started = time.Now()
+				output.Reset()
+			}
+		case strings.HasPrefix(line, end):
+			fields := strings.Fields(strings.TrimPrefix(line, end))
+			if current < 0 || len(fields) != 2 || fields[0] != strconv.Itoa(current) {
+				return
+			}
+			res := &results[current]
+			res.ExitCode, _ = strconv.Atoi(fields[1])
+			res.Duration = time.Since(started)
+			res.Output = strings.TrimSpace(output.String())
+			res.Success = res.ExitCode == 0 && res.Action.succeeded(res.Output)
+			if !res.Success {
+				res.Err = fmt.Errorf("failed to %s %s: %s", res.Action, res.Package, res.Output)
+			}
+			delete(pending, current)
+			logBatchResult(*res, userID)
+			c.applyBatchResult(*res, userID)
+			if onResult != nil {
+				onResult(*res)
+			}
+			current = -1
+		case current >= 0:
+			output.WriteString(line)
+			output.WriteString("\n")
+		}
+	}}
+
+	var stderr bytes.Buffer
+	runErr := c.run([]string{"shell"}, strings.NewReader(script.String()), demux, &stderr)
+	demux.Flush()
+
+	// Anything still pending never reported back, most likely because the
+	// session was cut short
+	for i := range results {
+		if !pending[i] {
+			continue
+		}
+		results[i].Err = fmt.Errorf("no result from device for %s", results[i].Package)
+		logBatchResult(results[i], userID)
+		if onResult != nil {
+			onResult(results[i])
+		}
+	}
+
+	if runErr != nil && len(pending) > 0 {
+		return results, fmt.Errorf("batch session failed: %w: %s", runErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return results, nil
+}
+
+// batchToken tags the markers of a session with a digest of its commands,
+// so output that only looks like a marker is never taken for one. The same
+// commands always get the same token, which keeps recorded sessions
+// replayable.
+func batchToken(commands []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(commands, "\n")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// logBatchResult records the outcome of one command of a batch session,
+// which is otherwise logged as a single adb shell invocation
+func logBatchResult(res BatchResult, userID string) {
+	attrs := []any{
+		"package", res.Package,
+		"action", res.Action,
+		"user", userID,
+		"duration", res.Duration,
+		"exitCode", res.ExitCode,
+		"output", logging.Truncate(res.Output, logOutputSize),
+	}
+	if !res.Success {
+		slog.Warn("package failed", append(attrs, "error", res.Err)...)
+		return
+	}
+	slog.Info("package done", attrs...)
+}
+
+// applyBatchResult keeps the cached inventory in line with a completed command
+func (c *Client) applyBatchResult(res BatchResult, userID string) {
+	if !res.Success {
+		return
+	}
+	switch res.Action {
+	case ActionUninstall:
+		c.markUninstalled(res.Package, userID)
+	case ActionDisable:
+		if c.inventory != nil && c.inventory.UserID == userID {
+			if rec := c.inventory.Packages[res.Package]; rec != nil {
+				rec.Enabled = false
+			}
+		}
+	}
+}
+
+// lineWriter calls fn for every complete line written to it
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes on a trailing line that was not terminated by a newline
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
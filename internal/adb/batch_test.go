@@ -0,0 +1,111 @@
+package adb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// scriptTransport answers the script of a batch session with canned output
+type scriptTransport struct {
+	script string
+	output string
+}
+
+func (t *scriptTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	t.script = string(data)
+	_, err = io.WriteString(stdout, t.output)
+	return err
+}
+
+func TestRunBatchDemux(t *testing.T) {
+	cmds := []BatchCommand{
+		{Package: "com.example.first", Action: ActionUninstall},
+		{Package: "com.example.second", Action: ActionUninstall},
+	}
+	token := batchToken([]string{
+		"pm uninstall --user 0 com.example.first",
+		"pm uninstall --user 0 com.example.second",
+	})
+	begin := func(i int) string { return fmt.Sprintf("%s %s %d\n", batchBegin, token, i) }
+	end := func(i, status int) string { return fmt.Sprintf("%s %s %d %d\n", batchEnd, token, i, status) }
+
+	tests := []struct {
+		name    string
+		output  string
+		success []bool
+		codes   []int
+		errs    []string
+	}{
+		{
+			name:    "both succeed",
+			output:  begin(0) + "Success\n" + end(0, 0) + begin(1) + "Success\n" + end(1, 0),
+			success: []bool{true, true},
+			codes:   []int{0, 0},
+		},
+		{
+			name:    "non-zero status",
+			output:  begin(0) + "Success\n" + end(0, 1) + begin(1) + "Failure [DELETE_FAILED_INTERNAL_ERROR]\n" + end(1, 1),
+			success: []bool{false, false},
+			codes:   []int{1, 1},
+			errs:    []string{"Success", "DELETE_FAILED_INTERNAL_ERROR"},
+		},
+		{
+			name:    "missing end marker",
+			output:  begin(0) + "Success\n" + end(0, 0) + begin(1) + "Success\n",
+			success: []bool{true, false},
+			codes:   []int{0, -1},
+			errs:    []string{"", "no result from device"},
+		},
+		{
+			name: "marker-like output",
+			output: begin(0) + batchEnd + " 0 0\n" + batchBegin + " 1\n" + batchEnd + " " + token + " 7 0\n" +
+				"Failure [DELETE_FAILED_INTERNAL_ERROR]\n" + end(0, 1) + begin(1) + "Success\n" + end(1, 0),
+			success: []bool{false, true},
+			codes:   []int{1, 0},
+			errs:    []string{"DELETE_FAILED_INTERNAL_ERROR", ""},
+		},
+		{
+			name:    "output without trailing newline",
+			output:  begin(0) + "Success" + end(0, 0) + begin(1) + "Success\n" + end(1, 0),
+			success: []bool{true, true},
+			codes:   []int{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &scriptTransport{output: tt.output}
+			c := NewClientWithTransport(transport)
+
+			results, err := c.RunBatch("0", cmds, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, res := range results {
+				if res.Success != tt.success[i] || res.ExitCode != tt.codes[i] {
+					t.Errorf("%s = success %v, exit %d; want %v, %d", res.Package, res.Success, res.ExitCode, tt.success[i], tt.codes[i])
+				}
+				wantErr := ""
+				if tt.errs != nil {
+					wantErr = tt.errs[i]
+				}
+				if (res.Err == nil) != (wantErr == "") || (res.Err != nil && !strings.Contains(res.Err.Error(), wantErr)) {
+					t.Errorf("%s error = %v, want %q", res.Package, res.Err, wantErr)
+				}
+			}
+
+			for _, cmd := range cmds {
+				want := fmt.Sprintf("pm uninstall --user 0 %s </dev/null 2>&1\n", cmd.Package)
+				if !strings.Contains(transport.script, want) {
+					t.Errorf("script does not keep %s from reading the session:\n%s", cmd.Package, transport.script)
+				}
+			}
+		})
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"os/exec"
 	"strings"
 )
 
 // Client represents an ADB client
 type Client struct {
-	adbPath string
+	transport Transport
+	serial    string
+	inventory *Inventory
 }
 
 // Device represents an Android device
@@ -19,6 +20,7 @@ type Device struct {
 	Manufacturer   string
 	Model          string
 	AndroidVersion string
+	Fingerprint    string
 	UserID         string
 }
 
@@ -27,31 +29,65 @@ func NewClient(adbPath string) *Client {
 	if adbPath == "" {
 		adbPath = "adb"
 	}
-	return &Client{adbPath: adbPath}
+	return NewClientWithTransport(&ExecTransport{Path: adbPath})
+}
+
+// NewClientWithTransport creates a new ADB client on top of a transport
+func NewClientWithTransport(transport Transport) *Client {
+	return &Client{transport: transport}
+}
+
+// WithSerial returns a client that targets the device with the given serial
+func (c *Client) WithSerial(serial string) *Client {
+	return &Client{transport: c.transport, serial: serial}
+}
+
+// Serial returns the serial of the targeted device, empty for the default one
+func (c *Client) Serial() string {
+	return c.serial
 }
 
 // IsAvailable checks if ADB is available
 func (c *Client) IsAvailable() bool {
-	cmd := exec.Command(c.adbPath, "version")
-	err := cmd.Run()
+	_, err := c.output("version")
 	return err == nil
 }
 
+// ListDevices returns the serials of connected and authorized devices
+func (c *Client) ListDevices() ([]string, error) {
+	output, err := c.output("devices")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check devices: %w", err)
+	}
+
+	var serials []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == "device" {
+			serials = append(serials, fields[0])
+		}
+	}
+
+	return serials, nil
+}
+
 // GetDevice returns the connected device information
 func (c *Client) GetDevice() (*Device, error) {
 	// Check if device is connected
-	cmd := exec.Command(c.adbPath, "devices")
-	output, err := cmd.Output()
+	serials, err := c.ListDevices()
 	if err != nil {
-		return nil, fmt.Errorf("failed to check devices: %w", err)
+		return nil, err
+	}
+
+	serial := c.serial
+	if serial == "" && len(serials) > 0 {
+		serial = serials[0]
 	}
 
-	// Parse devices output
-	scanner := bufio.NewScanner(bytes.NewReader(output))
 	found := false
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.Contains(line, "device") && !strings.HasPrefix(line, "List") {
+	for _, s := range serials {
+		if s == serial {
 			found = true
 			break
 		}
@@ -62,7 +98,7 @@ func (c *Client) GetDevice() (*Device, error) {
 	}
 
 	// Get device info
-	device := &Device{}
+	device := &Device{ID: serial}
 
 	// Get manufacturer
 	manufacturer, err := c.runShellCommand("getprop", "ro.product.manufacturer")
@@ -82,6 +118,12 @@ func (c *Client) GetDevice() (*Device, error) {
 		device.AndroidVersion = strings.TrimSpace(version)
 	}
 
+	// Get build fingerprint
+	fingerprint, err := c.runShellCommand("getprop", "ro.build.fingerprint")
+	if err == nil {
+		device.Fingerprint = strings.TrimSpace(fingerprint)
+	}
+
 	// Get user ID (default to 0)
 	device.UserID = "0"
 
@@ -90,8 +132,7 @@ func (c *Client) GetDevice() (*Device, error) {
 
 // ListPackages returns a list of installed packages
 func (c *Client) ListPackages() ([]string, error) {
-	cmd := exec.Command(c.adbPath, "shell", "pm", "list", "packages")
-	output, err := cmd.Output()
+	output, err := c.output("shell", "pm", "list", "packages")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list packages: %w", err)
 	}
@@ -111,8 +152,7 @@ func (c *Client) ListPackages() ([]string, error) {
 
 // ListSystemPackages returns only system packages
 func (c *Client) ListSystemPackages() ([]string, error) {
-	cmd := exec.Command(c.adbPath, "shell", "pm", "list", "packages", "-s")
-	output, err := cmd.Output()
+	output, err := c.output("shell", "pm", "list", "packages", "-s")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list system packages: %w", err)
 	}
@@ -132,8 +172,7 @@ func (c *Client) ListSystemPackages() ([]string, error) {
 
 // ListThirdPartyPackages returns only third-party packages
 func (c *Client) ListThirdPartyPackages() ([]string, error) {
-	cmd := exec.Command(c.adbPath, "shell", "pm", "list", "packages", "-3")
-	output, err := cmd.Output()
+	output, err := c.output("shell", "pm", "list", "packages", "-3")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list third-party packages: %w", err)
 	}
@@ -153,34 +192,34 @@ func (c *Client) ListThirdPartyPackages() ([]string, error) {
 
 // UninstallPackage removes a package for the current user
 func (c *Client) UninstallPackage(pkg string, userID string) (bool, error) {
-	cmd := exec.Command(c.adbPath, "shell", "pm", "uninstall", "--user", userID, pkg)
-	output, err := cmd.CombinedOutput()
+	output, err := c.combinedOutput("shell", "pm", "uninstall", "--user", userID, pkg)
 	if err != nil {
 		outputStr := string(output)
 		if strings.Contains(outputStr, "Success") {
+			c.markUninstalled(pkg, userID)
 			return true, nil
 		}
-		return false, fmt.Errorf("failed to uninstall %s: %w", pkg, err)
+		return false, fmt.Errorf("failed to uninstall %s: %w: %s", pkg, err, strings.TrimSpace(outputStr))
 	}
 
+	c.markUninstalled(pkg, userID)
 	return true, nil
 }
 
-// IsPackageInstalled checks if a package is installed
-func (c *Client) IsPackageInstalled(pkg string) (bool, error) {
-	cmd := exec.Command(c.adbPath, "shell", "pm", "list", "packages", pkg)
-	output, err := cmd.Output()
+// IsPackageInstalled checks if a package is installed for a user using the
+// cached inventory
+func (c *Client) IsPackageInstalled(pkg string, userID string) (bool, error) {
+	inv, err := c.Inventory(userID)
 	if err != nil {
 		return false, fmt.Errorf("failed to check package: %w", err)
 	}
 
-	return strings.Contains(string(output), "package:"+pkg), nil
+	return inv.IsInstalled(pkg), nil
 }
 
 // GetPackageInfo returns package information
 func (c *Client) GetPackageInfo(pkg string) (map[string]string, error) {
-	cmd := exec.Command(c.adbPath, "shell", "dumpsys", "package", pkg)
-	output, err := cmd.Output()
+	output, err := c.output("shell", "dumpsys", "package", pkg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get package info: %w", err)
 	}
@@ -209,8 +248,7 @@ func (c *Client) GetPackageInfo(pkg string) (map[string]string, error) {
 // runShellCommand executes a shell command on the device
 func (c *Client) runShellCommand(args ...string) (string, error) {
 	cmdArgs := append([]string{"shell"}, args...)
-	cmd := exec.Command(c.adbPath, cmdArgs...)
-	output, err := cmd.Output()
+	output, err := c.output(cmdArgs...)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,133 @@
+package adb_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/testutil"
+)
+
+// TestSession replays a session in the order it was recorded, so the steps
+// share one client and see the changes of the steps before them
+func TestSession(t *testing.T) {
+	c := adb.NewClientWithTransport(testutil.Session(t, "session.jsonl"))
+
+	steps := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"GetDevice", func(t *testing.T) {
+			device, err := c.GetDevice()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &adb.Device{
+				ID:             "fixture-phone",
+				Manufacturer:   "Fixture",
+				Model:          "Phone 1",
+				AndroidVersion: "13",
+				Fingerprint:    "fixture/phone1/13:user/release-keys",
+				UserID:         "0",
+			}
+			if !reflect.DeepEqual(device, want) {
+				t.Errorf("device = %+v, want %+v", device, want)
+			}
+		}},
+		{"listings", func(t *testing.T) {
+			listings := []struct {
+				name string
+				list func() ([]string, error)
+				want []string
+			}{
+				{"all", c.ListPackages, []string{"android", "com.example.admin", "com.example.bloat", "com.example.notes", "com.example.stuck"}},
+				{"system", c.ListSystemPackages, []string{"android", "com.example.admin", "com.example.bloat", "com.example.stuck"}},
+				{"third party", c.ListThirdPartyPackages, []string{"com.example.notes"}},
+			}
+			for _, tt := range listings {
+				got, err := tt.list()
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+				}
+			}
+		}},
+		{"Inventory", func(t *testing.T) {
+			inv, err := c.Inventory("0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inv.InstalledNames(); len(got) != 5 {
+				t.Errorf("installed = %v, want all 5 packages", got)
+			}
+			if rec := inv.Get("com.example.notes"); rec == nil || rec.System || rec.Installer != "com.android.vending" {
+				t.Errorf("com.example.notes = %+v, want a third-party package from com.android.vending", rec)
+			}
+		}},
+		{"UninstallPackage", func(t *testing.T) {
+			uninstalls := []struct {
+				pkg     string
+				want    bool
+				wantErr string
+			}{
+				{pkg: "com.example.bloat", want: true},
+				{pkg: "com.example.stuck", wantErr: "DELETE_FAILED_INTERNAL_ERROR"},
+			}
+			for _, tt := range uninstalls {
+				ok, err := c.UninstallPackage(tt.pkg, "0")
+				if ok != tt.want || (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+					t.Errorf("UninstallPackage(%s) = %v, %v; want %v, %q", tt.pkg, ok, err, tt.want, tt.wantErr)
+				}
+			}
+			if installed, _ := c.IsPackageInstalled("com.example.bloat", "0"); installed {
+				t.Error("com.example.bloat still installed in the cached inventory")
+			}
+		}},
+		{"RunBatch", func(t *testing.T) {
+			results, err := c.RunBatch("0", []adb.BatchCommand{
+				{Package: "com.example.notes", Action: adb.ActionUninstall},
+				{Package: "com.example.admin", Action: adb.ActionUninstall},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Success {
+				t.Errorf("com.example.notes = %+v, want success", results[0])
+			}
+			if results[1].Success || !strings.Contains(results[1].Output, "DELETE_FAILED_DEVICE_POLICY_MANAGER") {
+				t.Errorf("com.example.admin = %+v, want DELETE_FAILED_DEVICE_POLICY_MANAGER", results[1])
+			}
+		}},
+		{"restore", func(t *testing.T) {
+			ok, err := c.InstallExisting("com.example.bloat", "0")
+			if !ok || err != nil {
+				t.Fatalf("InstallExisting = %v, %v; want true", ok, err)
+			}
+
+			inv, err := c.RefreshInventory("0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			installed := map[string]bool{
+				"com.example.bloat": true,
+				"com.example.stuck": true,
+				"com.example.admin": true,
+				"com.example.notes": false,
+			}
+			for pkg, want := range installed {
+				if got := inv.IsInstalled(pkg); got != want {
+					t.Errorf("%s installed = %v, want %v", pkg, got, want)
+				}
+			}
+		}},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, step.run) {
+			break
+		}
+	}
+}
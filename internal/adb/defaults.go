@@ -0,0 +1,237 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Roles a device relies on a default app for. Keyboard and accessibility
+// are secure settings rather than roles but are held the same way.
+const (
+	RoleSMS           = "android.app.role.SMS"
+	RoleDialer        = "android.app.role.DIALER"
+	RoleBrowser       = "android.app.role.BROWSER"
+	RoleHome          = "android.app.role.HOME"
+	RoleKeyboard      = "keyboard"
+	RoleAccessibility = "accessibility"
+)
+
+// DefaultRoles are the roles whose holder cannot be removed without a
+// replacement, in the order they are checked
+var DefaultRoles = []string{RoleSMS, RoleDialer, RoleBrowser, RoleHome, RoleKeyboard}
+
+// roleIntents are the intents an app must handle to qualify for a role
+var roleIntents = map[string][]string{
+	RoleSMS:     {"-a", "android.intent.action.SENDTO", "-d", "smsto:"},
+	RoleDialer:  {"-a", "android.intent.action.DIAL"},
+	RoleBrowser: {"-a", "android.intent.action.VIEW", "-c", "android.intent.category.BROWSABLE", "-d", "http://"},
+	RoleHome:    {"-a", "android.intent.action.MAIN", "-c", "android.intent.category.HOME"},
+}
+
+// RoleLabel names a role the way the interface shows it
+func RoleLabel(role string) string {
+	switch role {
+	case RoleSMS:
+		return "SMS app"
+	case RoleDialer:
+		return "dialer"
+	case RoleBrowser:
+		return "browser"
+	case RoleHome:
+		return "home app"
+	case RoleKeyboard:
+		return "keyboard"
+	case RoleAccessibility:
+		return "accessibility service"
+	}
+	return strings.TrimPrefix(role, "android.app.role.")
+}
+
+// Defaults are the apps a user relies on: the role holders, the keyboard and
+// the enabled accessibility services
+type Defaults struct {
+	Roles         map[string][]string // role -> holding packages
+	Keyboard      string              // component of the default input method
+	Accessibility []string            // components of the enabled accessibility services
+}
+
+// Held returns the roles a package holds, in the order of DefaultRoles
+// followed by RoleAccessibility
+func (d *Defaults) Held(pkg string) []string {
+	if d == nil {
+		return nil
+	}
+
+	var held []string
+	for _, role := range DefaultRoles {
+		if role == RoleKeyboard {
+			if componentOf(d.Keyboard) == pkg {
+				held = append(held, role)
+			}
+			continue
+		}
+		for _, holder := range d.Roles[role] {
+			if holder == pkg {
+				held = append(held, role)
+			}
+		}
+	}
+	for _, service := range d.Accessibility {
+		if componentOf(service) == pkg {
+			held = append(held, RoleAccessibility)
+			break
+		}
+	}
+	return held
+}
+
+// componentOf returns the package of a component name such as
+// com.example/.Service
+func componentOf(component string) string {
+	pkg, _, _ := strings.Cut(component, "/")
+	return pkg
+}
+
+// Defaults reads the role holders and the secure settings naming the
+// keyboard and accessibility services of a user
+func (c *Client) Defaults(userID string) (*Defaults, error) {
+	d := &Defaults{Roles: make(map[string][]string)}
+	for _, role := range DefaultRoles {
+		if role == RoleKeyboard {
+			continue
+		}
+		holders, err := c.GetRoleHolders(role, userID)
+		if err != nil {
+			return nil, err
+		}
+		d.Roles[role] = holders
+	}
+
+	keyboard, err := c.SecureSetting("default_input_method", userID)
+	if err != nil {
+		return nil, err
+	}
+	d.Keyboard = keyboard
+
+	services, err := c.SecureSetting("enabled_accessibility_services", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range strings.Split(services, ":") {
+		if service = strings.TrimSpace(service); service != "" {
+			d.Accessibility = append(d.Accessibility, service)
+		}
+	}
+
+	return d, nil
+}
+
+// GetRoleHolders returns the packages holding a role for a user
+func (c *Client) GetRoleHolders(role string, userID string) ([]string, error) {
+	output, err := c.output("shell", "cmd", "role", "get-role-holders", "--user", userID, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get holders of %s: %w", role, err)
+	}
+
+	// Holders are separated by semicolons
+	var holders []string
+	for _, holder := range strings.FieldsFunc(string(output), func(r rune) bool {
+		return r == ';' || r == '\n' || r == '\r'
+	}) {
+		if holder = strings.TrimSpace(holder); holder != "" {
+			holders = append(holders, holder)
+		}
+	}
+	return holders, nil
+}
+
+// SecureSetting returns a secure setting of a user, empty when it is unset
+func (c *Client) SecureSetting(name string, userID string) (string, error) {
+	output, err := c.output("shell", "settings", "--user", userID, "get", "secure", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read setting %s: %w", name, err)
+	}
+
+	value := strings.TrimSpace(string(output))
+	if value == "null" {
+		return "", nil
+	}
+	return value, nil
+}
+
+// RoleCandidates returns what can take over a role for a user: packages
+// handling the intents of the role, or input method components for
+// RoleKeyboard
+func (c *Client) RoleCandidates(role string, userID string) ([]string, error) {
+	if role == RoleKeyboard {
+		output, err := c.output("shell", "ime", "list", "-s", "--user", userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list input methods: %w", err)
+		}
+		return parseComponents(output, false), nil
+	}
+
+	intent, ok := roleIntents[role]
+	if !ok {
+		return nil, fmt.Errorf("role %s has no replacement", role)
+	}
+	args := append([]string{"shell", "cmd", "package", "query-activities", "--brief", "--user", userID}, intent...)
+	output, err := c.output(args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query apps for %s: %w", role, err)
+	}
+	return parseComponents(output, true), nil
+}
+
+// parseComponents reads the component names of a listing, one per line,
+// returning their packages when packagesOnly is set
+func parseComponents(output []byte, packagesOnly bool) []string {
+	seen := make(map[string]bool)
+	var found []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.Contains(line, " ") || !strings.Contains(line, "/") {
+			continue
+		}
+		if packagesOnly {
+			line = componentOf(line)
+		}
+		if !seen[line] {
+			seen[line] = true
+			found = append(found, line)
+		}
+	}
+
+	sort.Strings(found)
+	return found
+}
+
+// SetRoleHolder makes a package or input method the default for a role.
+// Exclusive roles drop their previous holder.
+func (c *Client) SetRoleHolder(role string, holder string, userID string) error {
+	var args []string
+	switch role {
+	case RoleKeyboard:
+		args = []string{"shell", "ime", "set", "--user", userID, holder}
+	case RoleAccessibility:
+		return fmt.Errorf("accessibility services are not replaced")
+	default:
+		args = []string{"shell", "cmd", "role", "add-role-holder", "--user", userID, role, holder}
+	}
+
+	// Both commands may report a failure with a zero exit status
+	output, err := c.combinedOutput(args...)
+	text := strings.TrimSpace(string(output))
+	if err != nil {
+		return fmt.Errorf("failed to make %s the %s: %w: %s", holder, RoleLabel(role), err, text)
+	}
+	if strings.Contains(text, "Exception") || strings.Contains(text, "Unknown") {
+		return fmt.Errorf("failed to make %s the %s: %s", holder, RoleLabel(role), text)
+	}
+	return nil
+}
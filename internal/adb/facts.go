@@ -0,0 +1,162 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Intent actions whose handlers grant a package special powers
+const (
+	actionDeviceAdmin   = "android.app.action.DEVICE_ADMIN_ENABLED"
+	actionAccessibility = "android.accessibilityservice.AccessibilityService"
+)
+
+// PackageFacts is what the package manager reports about a package beyond
+// the package listing
+type PackageFacts struct {
+	SharedUser    string   // shared user ID, such as android.uid.system
+	OverlayTarget string   // package an overlay applies to
+	Authorities   []string // content provider authorities it declares
+	Flags         []string // pkgFlags and private flags, such as SYSTEM or PRIVILEGED
+	Signature     string   // signing certificate digests
+	DeviceAdmin   bool     // declares a device admin receiver
+	Accessibility bool     // declares an accessibility service
+}
+
+// HasFlag reports whether the package manager gave the package a flag
+func (f *PackageFacts) HasFlag(flag string) bool {
+	for _, f := range f.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// PackageFacts returns the facts about every package on the device, read
+// from a single dump of the package manager
+func (c *Client) PackageFacts() (map[string]*PackageFacts, error) {
+	output, err := c.output("shell", "dumpsys", "package")
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump packages: %w", err)
+	}
+	return parsePackageFacts(output), nil
+}
+
+// parsePackageFacts reads the package settings, the content provider
+// authorities and the resolver tables of a dumpsys package output
+func parsePackageFacts(output []byte) map[string]*PackageFacts {
+	facts := make(map[string]*PackageFacts)
+	get := func(pkg string) *PackageFacts {
+		if facts[pkg] == nil {
+			facts[pkg] = &PackageFacts{}
+		}
+		return facts[pkg]
+	}
+
+	section := ""
+	current := ""   // package whose settings are being read
+	authority := "" // authority whose provider is being read
+	action := ""    // intent action whose handlers are being read
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(raw, " ") {
+			section = line
+			current, authority, action = "", "", ""
+			continue
+		}
+
+		switch {
+		case section == hiddenSystemPackages:
+			// The factory versions of updated apps have flags of their own
+
+		case strings.HasPrefix(line, "Package [") && strings.HasSuffix(line, ":"):
+			// Package [com.example] (1a2b3c):
+			end := strings.Index(line, "]")
+			current = line[len("Package ["):end]
+			get(current)
+
+		case section == "ContentProvider Authorities:" && strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]:"):
+			authority = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]:")
+
+		case section == "ContentProvider Authorities:" && authority != "" && strings.HasPrefix(line, "Provider{"):
+			// Provider{58d7a3e com.example/.ExampleProvider}
+			if pkg := componentPackage(strings.TrimSuffix(strings.TrimPrefix(line, "Provider{"), "}")); pkg != "" {
+				f := get(pkg)
+				f.Authorities = append(f.Authorities, authority)
+			}
+			authority = ""
+
+		case strings.HasSuffix(section, "Resolver Table:"):
+			// android.app.action.DEVICE_ADMIN_ENABLED:
+			//   4c6f2a1 com.example/.AdminReceiver filter a1b2c3
+			if strings.HasSuffix(line, ":") && !strings.Contains(line, " ") {
+				action = strings.TrimSuffix(line, ":")
+				continue
+			}
+			pkg := componentPackage(line)
+			switch {
+			case pkg == "":
+			case action == actionDeviceAdmin && section == "Receiver Resolver Table:":
+				get(pkg).DeviceAdmin = true
+			case action == actionAccessibility && section == "Service Resolver Table:":
+				get(pkg).Accessibility = true
+			}
+
+		case current == "":
+
+		case strings.HasPrefix(line, "sharedUser="):
+			// sharedUser=SharedUserSetting{8e3c android.uid.phone/1001}
+			value := strings.TrimSuffix(strings.TrimPrefix(line, "sharedUser=SharedUserSetting{"), "}")
+			if fields := strings.Fields(value); len(fields) == 2 {
+				name, _, _ := strings.Cut(fields[1], "/")
+				get(current).SharedUser = name
+			}
+
+		case strings.HasPrefix(line, "overlayTarget="):
+			get(current).OverlayTarget = strings.TrimPrefix(line, "overlayTarget=")
+
+		case strings.HasPrefix(line, "pkgFlags="), strings.HasPrefix(line, "privatePkgFlags="), strings.HasPrefix(line, "privateFlags="):
+			// pkgFlags=[ SYSTEM HAS_CODE PERSISTENT ]
+			_, value, _ := strings.Cut(line, "=")
+			f := get(current)
+			f.Flags = append(f.Flags, strings.Fields(strings.Trim(value, "[]"))...)
+
+		case strings.HasPrefix(line, "signatures="):
+			// signatures=PackageSignatures{c1d2e3 version:3, signatures:[2a9b3e1c], past signatures:[]}
+			if _, rest, ok := strings.Cut(line, "signatures:["); ok {
+				digests, _, _ := strings.Cut(rest, "]")
+				get(current).Signature = digests
+			}
+		}
+	}
+
+	for _, f := range facts {
+		sort.Strings(f.Authorities)
+	}
+	return facts
+}
+
+// componentPackage returns the package of the component a resolver or
+// provider line names, such as "4c6f2a1 com.example/.Receiver filter 1a2b"
+func componentPackage(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ""
+	}
+	pkg, _, ok := strings.Cut(fields[1], "/")
+	if !ok {
+		return ""
+	}
+	return pkg
+}
@@ -0,0 +1,77 @@
+package adb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePackageFacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]*PackageFacts
+	}{
+		{
+			name: "updated system app",
+			output: `Packages:
+  Package [com.example.notes] (6a4f2d1):
+    sharedUser=SharedUserSetting{8e3c android.uid.phone/1001}
+    pkgFlags=[ SYSTEM HAS_CODE ]
+    privatePkgFlags=[ PRIVILEGED ]
+    signatures=PackageSignatures{1b2a3c4 version:2, signatures:[9f3c2a1b], past signatures:[]}
+
+Hidden system packages:
+  Package [com.example.notes] (2e7d9b0):
+    pkgFlags=[ SYSTEM PERSISTENT ]
+    privatePkgFlags=[ PRIVILEGED SIGNED_WITH_PLATFORM_KEY ]
+    signatures=PackageSignatures{77c0a11 version:2, signatures:[0000aaaa], past signatures:[]}
+`,
+			want: map[string]*PackageFacts{
+				"com.example.notes": {
+					SharedUser: "android.uid.phone",
+					Flags:      []string{"SYSTEM", "HAS_CODE", "PRIVILEGED"},
+					Signature:  "9f3c2a1b",
+				},
+			},
+		},
+		{
+			name: "only hidden",
+			output: `Hidden system packages:
+  Package [com.example.notes] (2e7d9b0):
+    pkgFlags=[ SYSTEM ]
+`,
+			want: map[string]*PackageFacts{},
+		},
+		{
+			name: "resolver tables and providers",
+			output: `Receiver Resolver Table:
+  Non-Data Actions:
+      android.app.action.DEVICE_ADMIN_ENABLED:
+        4c6f2a1 com.example.admin/.AdminReceiver filter a1b2c3
+
+Service Resolver Table:
+  Non-Data Actions:
+      android.accessibilityservice.AccessibilityService:
+        5d7e3b2 com.example.reader/.ReaderService filter b2c3d4
+
+ContentProvider Authorities:
+  [com.example.notes.provider]:
+    Provider{58d7a3e com.example.notes/.NotesProvider}
+`,
+			want: map[string]*PackageFacts{
+				"com.example.admin":  {DeviceAdmin: true},
+				"com.example.reader": {Accessibility: true},
+				"com.example.notes":  {Authorities: []string{"com.example.notes.provider"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePackageFacts([]byte(tt.output))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePackageFacts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package adb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inventorySection separates the listings produced by inventoryScript
+const inventorySection = "__ADB_CLEANER_SECTION__"
+
+// PackageRecord describes a package as reported by the package manager
+type PackageRecord struct {
+	Name      string
+	Path      string
+	Installer string
+	UID       int
+	System    bool
+	Enabled   bool
+	Installed bool // installed for the inventory user
+}
+
+// Inventory is a snapshot of the packages known to the device for one user
+type Inventory struct {
+	UserID   string
+	TakenAt  time.Time
+	Packages map[string]*PackageRecord
+}
+
+// Get returns the record for a package, or nil if the device does not know it
+func (inv *Inventory) Get(pkg string) *PackageRecord {
+	if inv == nil {
+		return nil
+	}
+	return inv.Packages[pkg]
+}
+
+// IsInstalled reports whether a package is installed for the inventory user
+func (inv *Inventory) IsInstalled(pkg string) bool {
+	rec := inv.Get(pkg)
+	return rec != nil && rec.Installed
+}
+
+// InstalledNames returns the sorted names of packages installed for the user
+func (inv *Inventory) InstalledNames() []string {
+	if inv == nil {
+		return nil
+	}
+
+	var names []string
+	for name, rec := range inv.Packages {
+		if rec.Installed {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Checksum returns a digest of the installed package names, so two
+// inventories can be compared without keeping the full listing
+func (inv *Inventory) Checksum() string {
+	h := sha256.New()
+	for _, name := range inv.InstalledNames() {
+		h.Write([]byte(name + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Inventory returns the cached package inventory for a user, taking it
+// from the device on first use
+func (c *Client) Inventory(userID string) (*Inventory, error) {
+	if c.inventory != nil && c.inventory.UserID == userID {
+		return c.inventory, nil
+	}
+	return c.RefreshInventory(userID)
+}
+
+// RefreshInventory takes a fresh package inventory for a user in a single
+// adb round-trip and replaces the cached one
+func (c *Client) RefreshInventory(userID string) (*Inventory, error) {
+	inv, err := c.takeInventory(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.inventory = inv
+	return inv, nil
+}
+
+// takeInventory lists the packages of a user without touching the cache
+func (c *Client) takeInventory(userID string) (*Inventory, error) {
+	output, err := c.runShellCommand(inventoryScript(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages: %w", err)
+	}
+	return parseInventory(output, userID)
+}
+
+// inventoryScript lists the full inventory including packages uninstalled
+// for the user, followed by the installed, disabled and system subsets used
+// to flag each record
+func inventoryScript(userID string) string {
+	listings := []string{
+		"pm list packages -f -i -U -u --user " + userID,
+		"pm list packages --user " + userID,
+		"pm list packages -d --user " + userID,
+		"pm list packages -s -u --user " + userID,
+	}
+	return strings.Join(listings, "; echo "+inventorySection+"; ")
+}
+
+// parseInventory parses the output of inventoryScript
+func parseInventory(output string, userID string) (*Inventory, error) {
+	sections := make([][]string, 0, 4)
+	current := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == inventorySection {
+			sections = append(sections, current)
+			current = []string{}
+			continue
+		}
+		if strings.HasPrefix(line, "package:") {
+			current = append(current, line)
+		}
+	}
+	sections = append(sections, current)
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading package list: %w", err)
+	}
+	if len(sections) != 4 {
+		return nil, fmt.Errorf("unexpected package list output: %d sections", len(sections))
+	}
+
+	inv := &Inventory{
+		UserID:   userID,
+		TakenAt:  time.Now(),
+		Packages: make(map[string]*PackageRecord),
+	}
+
+	for _, line := range sections[0] {
+		rec := parsePackageLine(line)
+		if rec.Name != "" {
+			rec.Enabled = true
+			inv.Packages[rec.Name] = rec
+		}
+	}
+
+	flag := func(lines []string, set func(*PackageRecord)) {
+		for _, line := range lines {
+			if rec := inv.Packages[parsePackageLine(line).Name]; rec != nil {
+				set(rec)
+			}
+		}
+	}
+	flag(sections[1], func(rec *PackageRecord) { rec.Installed = true })
+	flag(sections[2], func(rec *PackageRecord) { rec.Enabled = false })
+	flag(sections[3], func(rec *PackageRecord) { rec.System = true })
+
+	return inv, nil
+}
+
+// parsePackageLine parses a single "pm list packages" line such as
+// "package:/data/app/~~x==/com.foo-y==/base.apk=com.foo  installer=null uid:10123"
+func parsePackageLine(line string) *PackageRecord {
+	fields := strings.Fields(strings.TrimPrefix(line, "package:"))
+	if len(fields) == 0 {
+		return &PackageRecord{}
+	}
+
+	rec := &PackageRecord{Name: fields[0]}
+
+	// APK paths may contain '=' themselves, the package name follows the last one
+	if i := strings.LastIndex(fields[0], "="); i >= 0 {
+		rec.Path = fields[0][:i]
+		rec.Name = fields[0][i+1:]
+	}
+
+	for _, field := range fields[1:] {
+		switch {
+		case strings.HasPrefix(field, "installer="):
+			if installer := strings.TrimPrefix(field, "installer="); installer != "null" {
+				rec.Installer = installer
+			}
+		case strings.HasPrefix(field, "uid:"):
+			if uid, err := strconv.Atoi(strings.TrimPrefix(field, "uid:")); err == nil {
+				rec.UID = uid
+			}
+		}
+	}
+
+	return rec
+}
+
+// markUninstalled updates the cached inventory after a package was removed
+func (c *Client) markUninstalled(pkg string, userID string) {
+	if c.inventory == nil || c.inventory.UserID != userID {
+		return
+	}
+	if rec := c.inventory.Packages[pkg]; rec != nil {
+		rec.Installed = false
+	}
+}
+
+// markInstalled updates the cached inventory after a package was restored
+func (c *Client) markInstalled(pkg string, userID string) {
+	if c.inventory == nil || c.inventory.UserID != userID {
+		return
+	}
+	if rec := c.inventory.Packages[pkg]; rec != nil {
+		rec.Installed = true
+	}
+}
@@ -0,0 +1,231 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotRecorded is returned by a replay for a command the session it
+// replays never ran
+var ErrNotRecorded = errors.New("command was not recorded")
+
+// Interaction is one adb invocation of a recorded session
+type Interaction struct {
+	Args     []string `json:"args"`
+	Stdin    string   `json:"stdin,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exitCode"`
+	Error    string   `json:"error,omitempty"` // failure to run adb at all, such as a missing executable
+}
+
+// key identifies the command of an interaction
+func (i *Interaction) key() string {
+	return strings.Join(i.Args, "\x00") + "\x00\x00" + i.Stdin
+}
+
+// RecordingTransport runs adb through another transport and appends each
+// invocation to a fixture file, one JSON interaction per line
+type RecordingTransport struct {
+	inner Transport
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecordingTransport records the invocations of inner to filename,
+// appending to a previous recording
+func NewRecordingTransport(inner Transport, filename string) (*RecordingTransport, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &RecordingTransport{inner: inner, file: file}, nil
+}
+
+// Run runs adb and records the invocation once it exits
+func (t *RecordingTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	in := &Interaction{Args: append([]string(nil), args...)}
+
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		in.Stdin = string(data)
+		stdin = bytes.NewReader(data)
+	}
+
+	// A shared writer stays shared so both streams keep their order
+	var out, errOut lockedBuffer
+	recordOut := io.Writer(&out)
+	if stdout != nil {
+		recordOut = io.MultiWriter(stdout, &out)
+	}
+	recordErr := recordOut
+	if stdout != stderr {
+		recordErr = &errOut
+		if stderr != nil {
+			recordErr = io.MultiWriter(stderr, &errOut)
+		}
+	}
+
+	err := t.inner.Run(args, stdin, recordOut, recordErr)
+	in.Stdout = out.String()
+	in.Stderr = errOut.String()
+	if err != nil {
+		if in.ExitCode = exitCode(err); in.ExitCode < 0 {
+			in.Error = err.Error()
+		}
+	}
+
+	if recErr := t.record(in); recErr != nil {
+		return errors.Join(err, recErr)
+	}
+	return err
+}
+
+// record appends an interaction to the fixture file
+func (t *RecordingTransport) record(in *Interaction) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %w", strings.Join(in.Args, " "), err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to record %s: %w", strings.Join(in.Args, " "), err)
+	}
+	return nil
+}
+
+// Close closes the fixture file
+func (t *RecordingTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
+
+// lockedBuffer is a buffer that standard output and error may be written to
+// concurrently
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// ReplayTransport serves the outputs of a recorded session instead of
+// running adb. A command run several times gets its recorded results in
+// order, then the last one again, so state changes such as an uninstall
+// show up in later listings.
+type ReplayTransport struct {
+	mu      sync.Mutex
+	pending map[string][]*Interaction // command key -> results not served yet
+	last    map[string]*Interaction   // command key -> last result served
+}
+
+// LoadReplay reads a session recorded by RecordingTransport
+func LoadReplay(filename string) (*ReplayTransport, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	var interactions []*Interaction
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		in := &Interaction{}
+		if err := json.Unmarshal(scanner.Bytes(), in); err != nil {
+			return nil, fmt.Errorf("%s:%d: failed to parse interaction: %w", filename, lineNo, err)
+		}
+		interactions = append(interactions, in)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	return NewReplayTransport(interactions), nil
+}
+
+// NewReplayTransport replays interactions in the order they were recorded
+func NewReplayTransport(interactions []*Interaction) *ReplayTransport {
+	t := &ReplayTransport{
+		pending: make(map[string][]*Interaction),
+		last:    make(map[string]*Interaction),
+	}
+	for _, in := range interactions {
+		t.pending[in.key()] = append(t.pending[in.key()], in)
+	}
+	return t
+}
+
+// Run writes the recorded output of the command and returns its recorded
+// outcome
+func (t *ReplayTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	in := &Interaction{Args: args}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		in.Stdin = string(data)
+	}
+
+	recorded := t.next(in.key())
+	if recorded == nil {
+		return fmt.Errorf("%w: adb %s", ErrNotRecorded, strings.Join(args, " "))
+	}
+
+	if stdout != nil {
+		io.WriteString(stdout, recorded.Stdout)
+	}
+	if stderr != nil {
+		io.WriteString(stderr, recorded.Stderr)
+	}
+
+	switch {
+	case recorded.Error != "":
+		return errors.New(recorded.Error)
+	case recorded.ExitCode != 0:
+		return &ExitError{Code: recorded.ExitCode}
+	}
+	return nil
+}
+
+// next returns the result to serve for a command, or nil
+func (t *ReplayTransport) next(key string) *Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if queue := t.pending[key]; len(queue) > 0 {
+		t.pending[key] = queue[1:]
+		t.last[key] = queue[0]
+	}
+	return t.last[key]
+}
@@ -0,0 +1,720 @@
+package adb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Simulator is an in-memory Android device behind the adb transport. It
+// answers the commands the client sends the way a device running the
+// snapshot would, including the failures of the package manager, and keeps
+// the changes a run makes.
+type Simulator struct {
+	mu       sync.Mutex
+	snapshot *Snapshot
+	packages map[string]*SimPackage
+}
+
+// NewSimulator creates a simulated device from a copy of a snapshot.
+// Packages without per-user state are installed and enabled for every user.
+func NewSimulator(snapshot *Snapshot) *Simulator {
+	s := &Simulator{snapshot: snapshot.clone(), packages: make(map[string]*SimPackage)}
+	if len(s.snapshot.Users) == 0 {
+		s.snapshot.Users = []string{"0"}
+	}
+	for _, p := range s.snapshot.Packages {
+		if p.Users == nil {
+			p.Users = make(map[string]*SimUserState)
+			for _, user := range s.snapshot.Users {
+				p.Users[user] = &SimUserState{Installed: true, Enabled: true}
+			}
+		}
+		s.packages[p.Name] = p
+	}
+	return s
+}
+
+// Snapshot returns a copy of the current state of the simulated device
+func (s *Simulator) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot.clone()
+}
+
+// Run answers one adb invocation
+func (s *Simulator) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(args) >= 2 && args[0] == "-s" {
+		if args[1] != s.snapshot.Device.Serial {
+			fmt.Fprintf(stderr, "adb: device '%s' not found\n", args[1])
+			return &ExitError{Code: 1}
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return &ExitError{Code: 1}
+	}
+
+	var status int
+	switch args[0] {
+	case "version":
+		fmt.Fprintln(stdout, "Android Debug Bridge version 1.0.41 (simulated device)")
+	case "devices":
+		fmt.Fprintf(stdout, "List of devices attached\n%s\tdevice\n", s.snapshot.Device.Serial)
+	case "pull":
+		fmt.Fprintf(stderr, "adb: error: failed to stat remote object '%s': simulated devices hold no files\n", strings.Join(args[1:2], ""))
+		status = 1
+	case "install-multiple":
+		fmt.Fprintln(stdout, "Failure [INSTALL_FAILED_INVALID_APK: simulated devices do not install APK files]")
+		status = 1
+	case "shell":
+		script := strings.Join(args[1:], " ")
+		if len(args) == 1 && stdin != nil {
+			data, err := io.ReadAll(stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read input: %w", err)
+			}
+			script = string(data)
+		}
+		status = s.shell(script, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "adb: unknown command %s\n", args[0])
+		status = 1
+	}
+
+	if status != 0 {
+		return &ExitError{Code: status}
+	}
+	return nil
+}
+
+// shell runs a script of simple commands separated by newlines or
+// semicolons, with echo expanding $? to the status of the previous command
+func (s *Simulator) shell(script string, stdout, stderr io.Writer) int {
+	status := 0
+	for _, line := range strings.Split(script, "\n") {
+		for _, command := range strings.Split(line, ";") {
+			var fields []string
+			for _, field := range strings.Fields(command) {
+				// Redirections make no difference to the simulated commands
+				if field != "2>&1" && !strings.HasPrefix(field, "<") {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "exit":
+				return status
+			case "echo":
+				fmt.Fprintln(stdout, strings.ReplaceAll(strings.Join(fields[1:], " "), "$?", strconv.Itoa(status)))
+				status = 0
+			default:
+				status = s.exec(fields, stdout, stderr)
+			}
+		}
+	}
+	return status
+}
+
+// exec runs one device command and returns its exit status
+func (s *Simulator) exec(fields []string, stdout, stderr io.Writer) int {
+	name, args := fields[0], fields[1:]
+	switch name {
+	case "getprop":
+		if len(args) > 0 {
+			fmt.Fprintln(stdout, s.prop(args[0]))
+		}
+		return 0
+	case "pm":
+		return s.pm(args, stdout)
+	case "cmd":
+		return s.cmd(args, stdout)
+	case "ime":
+		return s.ime(args, stdout)
+	case "settings":
+		return s.settings(args, stdout)
+	case "dumpsys":
+		return s.dumpsys(args, stdout)
+	case "ps":
+		s.ps(stdout)
+		return 0
+	case "df":
+		// Snapshots taken without storage figures cannot report any
+		if s.snapshot.DataFree == 0 {
+			break
+		}
+		fmt.Fprintln(stdout, "Filesystem       1K-blocks    Used Available Use% Mounted on")
+		fmt.Fprintf(stdout, "/dev/block/dm-5  %9d %7d %9d  50%% /data\n", 2*s.snapshot.DataFree/1024, s.snapshot.DataFree/1024, s.snapshot.DataFree/1024)
+		return 0
+	case "cat":
+		if len(args) == 1 && args[0] == "/proc/meminfo" && s.snapshot.MemAvailable != 0 {
+			fmt.Fprintf(stdout, "MemTotal:        %d kB\nMemAvailable:    %d kB\n", 2*s.snapshot.MemAvailable/1024, s.snapshot.MemAvailable/1024)
+			return 0
+		}
+	case "du":
+		s.du(args, stdout)
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "/system/bin/sh: %s: inaccessible or not found\n", name)
+	return 127
+}
+
+// prop returns a system property of the simulated device
+func (s *Simulator) prop(name string) string {
+	device := s.snapshot.Device
+	switch name {
+	case "ro.product.manufacturer":
+		return device.Manufacturer
+	case "ro.product.model":
+		return device.Model
+	case "ro.build.version.release":
+		return device.AndroidVersion
+	case "ro.build.fingerprint":
+		return device.Fingerprint
+	}
+	return ""
+}
+
+// simArgs are the options and operands of a device command
+type simArgs struct {
+	user     string
+	flags    map[string]bool
+	operands []string
+}
+
+// parseSimArgs splits the arguments of a command, taking --user and its
+// value apart. Commands act on user 0 when no user is given.
+func parseSimArgs(args []string) simArgs {
+	parsed := simArgs{user: "0", flags: make(map[string]bool)}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--user" && i+1 < len(args):
+			parsed.user = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "-"):
+			parsed.flags[args[i]] = true
+		default:
+			parsed.operands = append(parsed.operands, args[i])
+		}
+	}
+	return parsed
+}
+
+// operand returns the nth operand, or an empty string
+func (a simArgs) operand(n int) string {
+	if n < len(a.operands) {
+		return a.operands[n]
+	}
+	return ""
+}
+
+// state returns the state of a package for a user, nil when the package is
+// unknown
+func (s *Simulator) state(pkg, user string) *SimUserState {
+	p := s.packages[pkg]
+	if p == nil {
+		return nil
+	}
+	if p.Users[user] == nil {
+		p.Users[user] = &SimUserState{Enabled: true}
+	}
+	return p.Users[user]
+}
+
+// installed reports whether a package is installed for a user
+func (s *Simulator) installed(pkg, user string) bool {
+	state := s.state(pkg, user)
+	return state != nil && state.Installed
+}
+
+// onDevice reports whether a package is still on the device: a system app,
+// or an app installed for at least one user
+func (p *SimPackage) onDevice() bool {
+	if p.System {
+		return true
+	}
+	for _, state := range p.Users {
+		if state.Installed {
+			return true
+		}
+	}
+	return false
+}
+
+// running reports whether a package has processes, which it has while it is
+// installed and enabled for some user and takes memory
+func (p *SimPackage) running() bool {
+	if p.Memory == 0 {
+		return false
+	}
+	for _, state := range p.Users {
+		if state.Installed && state.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the packages ordered by name
+func (s *Simulator) sorted() []*SimPackage {
+	packages := make([]*SimPackage, 0, len(s.packages))
+	for _, p := range s.packages {
+		packages = append(packages, p)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages
+}
+
+// pm runs a package manager command
+func (s *Simulator) pm(args []string, stdout io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "Error: no command given")
+		return 255
+	}
+	a := parseSimArgs(args[1:])
+
+	switch args[0] {
+	case "list":
+		if a.operand(0) != "packages" {
+			break
+		}
+		s.listPackages(a, stdout)
+		return 0
+	case "path":
+		p := s.packages[a.operand(0)]
+		if p == nil || !s.installed(p.Name, a.user) {
+			return 1
+		}
+		fmt.Fprintf(stdout, "package:%s\n", p.Path)
+		return 0
+	case "uninstall":
+		return s.uninstall(a.operand(0), a.user, stdout)
+	case "disable-user":
+		return s.disable(a.operand(0), a.user, stdout)
+	case "enable":
+		state := s.state(a.operand(0), a.user)
+		if state == nil || !state.Installed {
+			fmt.Fprintf(stdout, "Error: java.lang.IllegalArgumentException: Unknown package: %s\n", a.operand(0))
+			return 255
+		}
+		state.Enabled = true
+		fmt.Fprintf(stdout, "Package %s new state: enabled\n", a.operand(0))
+		return 0
+	case "install-existing":
+		p := s.packages[a.operand(0)]
+		if p == nil || !p.onDevice() {
+			fmt.Fprintf(stdout, "android.content.pm.PackageManager$NameNotFoundException: Package %s doesn't exist\n", a.operand(0))
+			return 1
+		}
+		state := s.state(p.Name, a.user)
+		if !state.Installed && !state.Enabled {
+			state.Enabled = true
+		}
+		if !state.Installed {
+			state.Installed = true
+			s.snapshot.DataFree -= p.Cache + p.Data
+		}
+		fmt.Fprintf(stdout, "Package %s installed for user: %s\n", p.Name, a.user)
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "Error: unknown command '%s'\n", args[0])
+	return 255
+}
+
+// listPackages prints the packages a pm list packages command asks for
+func (s *Simulator) listPackages(a simArgs, stdout io.Writer) {
+	for _, p := range s.sorted() {
+		state := s.state(p.Name, a.user)
+		switch {
+		case !p.onDevice():
+			continue
+		case !state.Installed && !a.flags["-u"]:
+			continue
+		case a.flags["-s"] && !p.System, a.flags["-3"] && p.System:
+			continue
+		case a.flags["-d"] && state.Enabled, a.flags["-e"] && !state.Enabled:
+			continue
+		}
+
+		line := "package:" + p.Name
+		if a.flags["-f"] {
+			line = "package:" + p.Path + "=" + p.Name
+		}
+		if a.flags["-i"] {
+			installer := p.Installer
+			if installer == "" {
+				installer = "null"
+			}
+			line += "  installer=" + installer
+		}
+		if a.flags["-U"] {
+			line += fmt.Sprintf(" uid:%d", p.UID)
+		}
+		fmt.Fprintln(stdout, line)
+	}
+}
+
+// uninstall removes a package for a user, failing the way the package
+// manager does for packages it refuses to remove
+func (s *Simulator) uninstall(pkg, user string, stdout io.Writer) int {
+	p := s.packages[pkg]
+	switch {
+	case p == nil || !s.installed(pkg, user):
+		fmt.Fprintf(stdout, "Failure [not installed for %s]\n", user)
+		return 1
+	case p.Fail != "":
+		fmt.Fprintf(stdout, "Failure [%s]\n", p.Fail)
+		return 1
+	case p.DeviceAdmin:
+		fmt.Fprintln(stdout, "Failure [DELETE_FAILED_DEVICE_POLICY_MANAGER]")
+		return 1
+	case p.Protected:
+		fmt.Fprintln(stdout, "Failure [DELETE_FAILED_INTERNAL_ERROR]")
+		return 1
+	}
+
+	wasRunning := p.running()
+	p.Users[user].Installed = false
+	s.snapshot.DataFree += p.Cache + p.Data
+	if !p.onDevice() {
+		s.snapshot.DataFree += p.Code
+	}
+	if wasRunning && !p.running() {
+		s.snapshot.MemAvailable += p.Memory
+	}
+	s.release(pkg, user)
+
+	fmt.Fprintln(stdout, "Success")
+	return 0
+}
+
+// disable disables a package for a user
+func (s *Simulator) disable(pkg, user string, stdout io.Writer) int {
+	p := s.packages[pkg]
+	switch {
+	case p == nil || !s.installed(pkg, user):
+		fmt.Fprintf(stdout, "Error: java.lang.IllegalArgumentException: Unknown package: %s\n", pkg)
+		return 255
+	case p.Fail != "":
+		fmt.Fprintf(stdout, "Error: java.lang.IllegalStateException: %s\n", p.Fail)
+		return 255
+	case p.DeviceAdmin || p.Protected:
+		fmt.Fprintf(stdout, "Error: java.lang.SecurityException: Cannot disable a protected package: %s\n", pkg)
+		return 255
+	}
+
+	wasRunning := p.running()
+	p.Users[user].Enabled = false
+	if wasRunning && !p.running() {
+		s.snapshot.MemAvailable += p.Memory
+	}
+	s.release(pkg, user)
+
+	fmt.Fprintf(stdout, "Package %s new state: disabled-user\n", pkg)
+	return 0
+}
+
+// release drops the roles and settings a removed package held for a user
+func (s *Simulator) release(pkg, user string) {
+	for role, holders := range s.snapshot.Roles[user] {
+		s.snapshot.Roles[user][role] = slices.DeleteFunc(holders, func(h string) bool { return h == pkg })
+	}
+	settings := s.snapshot.Settings[user]
+	if componentOf(settings["default_input_method"]) == pkg {
+		delete(settings, "default_input_method")
+	}
+	if services := settings["enabled_accessibility_services"]; services != "" {
+		kept := slices.DeleteFunc(strings.Split(services, ":"), func(c string) bool { return componentOf(c) == pkg })
+		settings["enabled_accessibility_services"] = strings.Join(kept, ":")
+	}
+}
+
+// canHold reports whether a package can take a role for a user
+func (s *Simulator) canHold(pkg, role, user string) bool {
+	p := s.packages[pkg]
+	state := s.state(pkg, user)
+	return p != nil && state.Installed && state.Enabled && slices.Contains(p.Qualifies, role)
+}
+
+// cmd runs a role or package service command
+func (s *Simulator) cmd(args []string, stdout io.Writer) int {
+	if len(args) < 2 {
+		fmt.Fprintln(stdout, "Error: no service given")
+		return 255
+	}
+	a := parseSimArgs(args[2:])
+
+	switch args[0] + " " + args[1] {
+	case "role get-role-holders":
+		fmt.Fprintln(stdout, strings.Join(s.snapshot.Roles[a.user][a.operand(0)], ";"))
+		return 0
+	case "role add-role-holder":
+		role, pkg := a.operand(0), a.operand(1)
+		if !s.canHold(pkg, role, a.user) {
+			fmt.Fprintf(stdout, "Error: java.lang.IllegalArgumentException: Unknown package or role: %s %s\n", pkg, role)
+			return 255
+		}
+		if s.snapshot.Roles[a.user] == nil {
+			s.snapshot.Roles[a.user] = make(map[string][]string)
+		}
+		s.snapshot.Roles[a.user][role] = []string{pkg}
+		return 0
+	case "package query-activities":
+		for role, intent := range roleIntents {
+			if !slices.Equal(intent, stripSimUser(args[2:])) {
+				continue
+			}
+			for _, p := range s.sorted() {
+				if s.canHold(p.Name, role, a.user) {
+					fmt.Fprintf(stdout, "%s/.Main\n", p.Name)
+				}
+			}
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "Error: unknown command '%s %s'\n", args[0], args[1])
+	return 255
+}
+
+// stripSimUser returns the intent arguments of a query, without --brief and
+// --user
+func stripSimUser(args []string) []string {
+	var intent []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--brief":
+		case "--user":
+			i++
+		default:
+			intent = append(intent, args[i])
+		}
+	}
+	return intent
+}
+
+// ime lists and selects input methods
+func (s *Simulator) ime(args []string, stdout io.Writer) int {
+	if len(args) == 0 {
+		return 255
+	}
+	a := parseSimArgs(args[1:])
+
+	switch args[0] {
+	case "list":
+		for _, p := range s.sorted() {
+			if s.canHold(p.Name, RoleKeyboard, a.user) {
+				fmt.Fprintf(stdout, "%s/.InputMethod\n", p.Name)
+			}
+		}
+		return 0
+	case "set":
+		id := a.operand(0)
+		if !s.canHold(componentOf(id), RoleKeyboard, a.user) {
+			fmt.Fprintf(stdout, "Unknown input method %s cannot be selected for user #%s\n", id, a.user)
+			return 0
+		}
+		if s.snapshot.Settings[a.user] == nil {
+			s.snapshot.Settings[a.user] = make(map[string]string)
+		}
+		s.snapshot.Settings[a.user]["default_input_method"] = id
+		fmt.Fprintf(stdout, "Input method %s selected for user #%s\n", id, a.user)
+		return 0
+	}
+	return 255
+}
+
+// settings reads secure settings
+func (s *Simulator) settings(args []string, stdout io.Writer) int {
+	a := parseSimArgs(args)
+	if a.operand(0) != "get" {
+		return 255
+	}
+	value, ok := s.snapshot.Settings[a.user][a.operand(2)]
+	if !ok || value == "" {
+		value = "null"
+	}
+	fmt.Fprintln(stdout, value)
+	return 0
+}
+
+// dumpsys prints the parts of the system service dumps the client reads
+func (s *Simulator) dumpsys(args []string, stdout io.Writer) int {
+	if len(args) == 0 {
+		return 0
+	}
+
+	switch args[0] {
+	case "package":
+		if len(args) > 1 {
+			s.dumpPackage(args[1], stdout)
+		} else {
+			s.dumpPackages(stdout)
+		}
+	case "diskstats":
+		var names []string
+		var code, data, cache []int64
+		for _, p := range s.sorted() {
+			if p.onDevice() {
+				names = append(names, p.Name)
+				code = append(code, p.Code)
+				data = append(data, p.Data)
+				cache = append(cache, p.Cache)
+			}
+		}
+		for _, field := range []struct {
+			label string
+			value any
+		}{{"Package Names", names}, {"App Sizes", code}, {"App Data Sizes", data}, {"Cache Sizes", cache}} {
+			encoded, _ := json.Marshal(field.value)
+			fmt.Fprintf(stdout, "%s: %s\n", field.label, encoded)
+		}
+	case "meminfo":
+		fmt.Fprintln(stdout, "Total PSS by process:")
+		pid := 1000
+		for _, p := range s.sorted() {
+			if p.running() {
+				pid++
+				fmt.Fprintf(stdout, "    %sK: %s (pid %d)\n", formatThousands(p.Memory/1024), p.Name, pid)
+			}
+		}
+		fmt.Fprintln(stdout)
+	}
+	return 0
+}
+
+// dumpPackages prints the package settings and the device admin and
+// accessibility handlers of every package
+func (s *Simulator) dumpPackages(stdout io.Writer) {
+	fmt.Fprintln(stdout, "Receiver Resolver Table:")
+	fmt.Fprintln(stdout, "  Non-Data Actions:")
+	fmt.Fprintf(stdout, "      %s:\n", actionDeviceAdmin)
+	for _, p := range s.sorted() {
+		if p.DeviceAdmin && p.onDevice() {
+			fmt.Fprintf(stdout, "        0 %s/.AdminReceiver filter 0\n", p.Name)
+		}
+	}
+	fmt.Fprintln(stdout, "Service Resolver Table:")
+	fmt.Fprintln(stdout, "  Non-Data Actions:")
+	fmt.Fprintf(stdout, "      %s:\n", actionAccessibility)
+	for _, p := range s.sorted() {
+		if p.Accessibility && p.onDevice() {
+			fmt.Fprintf(stdout, "        0 %s/.AccessibilityService filter 0\n", p.Name)
+		}
+	}
+
+	fmt.Fprintln(stdout, "Packages:")
+	for _, p := range s.sorted() {
+		if p.onDevice() {
+			s.writePackage(p, stdout)
+		}
+	}
+}
+
+// dumpPackage prints the settings of one package
+func (s *Simulator) dumpPackage(pkg string, stdout io.Writer) {
+	if p := s.packages[pkg]; p != nil && p.onDevice() {
+		fmt.Fprintln(stdout, "Packages:")
+		s.writePackage(p, stdout)
+	}
+}
+
+// writePackage prints the settings block of a package
+func (s *Simulator) writePackage(p *SimPackage, stdout io.Writer) {
+	fmt.Fprintf(stdout, "  Package [%s] (0):\n", p.Name)
+	fmt.Fprintf(stdout, "    userId=%d\n", p.UID)
+	if p.SharedUser != "" {
+		fmt.Fprintf(stdout, "    sharedUser=SharedUserSetting{0 %s/%d}\n", p.SharedUser, p.UID)
+	}
+	if p.OverlayTarget != "" {
+		fmt.Fprintf(stdout, "    overlayTarget=%s\n", p.OverlayTarget)
+	}
+	fmt.Fprintf(stdout, "    codePath=%s\n", path.Dir(p.Path))
+	versionName, versionCode := p.VersionName, p.VersionCode
+	if versionName == "" {
+		versionName, versionCode = "1.0", "1"
+	}
+	fmt.Fprintf(stdout, "    versionCode=%s minSdk=28 targetSdk=33\n", versionCode)
+	fmt.Fprintf(stdout, "    versionName=%s\n", versionName)
+
+	flags := p.Flags
+	if p.System && !slices.Contains(flags, "SYSTEM") {
+		flags = append([]string{"SYSTEM"}, flags...)
+	}
+	fmt.Fprintf(stdout, "    pkgFlags=[ %s ]\n", strings.Join(flags, " "))
+	if p.Signature != "" {
+		fmt.Fprintf(stdout, "    signatures=PackageSignatures{0 version:3, signatures:[%s], past signatures:[]}\n", p.Signature)
+	}
+}
+
+// ps lists the processes of the running packages
+func (s *Simulator) ps(stdout io.Writer) {
+	fmt.Fprintln(stdout, "USER           PID  PPID     VSZ    RSS WCHAN            ADDR S NAME")
+	pid := 1000
+	for _, p := range s.sorted() {
+		if p.running() {
+			pid++
+			fmt.Fprintf(stdout, "u0_a%-9d %5d   700 1000000 %6d 0                   0 S %s\n", p.UID%100000, pid, p.Memory/1024, p.Name)
+		}
+	}
+}
+
+// du prints the size of the directories holding package APKs
+func (s *Simulator) du(args []string, stdout io.Writer) {
+	for _, dir := range args {
+		if strings.HasPrefix(dir, "-") {
+			continue
+		}
+		var size int64
+		for _, p := range s.packages {
+			if path.Dir(p.Path) == dir {
+				size += p.Code
+			}
+		}
+		fmt.Fprintf(stdout, "%d\t%s\n", size/1024, dir)
+	}
+}
+
+// formatThousands formats a number with comma separators, as dumpsys does
+func formatThousands(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
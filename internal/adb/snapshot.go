@@ -0,0 +1,331 @@
+package adb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Snapshot is the state of a device a Simulator plays back: its users, its
+// packages with their per-user state, the default apps and the free storage
+// and memory
+type Snapshot struct {
+	Device   SnapshotDevice                 `json:"device"`
+	Users    []string                       `json:"users"`
+	Packages []*SimPackage                  `json:"packages"`
+	Roles    map[string]map[string][]string `json:"roles,omitempty"`    // user -> role -> holders
+	Settings map[string]map[string]string   `json:"settings,omitempty"` // user -> secure setting -> value
+
+	DataFree     int64 `json:"dataFree,omitempty"`
+	MemAvailable int64 `json:"memAvailable,omitempty"`
+}
+
+// SnapshotDevice identifies the device a snapshot was taken from
+type SnapshotDevice struct {
+	Serial         string `json:"serial"`
+	Manufacturer   string `json:"manufacturer,omitempty"`
+	Model          string `json:"model,omitempty"`
+	AndroidVersion string `json:"androidVersion,omitempty"`
+	Fingerprint    string `json:"fingerprint,omitempty"`
+}
+
+// SimPackage is a package of a simulated device
+type SimPackage struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Installer string `json:"installer,omitempty"`
+	UID       int    `json:"uid,omitempty"`
+	System    bool   `json:"system,omitempty"`
+
+	Flags         []string `json:"flags,omitempty"`
+	SharedUser    string   `json:"sharedUser,omitempty"`
+	OverlayTarget string   `json:"overlayTarget,omitempty"`
+	Signature     string   `json:"signature,omitempty"`
+	DeviceAdmin   bool     `json:"deviceAdmin,omitempty"`   // uninstall fails with DELETE_FAILED_DEVICE_POLICY_MANAGER
+	Accessibility bool     `json:"accessibility,omitempty"` // declares an accessibility service
+	Protected     bool     `json:"protected,omitempty"`     // the package manager refuses to remove or disable it
+	Fail          string   `json:"fail,omitempty"`          // failure code every removal reports, such as DELETE_FAILED_INTERNAL_ERROR
+	Qualifies     []string `json:"qualifies,omitempty"`     // roles it can take over
+
+	VersionName string `json:"versionName,omitempty"`
+	VersionCode string `json:"versionCode,omitempty"`
+
+	Code   int64 `json:"code,omitempty"`
+	Data   int64 `json:"data,omitempty"`
+	Cache  int64 `json:"cache,omitempty"`
+	Memory int64 `json:"memory,omitempty"`
+
+	// Users holds the state of the package per user. A package without it
+	// is installed and enabled for every user.
+	Users map[string]*SimUserState `json:"users,omitempty"`
+}
+
+// SimUserState is the state of a package for one user
+type SimUserState struct {
+	Installed bool `json:"installed"`
+	Enabled   bool `json:"enabled"`
+}
+
+// LoadSnapshot reads a snapshot file
+func LoadSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", filename, err)
+	}
+	if snapshot.Device.Serial == "" {
+		return nil, fmt.Errorf("snapshot %s names no device serial", filename)
+	}
+	return &snapshot, nil
+}
+
+// Write writes the snapshot as JSON
+func (s *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return nil
+}
+
+// Save writes the snapshot to a file
+func (s *Snapshot) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer file.Close()
+
+	if err := s.Write(file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// clone returns a deep copy of the snapshot, so a simulation never changes
+// the snapshot it was started from
+func (s *Snapshot) clone() *Snapshot {
+	c := *s
+	c.Users = slices.Clone(s.Users)
+
+	c.Packages = make([]*SimPackage, len(s.Packages))
+	for i, p := range s.Packages {
+		cp := *p
+		cp.Flags = slices.Clone(p.Flags)
+		cp.Qualifies = slices.Clone(p.Qualifies)
+		if p.Users != nil {
+			cp.Users = make(map[string]*SimUserState, len(p.Users))
+			for user, state := range p.Users {
+				st := *state
+				cp.Users[user] = &st
+			}
+		}
+		c.Packages[i] = &cp
+	}
+
+	c.Roles = make(map[string]map[string][]string, len(s.Roles))
+	for user, roles := range s.Roles {
+		c.Roles[user] = make(map[string][]string, len(roles))
+		for role, holders := range roles {
+			c.Roles[user][role] = slices.Clone(holders)
+		}
+	}
+	c.Settings = make(map[string]map[string]string, len(s.Settings))
+	for user, settings := range s.Settings {
+		c.Settings[user] = maps.Clone(settings)
+	}
+	return &c
+}
+
+// Snapshot takes the state of the device for the given users, the first of
+// which is used to find the apps that can take over a role. The cached
+// inventory is left alone. Storage and memory are left out when the device
+// does not report them.
+func (c *Client) Snapshot(users []string) (*Snapshot, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users to snapshot")
+	}
+	// A simulated device hands over its own state, failures included
+	if sim, ok := c.transport.(*Simulator); ok {
+		return sim.Snapshot(), nil
+	}
+
+	device, err := c.GetDevice()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newSnapshot(device, users)
+	snapshot.Roles = make(map[string]map[string][]string)
+	snapshot.Settings = make(map[string]map[string]string)
+
+	byName := make(map[string]*SimPackage)
+	for _, user := range users {
+		inv, err := c.takeInventory(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range inv.Packages {
+			addSimPackage(byName, rec, user)
+		}
+
+		defaults, err := c.Defaults(user)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Roles[user] = make(map[string][]string)
+		for role, holders := range defaults.Roles {
+			if len(holders) > 0 {
+				snapshot.Roles[user][role] = holders
+			}
+		}
+		snapshot.Settings[user] = make(map[string]string)
+		if defaults.Keyboard != "" {
+			snapshot.Settings[user]["default_input_method"] = defaults.Keyboard
+		}
+		if len(defaults.Accessibility) > 0 {
+			snapshot.Settings[user]["enabled_accessibility_services"] = strings.Join(defaults.Accessibility, ":")
+		}
+	}
+
+	facts, err := c.PackageFacts()
+	if err != nil {
+		return nil, err
+	}
+	applyFacts(byName, facts)
+
+	for _, role := range DefaultRoles {
+		candidates, err := c.RoleCandidates(role, users[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			if p := byName[componentOf(candidate)]; p != nil {
+				p.Qualifies = append(p.Qualifies, role)
+			}
+		}
+	}
+
+	c.applyUsage(snapshot, byName)
+	return snapshot, nil
+}
+
+// RunSnapshot takes only the state a run of the given packages plays out
+// on: their per-user state, the facts that make the package manager refuse
+// to remove them, and the storage and memory they free. The device and the
+// facts are passed in by a caller that has read them already, and the cached
+// inventory is used for its user.
+func (c *Client) RunSnapshot(device *Device, users []string, pkgs []string, facts map[string]*PackageFacts) (*Snapshot, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users to snapshot")
+	}
+	if sim, ok := c.transport.(*Simulator); ok {
+		return sim.Snapshot(), nil
+	}
+
+	snapshot := newSnapshot(device, users)
+	byName := make(map[string]*SimPackage)
+	for _, user := range users {
+		inv := c.inventory
+		if inv == nil || inv.UserID != user {
+			var err error
+			if inv, err = c.takeInventory(user); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range pkgs {
+			if rec := inv.Get(name); rec != nil {
+				addSimPackage(byName, rec, user)
+			}
+		}
+	}
+	applyFacts(byName, facts)
+
+	c.applyUsage(snapshot, byName)
+	return snapshot, nil
+}
+
+// newSnapshot starts a snapshot of a device for the given users
+func newSnapshot(device *Device, users []string) *Snapshot {
+	return &Snapshot{
+		Device: SnapshotDevice{
+			Serial:         device.ID,
+			Manufacturer:   device.Manufacturer,
+			Model:          device.Model,
+			AndroidVersion: device.AndroidVersion,
+			Fingerprint:    device.Fingerprint,
+		},
+		Users: slices.Clone(users),
+	}
+}
+
+// addSimPackage records the state of a package for one user
+func addSimPackage(byName map[string]*SimPackage, rec *PackageRecord, user string) {
+	p := byName[rec.Name]
+	if p == nil {
+		p = &SimPackage{
+			Name:      rec.Name,
+			Path:      rec.Path,
+			Installer: rec.Installer,
+			UID:       rec.UID,
+			Users:     make(map[string]*SimUserState),
+		}
+		byName[rec.Name] = p
+	}
+	p.System = p.System || rec.System
+	p.Users[user] = &SimUserState{Installed: rec.Installed, Enabled: rec.Enabled}
+}
+
+// applyFacts marks the packages the package manager treats specially
+func applyFacts(byName map[string]*SimPackage, facts map[string]*PackageFacts) {
+	for name, f := range facts {
+		if p := byName[name]; p != nil {
+			p.Flags = f.Flags
+			p.SharedUser = f.SharedUser
+			p.OverlayTarget = f.OverlayTarget
+			p.Signature = f.Signature
+			p.DeviceAdmin = f.DeviceAdmin
+			p.Accessibility = f.Accessibility
+		}
+	}
+
+	// The framework itself cannot be removed for a user
+	if p := byName["android"]; p != nil {
+		p.Protected = true
+	}
+}
+
+// applyUsage fills in the sizes of the packages and the free storage and
+// memory of the device when the device reports them, then lists the
+// packages in the snapshot
+func (c *Client) applyUsage(snapshot *Snapshot, byName map[string]*SimPackage) {
+	if usage, err := c.PackageUsage(); err == nil {
+		for name, u := range usage {
+			if p := byName[name]; p != nil {
+				p.Code, p.Data, p.Cache = u.Code, u.Data, u.Cache
+				if u.Running {
+					p.Memory = u.Memory
+				}
+			}
+		}
+	}
+	if usage, err := c.DeviceUsage(); err == nil {
+		snapshot.DataFree = usage.DataFree
+		snapshot.MemAvailable = usage.MemAvailable
+	}
+
+	for _, p := range byName {
+		snapshot.Packages = append(snapshot.Packages, p)
+	}
+	sort.Slice(snapshot.Packages, func(i, j int) bool { return snapshot.Packages[i].Name < snapshot.Packages[j].Name })
+}
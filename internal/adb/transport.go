@@ -0,0 +1,140 @@
+package adb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/logging"
+)
+
+// Transport runs adb with the given arguments
+type Transport interface {
+	Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// ExecTransport runs the adb executable as a child process
+type ExecTransport struct {
+	Path string
+}
+
+// Run executes adb and waits for it to exit
+func (t *ExecTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cmd := exec.Command(t.Path, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// logOutputSize is how much of the output of an adb invocation is logged
+const logOutputSize = 512
+
+// run runs adb against the client's device and logs the invocation
+func (c *Client) run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if c.serial != "" && len(args) > 0 && args[0] != "devices" && args[0] != "version" {
+		args = append([]string{"-s", c.serial}, args...)
+	}
+
+	// Keep the start of the output for the log while passing it on. A
+	// shared writer stays shared so exec keeps both streams on one pipe.
+	captured := &limitedBuffer{max: logOutputSize + 1}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	sameWriter := stdout == stderr
+	stdout = io.MultiWriter(stdout, captured)
+	if sameWriter {
+		stderr = stdout
+	} else if stderr != nil {
+		stderr = io.MultiWriter(stderr, captured)
+	} else {
+		stderr = captured
+	}
+
+	started := time.Now()
+	err := c.transport.Run(args, stdin, stdout, stderr)
+
+	attrs := []any{
+		"args", args,
+		"duration", time.Since(started),
+		"exitCode", exitCode(err),
+		"output", logging.Truncate(captured.String(), logOutputSize),
+	}
+	if err != nil {
+		slog.Warn("adb failed", append(attrs, "error", err)...)
+	} else {
+		slog.Info("adb", attrs...)
+	}
+	return err
+}
+
+// exitCode returns the exit status of a finished command, 0 on success and
+// -1 when it did not run to completion
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// ExitError is the failure of a replayed or simulated command that exited
+// with a non-zero status
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit status
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// limitedBuffer keeps the first max bytes written to it. Standard output
+// and error may be written concurrently.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// output runs adb and returns its standard output
+func (c *Client) output(args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	err := c.run(args, nil, &stdout, nil)
+	return stdout.Bytes(), err
+}
+
+// combinedOutput runs adb and returns its standard output and error
+func (c *Client) combinedOutput(args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	err := c.run(args, nil, &out, &out)
+	return out.Bytes(), err
+}
@@ -0,0 +1,271 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// PackageUsage is the storage and memory a package takes, in bytes
+type PackageUsage struct {
+	Code    int64 `json:"code"`
+	Data    int64 `json:"data"`
+	Cache   int64 `json:"cache"`
+	Memory  int64 `json:"memory"` // proportional set size of its processes
+	Running bool  `json:"running"`
+}
+
+// Storage returns the storage the package takes
+func (u *PackageUsage) Storage() int64 {
+	return u.Code + u.Data + u.Cache
+}
+
+// DeviceUsage is the free storage and memory of a device, in bytes
+type DeviceUsage struct {
+	DataFree     int64 `json:"dataFree"`
+	MemAvailable int64 `json:"memAvailable"`
+}
+
+// PackageUsage returns the storage and memory every package takes. Sizes
+// come from the last storage scan of the system; memory and running state
+// are current.
+func (c *Client) PackageUsage() (map[string]*PackageUsage, error) {
+	output, err := c.output("shell", "dumpsys", "diskstats")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk stats: %w", err)
+	}
+	usage, err := parseDiskStats(output)
+	if err != nil {
+		return nil, err
+	}
+	get := func(pkg string) *PackageUsage {
+		if usage[pkg] == nil {
+			usage[pkg] = &PackageUsage{}
+		}
+		return usage[pkg]
+	}
+
+	output, err = c.output("shell", "dumpsys", "meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	for pkg, memory := range parseMemInfo(output) {
+		get(pkg).Memory = memory
+	}
+
+	output, err = c.output("shell", "ps", "-A")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	for _, pkg := range parseProcesses(output) {
+		get(pkg).Running = true
+	}
+
+	return usage, nil
+}
+
+// parseDiskStats reads the per-package sizes of a dumpsys diskstats output,
+// given as JSON arrays in the same order as the package names
+func parseDiskStats(output []byte) (map[string]*PackageUsage, error) {
+	var names []string
+	var code, data, cache []int64
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		label, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch label {
+		case "Package Names":
+			err = json.Unmarshal([]byte(value), &names)
+		case "App Sizes":
+			err = json.Unmarshal([]byte(value), &code)
+		case "App Data Sizes":
+			err = json.Unmarshal([]byte(value), &data)
+		case "Cache Sizes":
+			err = json.Unmarshal([]byte(value), &cache)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse disk stats %s: %w", label, err)
+		}
+	}
+
+	usage := make(map[string]*PackageUsage, len(names))
+	for i, name := range names {
+		u := &PackageUsage{}
+		if i < len(code) {
+			u.Code = code[i]
+		}
+		if i < len(data) {
+			u.Data = data[i]
+		}
+		if i < len(cache) {
+			u.Cache = cache[i]
+		}
+		usage[name] = u
+	}
+	return usage, nil
+}
+
+// parseMemInfo sums the proportional set size of the processes of each
+// package, read from the "Total PSS by process" section of dumpsys meminfo
+func parseMemInfo(output []byte) map[string]int64 {
+	memory := make(map[string]int64)
+	inSection := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Total PSS by process") {
+			inSection = true
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if line == "" {
+			break
+		}
+
+		// 123,456K: com.example:remote (pid 1234 / activities)
+		size, rest, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.NewReplacer(",", "", "K", "").Replace(size), 10, 64)
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		memory[processPackage(fields[0])] += kb * 1024
+	}
+	return memory
+}
+
+// parseProcesses returns the packages of the running processes of a ps
+// listing, whose last column is the process name
+func parseProcesses(output []byte) []string {
+	var running []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "USER" {
+			continue
+		}
+		pkg := processPackage(fields[len(fields)-1])
+		if !seen[pkg] {
+			seen[pkg] = true
+			running = append(running, pkg)
+		}
+	}
+	return running
+}
+
+// processPackage returns the package of a process name such as
+// com.example:remote
+func processPackage(process string) string {
+	pkg, _, _ := strings.Cut(process, ":")
+	return pkg
+}
+
+// CodeSize measures the directories holding the APKs of a package, for
+// packages the last storage scan does not list
+func (c *Client) CodeSize(pkg string, userID string) (int64, error) {
+	paths, err := c.GetPackagePaths(pkg, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	dirs := make(map[string]bool)
+	args := []string{"shell", "du", "-sk"}
+	for _, p := range paths {
+		if dir := path.Dir(p); !dirs[dir] {
+			dirs[dir] = true
+			args = append(args, dir)
+		}
+	}
+
+	output, err := c.output(args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", pkg, err)
+	}
+
+	var total int64
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if kb, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			total += kb * 1024
+		}
+	}
+	return total, nil
+}
+
+// DeviceUsage reads the free space of the data partition and the available
+// memory
+func (c *Client) DeviceUsage() (*DeviceUsage, error) {
+	output, err := c.output("shell", "df", "-k", "/data")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read free storage: %w", err)
+	}
+	usage := &DeviceUsage{}
+
+	// Filesystem 1K-blocks Used Available Use% Mounted on
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if fields := strings.Fields(lines[len(lines)-1]); len(fields) >= 4 {
+		if kb, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+			usage.DataFree = kb * 1024
+		}
+	}
+
+	output, err = c.output("shell", "cat", "/proc/meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// MemAvailable:    1234567 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				usage.MemAvailable = kb * 1024
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// FormatBytes formats a size with a binary unit, such as "12.3 MB"
+func FormatBytes(size int64) string {
+	const unit = 1024
+	sign := ""
+	if size < 0 {
+		sign, size = "-", -size
+	}
+	if size < unit {
+		return fmt.Sprintf("%s%d B", sign, size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %cB", sign, float64(size)/float64(div), "KMGTPE"[exp])
+}
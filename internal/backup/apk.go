@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+const manifestFile = "manifest.json"
+
+// APKManifest describes the APK files saved for one package
+type APKManifest struct {
+	Package     string    `json:"package"`
+	VersionName string    `json:"versionName"`
+	VersionCode string    `json:"versionCode"`
+	CodePath    string    `json:"codePath"`
+	Signatures  []string  `json:"signatures"`
+	Permissions []string  `json:"permissions"`
+	Files       []string  `json:"files"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// APKDir returns the directory holding the APK backup of a package
+func APKDir(backupDir string, pkg string) string {
+	return filepath.Join(backupDir, "apk", pkg)
+}
+
+// SaveAPKs pulls the base and split APKs of a package into the backup
+// directory and writes a manifest next to them
+func SaveAPKs(client *adb.Client, backupDir string, pkg string, userID string) (*APKManifest, error) {
+	details, err := client.GetPackageDetails(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := client.GetPackagePaths(pkg, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := APKDir(backupDir, pkg)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	manifest := &APKManifest{
+		Package:     pkg,
+		VersionName: details.VersionName,
+		VersionCode: details.VersionCode,
+		CodePath:    details.CodePath,
+		Signatures:  details.Signatures,
+		Permissions: details.Permissions,
+		CreatedAt:   time.Now(),
+	}
+
+	for _, remote := range paths {
+		name := path.Base(remote)
+		if err := client.Pull(remote, filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// LoadAPKManifest reads the manifest of a package's APK backup
+func LoadAPKManifest(backupDir string, pkg string) (*APKManifest, error) {
+	data, err := os.ReadFile(filepath.Join(APKDir(backupDir, pkg), manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	manifest := &APKManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Restore brings a removed package back. It first tries install-existing,
+// which is enough while the APK is still on the device, and falls back to
+// reinstalling the saved APK files.
+func Restore(client *adb.Client, backupDir string, pkg string, userID string) error {
+	if ok, err := client.InstallExisting(pkg, userID); err == nil && ok {
+		return nil
+	}
+
+	manifest, err := LoadAPKManifest(backupDir, pkg)
+	if err != nil {
+		return fmt.Errorf("cannot restore %s: no APK backup: %w", pkg, err)
+	}
+
+	files := make([]string, len(manifest.Files))
+	for i, name := range manifest.Files {
+		files[i] = filepath.Join(APKDir(backupDir, pkg), name)
+	}
+
+	return client.InstallAPKs(files, userID)
+}
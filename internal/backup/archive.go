@@ -0,0 +1,547 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// ManifestVersion is the version of the archive manifest format
+const ManifestVersion = 1
+
+// ArchiveExt is the file extension of backup archives
+const ArchiveExt = ".tar.gz"
+
+// ErrDeviceMismatch is returned when an archive was taken on another device
+var ErrDeviceMismatch = errors.New("backup was taken on a different device")
+
+// ErrCorruptArchive is returned when the files of an archive do not match
+// the checksums of its manifest
+var ErrCorruptArchive = errors.New("backup archive does not match its manifest")
+
+// Package results recorded in a manifest
+const (
+	ResultSelected = "selected"
+	ResultSuccess  = "success"
+	ResultFailed   = "failed"
+	ResultSkipped  = "skipped"
+	ResultDryRun   = "dry-run"
+)
+
+// DeviceInfo identifies the device a backup was taken on
+type DeviceInfo struct {
+	Serial         string `json:"serial"`
+	Manufacturer   string `json:"manufacturer"`
+	Model          string `json:"model"`
+	AndroidVersion string `json:"androidVersion"`
+	Fingerprint    string `json:"fingerprint"`
+}
+
+// NewDeviceInfo captures the identity of a connected device
+func NewDeviceInfo(device *adb.Device) DeviceInfo {
+	return DeviceInfo{
+		Serial:         device.ID,
+		Manufacturer:   device.Manufacturer,
+		Model:          device.Model,
+		AndroidVersion: device.AndroidVersion,
+		Fingerprint:    device.Fingerprint,
+	}
+}
+
+// Matches reports whether the backup device is the given device. The serial
+// is preferred since the build fingerprint is shared by identical phones.
+func (d DeviceInfo) Matches(device *adb.Device) bool {
+	if device == nil {
+		return false
+	}
+	if d.Serial != "" && device.ID != "" {
+		return d.Serial == device.ID
+	}
+	return d.Fingerprint != "" && d.Fingerprint == device.Fingerprint
+}
+
+// PackSource records a package list used for the run
+type PackSource struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// PackageResult records what happened to one package
+type PackageResult struct {
+	Package     string       `json:"package"`
+	Description string       `json:"description,omitempty"`
+	Category    string       `json:"category,omitempty"`
+	RiskLevel   string       `json:"riskLevel,omitempty"`
+	Source      string       `json:"source,omitempty"`
+	Action      string       `json:"action"`
+	Result      string       `json:"result"`
+	Error       string       `json:"error,omitempty"`
+	Score       int          `json:"score,omitempty"`      // risk score when the run started
+	Reasons     []string     `json:"reasons,omitempty"`    // what makes up the score, none when unscored
+	DurationMS  int64        `json:"durationMs,omitempty"` // time the device took to act on it
+	APK         *APKManifest `json:"apk,omitempty"`
+}
+
+// Manifest describes one run stored in a backup archive
+type Manifest struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Device    DeviceInfo        `json:"device"`
+	UserID    string            `json:"userId"`
+	DryRun    bool              `json:"dryRun"`
+	Packs     []PackSource      `json:"packs"`
+	Packages  []PackageResult   `json:"packages"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// Counts returns the number of packages per result
+func (m *Manifest) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, res := range m.Packages {
+		counts[res.Result]++
+	}
+	return counts
+}
+
+// Run collects everything belonging to one run and writes it as a single
+// archive. APKs are staged in a temporary directory until then.
+type Run struct {
+	Manifest *Manifest
+	staging  string
+}
+
+// NewRun starts a run on a device
+func NewRun(device *adb.Device, userID string) (*Run, error) {
+	staging, err := os.MkdirTemp("", "adb-cleaner-run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return &Run{
+		Manifest: &Manifest{
+			Version:   ManifestVersion,
+			CreatedAt: time.Now(),
+			Device:    NewDeviceInfo(device),
+			UserID:    userID,
+			Checksums: make(map[string]string),
+		},
+		staging: staging,
+	}, nil
+}
+
+// NewPackSource records a package list and its checksum
+func NewPackSource(filename string) (PackSource, error) {
+	sum, err := fileChecksum(filename)
+	if err != nil {
+		return PackSource{}, err
+	}
+	return PackSource{Path: filename, SHA256: sum}, nil
+}
+
+// AddPack records a package list and its checksum
+func (r *Run) AddPack(filename string) error {
+	source, err := NewPackSource(filename)
+	if err != nil {
+		return err
+	}
+
+	r.Manifest.Packs = append(r.Manifest.Packs, source)
+	return nil
+}
+
+// SaveAPKs pulls the APKs of a package into the run
+func (r *Run) SaveAPKs(client *adb.Client, pkg string) (*APKManifest, error) {
+	return SaveAPKs(client, r.staging, pkg, r.Manifest.UserID)
+}
+
+// Record adds the result for a package
+func (r *Run) Record(res PackageResult) {
+	r.Manifest.Packages = append(r.Manifest.Packages, res)
+}
+
+// Write stores the run as an archive in backupDir and returns its path
+func (r *Run) Write(backupDir string) (string, error) {
+	defer r.Discard()
+
+	var files []string
+	err := filepath.WalkDir(r.staging, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(r.staging, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read staging directory: %w", err)
+	}
+	sort.Strings(files)
+
+	for _, name := range files {
+		sum, err := fileChecksum(filepath.Join(r.staging, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		r.Manifest.Checksums[name] = sum
+	}
+
+	manifest, err := json.MarshalIndent(r.Manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	file, archivePath, err := createArchive(backupDir, r.Manifest.CreatedAt, r.Manifest.UserID)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	if err := writeTarFile(tw, manifestFile, manifest, r.Manifest.CreatedAt); err != nil {
+		return "", err
+	}
+
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(r.staging, filepath.FromSlash(name)))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := writeTarFile(tw, name, data, r.Manifest.CreatedAt); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to write backup archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to write backup archive: %w", err)
+	}
+
+	return archivePath, nil
+}
+
+// createArchive creates a new archive file named after the time and user of
+// the run. Runs for several users, or from several processes, can start
+// within the same second, so an existing archive is never overwritten and a
+// counter is added instead.
+func createArchive(backupDir string, createdAt time.Time, userID string) (*os.File, string, error) {
+	base := fmt.Sprintf("backup_%s_user%s", createdAt.Format("20060102_150405"), userID)
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		archivePath := filepath.Join(backupDir, name+ArchiveExt)
+
+		file, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create backup archive: %w", err)
+		}
+		return file, archivePath, nil
+	}
+}
+
+// Discard removes the staged files of a run that is not written
+func (r *Run) Discard() {
+	os.RemoveAll(r.staging)
+}
+
+// Archive is a backup archive on disk
+type Archive struct {
+	Path     string
+	Manifest *Manifest
+}
+
+// OpenArchive reads the manifest of a backup archive
+func OpenArchive(archivePath string) (*Archive, error) {
+	var manifest *Manifest
+	err := walkArchive(archivePath, func(name string, r io.Reader) error {
+		if name != manifestFile {
+			return nil
+		}
+		manifest = &Manifest{}
+		if err := json.NewDecoder(r).Decode(manifest); err != nil {
+			return fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%s has no manifest", archivePath)
+	}
+
+	return &Archive{Path: archivePath, Manifest: manifest}, nil
+}
+
+// CheckDevice refuses an archive taken on another device unless forced
+func (a *Archive) CheckDevice(device *adb.Device, force bool) error {
+	if force || a.Manifest.Device.Matches(device) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s (%s)", ErrDeviceMismatch,
+		a.Manifest.Device.Manufacturer, a.Manifest.Device.Model, a.Manifest.Device.Serial)
+}
+
+// Verify checks every archive member against the manifest checksums and
+// returns a description of each problem found
+func (a *Archive) Verify() ([]string, error) {
+	return a.check(func(name string, r io.Reader) error {
+		return nil
+	})
+}
+
+// check walks the archive members, passing each to fn while comparing it
+// with its manifest checksum, and returns a description of each problem found
+func (a *Archive) check(fn func(name string, r io.Reader) error) ([]string, error) {
+	var problems []string
+	seen := make(map[string]bool)
+
+	err := walkArchive(a.Path, func(name string, r io.Reader) error {
+		if name == manifestFile {
+			return nil
+		}
+		seen[name] = true
+
+		expected, ok := a.Manifest.Checksums[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: not listed in manifest", name))
+			return nil
+		}
+
+		h := sha256.New()
+		tee := io.TeeReader(r, h)
+		if err := fn(name, tee); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tee); err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != expected {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range a.Manifest.Checksums {
+		if !seen[name] {
+			problems = append(problems, fmt.Sprintf("%s: missing from archive", name))
+		}
+	}
+	sort.Strings(problems)
+
+	return problems, nil
+}
+
+// Extract unpacks the archive into dir, verifying it on the way. An archive
+// that does not match its manifest returns ErrCorruptArchive.
+func (a *Archive) Extract(dir string) error {
+	problems, err := a.check(func(name string, r io.Reader) error {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+
+		file, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", target, err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, r); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrCorruptArchive, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RestoreAll brings several packages from this archive back onto the device,
+// extracting the archive only once. Nothing is installed from an archive
+// that does not match its manifest. onResult, if set, is called per package.
+func (a *Archive) RestoreAll(client *adb.Client, pkgs []string, userID string, onResult func(pkg string, err error)) error {
+	dir, err := os.MkdirTemp("", "adb-cleaner-restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := a.Extract(dir); err != nil {
+		return err
+	}
+
+	disabled := make(map[string]bool)
+	for _, res := range a.Manifest.Packages {
+		if res.Action == string(adb.ActionDisable) {
+			disabled[res.Package] = true
+		}
+	}
+
+	var failed []string
+	for _, pkg := range pkgs {
+		var err error
+		if disabled[pkg] {
+			err = client.EnablePackage(pkg, userID)
+		} else {
+			err = Restore(client, dir, pkg, userID)
+		}
+		if err != nil {
+			failed = append(failed, pkg)
+		}
+		if onResult != nil {
+			onResult(pkg, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// RemovedPackages returns the packages this run removed or disabled
+// successfully
+func (a *Archive) RemovedPackages() []string {
+	var pkgs []string
+	for _, res := range a.Manifest.Packages {
+		if res.Result == ResultSuccess {
+			pkgs = append(pkgs, res.Package)
+		}
+	}
+	return pkgs
+}
+
+// ListArchives returns the readable backup archives in backupDir, newest first
+func ListArchives(backupDir string) ([]*Archive, error) {
+	paths, err := filepath.Glob(filepath.Join(backupDir, "*"+ArchiveExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var archives []*Archive
+	for _, p := range paths {
+		archive, err := OpenArchive(p)
+		if err != nil {
+			continue
+		}
+		archives = append(archives, archive)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Manifest.CreatedAt.After(archives[j].Manifest.CreatedAt)
+	})
+
+	return archives, nil
+}
+
+// errStopWalk ends walkArchive early without an error
+var errStopWalk = errors.New("stop walk")
+
+// walkArchive calls fn for every regular file in a backup archive
+func walkArchive(archivePath string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read backup archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("unsafe path %q in backup archive", hdr.Name)
+		}
+
+		if err := fn(name, tr); err != nil {
+			if errors.Is(err, errStopWalk) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// writeTarFile adds a regular file to a tar archive
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
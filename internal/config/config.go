@@ -5,69 +5,91 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/logging"
+	"github.com/adb-cleaner/adb-cleaner/internal/report"
 )
 
 // Config represents the application configuration
 type Config struct {
+	Version        int    `json:"version"`
 	ADBPath        string `json:"adbPath"`
 	PackagesFile   string `json:"packagesFile"`
+	UserPack       string `json:"userPack"`
+	OverridesFile  string `json:"overridesFile"`
 	LogDir         string `json:"logDir"`
+	LogLevel       string `json:"logLevel"`
+	LogFormat      string `json:"logFormat"`
 	BackupDir      string `json:"backupDir"`
 	UserID         string `json:"userId"`
 	Theme          string `json:"theme"`
 	AutoSelectSafe bool   `json:"autoSelectSafe"`
+	BackupAPKs     bool   `json:"backupApks"`
+	IncludeRelated bool   `json:"includeRelated"`
+	ReportFormats  string `json:"reportFormats"` // comma-separated, see report.Formats
+
+	Keys     map[string][]string `json:"keys,omitempty"` // TUI action -> keys
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+
+	origins map[string]string // setting key -> layer it came from
+	dir     string            // directory of the project config file
+}
+
+// DefaultProfile is the name of the profile used for devices no other
+// profile matches
+const DefaultProfile = "default"
+
+// Profile declares what to remove from the devices it matches
+type Profile struct {
+	Devices    []string          `json:"devices,omitempty"`    // serials or build fingerprints
+	Packs      []string          `json:"packs,omitempty"`      // pack files, packagesFile when empty
+	Categories []string          `json:"categories,omitempty"` // select packages in these categories
+	Risk       []string          `json:"risk,omitempty"`       // select packages with these risk levels
+	Include    []string          `json:"include,omitempty"`    // always select these packages
+	Exclude    []string          `json:"exclude,omitempty"`    // never select these packages
+	Actions    map[string]string `json:"actions,omitempty"`    // package -> uninstall or disable
+	Users      []string          `json:"users,omitempty"`      // target users, userId when empty
 }
 
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
+		Version:        CurrentVersion,
 		ADBPath:        "adb",
 		PackagesFile:   "packs.txt",
+		UserPack:       "packs/user.txt",
+		OverridesFile:  UserOverridesFile(),
 		LogDir:         "logs",
+		LogLevel:       "info",
+		LogFormat:      logging.FormatText,
 		BackupDir:      "backups",
 		UserID:         "0",
 		Theme:          "default",
 		AutoSelectSafe: false,
+		BackupAPKs:     false,
+		IncludeRelated: false,
+		ReportFormats:  report.FormatMarkdown,
 	}
 }
 
-// Load loads configuration from file or returns default
-func Load() (*Config, error) {
-	cfg := DefaultConfig()
-
-	configFile := "config.json"
-	if _, err := os.Stat(configFile); err == nil {
-		data, err := os.ReadFile(configFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-
-		if err := json.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
-		}
-	}
-
-	// Create directories
-	if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create log directory: %w", err)
-	}
-
-	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create backup directory: %w", err)
-	}
-
-	return cfg, nil
-}
-
-// Save saves configuration to file
+// Save writes the configuration to the user config file
 func (c *Config) Save() error {
-	configFile := "config.json"
+	configFile := UserConfigFile()
+	if configFile == "" {
+		return fmt.Errorf("failed to locate the user config directory")
+	}
 
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
 	if err := os.WriteFile(configFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
@@ -75,26 +97,149 @@ func (c *Config) Save() error {
 	return nil
 }
 
-// GetPackagesFile returns the packages file path
+// GetPackagesFile returns the packages file path. Relative paths from a
+// config file were resolved against that file when it was loaded.
 func (c *Config) GetPackagesFile() string {
-	if filepath.IsAbs(c.PackagesFile) {
-		return c.PackagesFile
-	}
 	return c.PackagesFile
 }
 
 // GetLogDir returns the log directory path
 func (c *Config) GetLogDir() string {
-	if filepath.IsAbs(c.LogDir) {
-		return c.LogDir
-	}
 	return c.LogDir
 }
 
+// GetReportFormats returns the formats of the reports written after a run.
+// Invalid formats are left out; Validate reports them.
+func (c *Config) GetReportFormats() []string {
+	formats, _ := report.ParseFormats(c.ReportFormats)
+	return formats
+}
+
 // GetBackupDir returns the backup directory path
 func (c *Config) GetBackupDir() string {
-	if filepath.IsAbs(c.BackupDir) {
-		return c.BackupDir
-	}
 	return c.BackupDir
 }
+
+// Dir returns the absolute directory of the project config file, whether or
+// not the file exists
+func (c *Config) Dir() string {
+	return c.dir
+}
+
+// Setting is a configuration value and the layer it came from
+type Setting struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// Origin returns where a setting came from: "default", the path of a config
+// file, "env NAME" or "flag -name"
+func (c *Config) Origin(key string) string {
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return OriginDefault
+}
+
+// Settings lists every setting with its value and origin, then the key
+// bindings and the profiles
+func (c *Config) Settings() []Setting {
+	var settings []Setting
+	for _, opt := range options {
+		settings = append(settings, Setting{Key: opt.key, Value: opt.get(c), Origin: c.Origin(opt.key)})
+	}
+
+	actions := make([]string, 0, len(c.Keys))
+	for action := range c.Keys {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	for _, action := range actions {
+		key := "keys." + action
+		settings = append(settings, Setting{Key: key, Value: strings.Join(c.Keys[action], ", "), Origin: c.Origin(key)})
+	}
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, _ := json.Marshal(c.Profiles[name])
+		key := "profiles." + name
+		settings = append(settings, Setting{Key: key, Value: string(data), Origin: c.Origin(key)})
+	}
+
+	return settings
+}
+
+// ProfileFor returns the profile for a device. A profile listing the serial
+// or fingerprint of the device wins; otherwise the "default" profile is used,
+// falling back to one built from packagesFile, userId and autoSelectSafe.
+func (c *Config) ProfileFor(serial string, fingerprint string) (string, *Profile) {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, id := range c.Profiles[name].Devices {
+			if id == serial || (fingerprint != "" && id == fingerprint) {
+				return name, c.resolveProfile(c.Profiles[name])
+			}
+		}
+	}
+
+	profile, _ := c.Profile(DefaultProfile)
+	return DefaultProfile, profile
+}
+
+// Profile returns a profile by name. The "default" profile always exists:
+// when the config does not declare it, it selects the SAFE packages if
+// autoSelectSafe is set.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if profile, ok := c.Profiles[name]; ok {
+		return c.resolveProfile(profile), nil
+	}
+
+	if name != DefaultProfile {
+		return nil, fmt.Errorf("profile %q not found", name)
+	}
+
+	profile := &Profile{}
+	if c.AutoSelectSafe {
+		profile.Risk = []string{"SAFE"}
+	}
+	return c.resolveProfile(profile), nil
+}
+
+// resolveProfile returns a copy of a profile with the packs and users it
+// leaves empty taken from the top-level settings. The user pack, once it
+// exists, is always loaded last.
+func (c *Config) resolveProfile(profile *Profile) *Profile {
+	resolved := *profile
+	if len(resolved.Packs) == 0 {
+		resolved.Packs = []string{c.GetPackagesFile()}
+	}
+	if info, err := os.Stat(c.UserPack); err == nil && !info.IsDir() && !containsPath(resolved.Packs, c.UserPack) {
+		resolved.Packs = append(append([]string(nil), resolved.Packs...), c.UserPack)
+	}
+	if len(resolved.Users) == 0 {
+		resolved.Users = []string{c.UserID}
+	}
+	return &resolved
+}
+
+// containsPath reports whether paths lists path, comparing cleaned paths
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if filepath.Clean(p) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes a file under dir, creating its directory
+func writeFile(t *testing.T, dir string, name string, data string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// loadProject loads the configuration of a project file in dir, with the
+// user config directory isolated under dir and the given flags
+func loadProject(t *testing.T, dir string, args ...string) (*Config, error) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	writeFile(t, dir, "packs.txt", "com.example.bloat # Bloat | Ads | SAFE\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse(append([]string{"-config", filepath.Join(dir, "config.json")}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return Load(flags)
+}
+
+func TestLoadLayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		project string
+		env     string
+		flag    string
+		want    string
+		origin  string
+	}{
+		{name: "default", want: "info", origin: OriginDefault},
+		{name: "user file", user: "debug", want: "debug", origin: "xdg"},
+		{name: "project over user", user: "debug", project: "warn", want: "warn", origin: "config.json"},
+		{name: "env over files", user: "debug", project: "warn", env: "error", want: "error", origin: "env ADB_CLEANER_LOG_LEVEL"},
+		{name: "flag over env", project: "warn", env: "error", flag: "debug", want: "debug", origin: "flag -log-level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.user != "" {
+				writeFile(t, dir, filepath.Join("xdg", "adb-cleaner", "config.json"), `{"version": 1, "logLevel": "`+tt.user+`"}`)
+			}
+			if tt.project != "" {
+				writeFile(t, dir, "config.json", `{"version": 1, "logLevel": "`+tt.project+`"}`)
+			}
+			if tt.env != "" {
+				t.Setenv(EnvPrefix+"LOG_LEVEL", tt.env)
+			}
+			var args []string
+			if tt.flag != "" {
+				args = append(args, "-log-level", tt.flag)
+			}
+
+			cfg, err := loadProject(t, dir, args...)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.LogLevel != tt.want {
+				t.Errorf("logLevel = %q, want %q", cfg.LogLevel, tt.want)
+			}
+			if origin := cfg.Origin("logLevel"); !strings.Contains(origin, tt.origin) {
+				t.Errorf("origin = %q, want %q", origin, tt.origin)
+			}
+		})
+	}
+}
+
+func TestLoadStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		key     string
+		message string
+	}{
+		{
+			name:    "unknown key",
+			project: `{"version": 1, "logLevle": "debug"}`,
+			key:     "logLevle",
+			message: `did you mean "logLevel"?`,
+		},
+		{
+			name:    "unknown profile key",
+			project: `{"version": 1, "profiles": {"kiosk": {"user": ["10"]}}}`,
+			key:     "profiles.kiosk.user",
+			message: `did you mean "users"?`,
+		},
+		{
+			name:    "mistyped value",
+			project: `{"version": 1, "backupApks": "yes"}`,
+			key:     "backupApks",
+			message: "expected boolean, got string",
+		},
+		{
+			name:    "newer version",
+			project: `{"version": 9}`,
+			key:     "version",
+			message: "newer than this build supports",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "config.json", tt.project)
+
+			_, err := loadProject(t, dir)
+			var keyErr *KeyError
+			if !errors.As(err, &keyErr) {
+				t.Fatalf("Load = %v, want a key error", err)
+			}
+			if keyErr.Key != tt.key || !strings.Contains(keyErr.Err.Error(), tt.message) {
+				t.Errorf("Load = %v, want %s: %s", err, tt.key, tt.message)
+			}
+		})
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		version  int
+		migrated bool
+		wantErr  string
+	}{
+		{
+			name:     "unversioned",
+			data:     `{"logLevel": "debug", "userId": "10"}`,
+			version:  0,
+			migrated: true,
+		},
+		{
+			name:    "current",
+			data:    `{"version": 1, "logLevel": "debug"}`,
+			version: CurrentVersion,
+		},
+		{
+			name:    "unknown key",
+			data:    `{"logLevle": "debug"}`,
+			wantErr: "unknown key",
+		},
+		{
+			name:    "newer version",
+			data:    `{"version": 9}`,
+			wantErr: "newer than this build supports",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := writeFile(t, t.TempDir(), "config.json", tt.data)
+
+			version, err := MigrateFile(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("MigrateFile = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("MigrateFile: %v", err)
+			} else if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, statErr := os.Stat(filename + ".bak")
+			if !tt.migrated {
+				if string(data) != tt.data || statErr == nil {
+					t.Errorf("file rewritten to %s", data)
+				}
+				return
+			}
+
+			if statErr != nil {
+				t.Errorf("original not kept: %v", statErr)
+			}
+			cfg := &Config{origins: make(map[string]string)}
+			if err := cfg.loadFile(filename); err != nil {
+				t.Fatalf("migrated file: %v", err)
+			}
+			if !strings.Contains(string(data), `"version": 1`) || cfg.LogLevel != "debug" || cfg.UserID != "10" {
+				t.Errorf("migrated file = %s", data)
+			}
+		})
+	}
+}
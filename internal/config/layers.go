@@ -0,0 +1,444 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/theme"
+)
+
+// EnvPrefix prefixes the environment variables that override settings
+const EnvPrefix = "ADB_CLEANER_"
+
+// Origins of settings that do not come from a file
+const (
+	OriginDefault = "default"
+	OriginEnv     = "env"
+	OriginFlag    = "flag"
+)
+
+// option describes a top-level setting and how each layer names it
+type option struct {
+	key  string // JSON key in config files
+	env  string // environment variable, without EnvPrefix
+	flag string // command line flag
+	path bool   // relative values are resolved against the config file
+	bool bool   // the flag may be given without a value
+	get  func(c *Config) string
+	set  func(c *Config, value string) error
+}
+
+var options = []option{
+	{
+		key: "adbPath", env: "ADB_PATH", flag: "adb-path",
+		get: func(c *Config) string { return c.ADBPath },
+		set: func(c *Config, v string) error { c.ADBPath = v; return nil },
+	},
+	{
+		key: "packagesFile", env: "PACKAGES_FILE", flag: "packages-file", path: true,
+		get: func(c *Config) string { return c.PackagesFile },
+		set: func(c *Config, v string) error { c.PackagesFile = v; return nil },
+	},
+	{
+		key: "userPack", env: "USER_PACK", flag: "user-pack", path: true,
+		get: func(c *Config) string { return c.UserPack },
+		set: func(c *Config, v string) error { c.UserPack = v; return nil },
+	},
+	{
+		key: "overridesFile", env: "OVERRIDES_FILE", flag: "overrides-file", path: true,
+		get: func(c *Config) string { return c.OverridesFile },
+		set: func(c *Config, v string) error { c.OverridesFile = v; return nil },
+	},
+	{
+		key: "logDir", env: "LOG_DIR", flag: "log-dir", path: true,
+		get: func(c *Config) string { return c.LogDir },
+		set: func(c *Config, v string) error { c.LogDir = v; return nil },
+	},
+	{
+		key: "logLevel", env: "LOG_LEVEL", flag: "log-level",
+		get: func(c *Config) string { return c.LogLevel },
+		set: func(c *Config, v string) error { c.LogLevel = v; return nil },
+	},
+	{
+		key: "logFormat", env: "LOG_FORMAT", flag: "log-format",
+		get: func(c *Config) string { return c.LogFormat },
+		set: func(c *Config, v string) error { c.LogFormat = v; return nil },
+	},
+	{
+		key: "backupDir", env: "BACKUP_DIR", flag: "backup-dir", path: true,
+		get: func(c *Config) string { return c.BackupDir },
+		set: func(c *Config, v string) error { c.BackupDir = v; return nil },
+	},
+	{
+		key: "userId", env: "USER_ID", flag: "user",
+		get: func(c *Config) string { return c.UserID },
+		set: func(c *Config, v string) error { c.UserID = v; return nil },
+	},
+	{
+		key: "theme", env: "THEME", flag: "theme",
+		get: func(c *Config) string { return c.Theme },
+		set: func(c *Config, v string) error { c.Theme = v; return nil },
+	},
+	{
+		key: "autoSelectSafe", env: "AUTO_SELECT_SAFE", flag: "auto-select-safe", bool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.AutoSelectSafe) },
+		set: func(c *Config, v string) error { return setBool(&c.AutoSelectSafe, v) },
+	},
+	{
+		key: "backupApks", env: "BACKUP_APKS", flag: "backup-apks", bool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.BackupAPKs) },
+		set: func(c *Config, v string) error { return setBool(&c.BackupAPKs, v) },
+	},
+	{
+		key: "includeRelated", env: "INCLUDE_RELATED", flag: "include-related", bool: true,
+		get: func(c *Config) string { return strconv.FormatBool(c.IncludeRelated) },
+		set: func(c *Config, v string) error { return setBool(&c.IncludeRelated, v) },
+	},
+	{
+		key: "reportFormats", env: "REPORT_FORMATS", flag: "report-formats",
+		get: func(c *Config) string { return c.ReportFormats },
+		set: func(c *Config, v string) error { c.ReportFormats = v; return nil },
+	},
+}
+
+func setBool(dst *bool, value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+	*dst = b
+	return nil
+}
+
+// Flags holds the command line overrides of the settings
+type Flags struct {
+	fs     *flag.FlagSet
+	file   *string
+	values map[string]*string
+}
+
+// RegisterFlags adds a flag for every setting to fs, plus -config to read a
+// project config file other than ./config.json
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		fs:     fs,
+		file:   fs.String("config", "", "project config file (default ./config.json)"),
+		values: make(map[string]*string),
+	}
+	for _, opt := range options {
+		value := &flagValue{isBool: opt.bool}
+		fs.Var(value, opt.flag, fmt.Sprintf("override %s", opt.key))
+		f.values[opt.flag] = &value.value
+	}
+	return f
+}
+
+// flagValue is a setting flag; boolean settings may be given without a value
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// set returns the flags given on the command line
+func (f *Flags) set() map[string]string {
+	set := make(map[string]string)
+	if f == nil {
+		return set
+	}
+	f.fs.Visit(func(fl *flag.Flag) {
+		if v, ok := f.values[fl.Name]; ok {
+			set[fl.Name] = *v
+		}
+	})
+	return set
+}
+
+// SystemConfigFile returns the path of the machine-wide config file
+func SystemConfigFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "adb-cleaner", "config.json")
+	}
+	return "/etc/adb-cleaner/config.json"
+}
+
+// UserConfigFile returns the path of the per-user config file under
+// $XDG_CONFIG_HOME, or the platform config directory when it is unset
+func UserConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "adb-cleaner", "config.json")
+}
+
+// UserOverridesFile returns the default path of the user override file, next
+// to the per-user config file
+func UserOverridesFile() string {
+	if file := UserConfigFile(); file != "" {
+		return filepath.Join(filepath.Dir(file), "overrides.json")
+	}
+	return "overrides.json"
+}
+
+// ProjectFile returns the project config file: the -config flag, then
+// ADB_CLEANER_CONFIG, then ./config.json. flags may be nil.
+func ProjectFile(flags *Flags) string {
+	if flags != nil && *flags.file != "" {
+		return *flags.file
+	}
+	if env := os.Getenv(EnvPrefix + "CONFIG"); env != "" {
+		return env
+	}
+	return "config.json"
+}
+
+// Load builds the configuration from, in increasing priority: built-in
+// defaults, the system file, the user file, the project file, ADB_CLEANER_*
+// environment variables and command line flags. flags may be nil.
+func Load(flags *Flags) (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.origins = make(map[string]string)
+	for _, opt := range options {
+		cfg.origins[opt.key] = OriginDefault
+	}
+
+	projectFile := ProjectFile(flags)
+	if abs, err := filepath.Abs(projectFile); err == nil {
+		cfg.dir = filepath.Dir(abs)
+	}
+
+	for _, file := range []string{SystemConfigFile(), UserConfigFile(), projectFile} {
+		if file == "" {
+			continue
+		}
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, opt := range options {
+		name := EnvPrefix + opt.env
+		if value, ok := os.LookupEnv(name); ok {
+			if err := opt.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			cfg.origins[opt.key] = OriginEnv + " " + name
+		}
+	}
+
+	set := flags.set()
+	for _, opt := range options {
+		if value, ok := set[opt.flag]; ok {
+			if err := opt.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %w", opt.flag, err)
+			}
+			cfg.origins[opt.key] = OriginFlag + " -" + opt.flag
+		}
+	}
+
+	// Paths left at their defaults live next to the project config file,
+	// wherever the program is started from
+	for _, opt := range options {
+		if opt.path && cfg.origins[opt.key] == OriginDefault {
+			opt.set(cfg, resolvePath(cfg.dir, opt.get(cfg)))
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	// Create directories
+	if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadFile layers a config file over the configuration, if it exists.
+// Relative paths in the file are resolved against the file's directory.
+func (c *Config) loadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", filename, err)
+	}
+
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	dir := filepath.Dir(filename)
+
+	keys, err := parseFile(filename, data)
+	if err != nil {
+		return err
+	}
+
+	var profiles map[string]json.RawMessage
+	if value, ok := keys["profiles"]; ok {
+		if err := json.Unmarshal(value, &profiles); err != nil {
+			return &KeyError{Origin: filename, Key: "profiles", Err: describeJSONError(err)}
+		}
+	}
+
+	settings := make(map[string]json.RawMessage, len(keys))
+	for key, value := range keys {
+		if key != "profiles" {
+			settings[key] = value
+		}
+	}
+	if err := decodeStrict(settings, c, filename, ""); err != nil {
+		return err
+	}
+
+	if c.Profiles == nil && len(profiles) > 0 {
+		c.Profiles = make(map[string]*Profile)
+	}
+	for name, value := range profiles {
+		if string(value) == "null" {
+			c.Profiles[name] = nil
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return &KeyError{Origin: filename, Key: "profiles." + name, Err: describeJSONError(err)}
+		}
+		profile := &Profile{}
+		if err := decodeStrict(fields, profile, filename, "profiles."+name+"."); err != nil {
+			return err
+		}
+		c.Profiles[name] = profile
+	}
+
+	for _, opt := range options {
+		if _, ok := keys[opt.key]; !ok {
+			continue
+		}
+		if opt.path || (opt.key == "theme" && theme.IsFile(c.Theme)) {
+			opt.set(c, resolvePath(dir, opt.get(c)))
+		}
+		c.origins[opt.key] = filename
+	}
+
+	var keyBindings map[string]json.RawMessage
+	json.Unmarshal(keys["keys"], &keyBindings)
+	for action := range keyBindings {
+		c.origins["keys."+action] = filename
+		c.origins["keys"] = filename
+	}
+
+	for name := range profiles {
+		profile := c.Profiles[name]
+		if profile == nil {
+			// null removes a profile declared by a lower layer
+			delete(c.Profiles, name)
+			delete(c.origins, "profiles."+name)
+			continue
+		}
+		for i, pack := range profile.Packs {
+			profile.Packs[i] = resolvePath(dir, pack)
+		}
+		c.origins["profiles."+name] = filename
+	}
+
+	return nil
+}
+
+// resolvePath makes a relative path relative to dir
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// parseFile decodes the keys of a config file and migrates them to
+// CurrentVersion
+func parseFile(filename string, data []byte) (map[string]json.RawMessage, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+	if keys == nil {
+		keys = make(map[string]json.RawMessage)
+	}
+
+	if _, err := migrate(keys, filename); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// MigrateFile rewrites a config file in the current format, keeping the
+// original next to it with a .bak suffix. It returns the version the file
+// was written in.
+func MigrateFile(filename string) (int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read config file %s: %w", filename, err)
+	}
+
+	var original map[string]json.RawMessage
+	if err := json.Unmarshal(data, &original); err != nil {
+		return 0, fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+	version := 0
+	json.Unmarshal(original["version"], &version)
+
+	keys, err := parseFile(filename, data)
+	if err != nil {
+		return 0, err
+	}
+	if version == CurrentVersion {
+		return version, nil
+	}
+
+	// Decode into a scratch config so a file with unknown keys is reported
+	// instead of rewritten
+	scratch := &Config{origins: make(map[string]string)}
+	if err := scratch.loadFile(filename); err != nil {
+		return 0, err
+	}
+
+	migrated, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(filename+".bak", data, 0644); err != nil {
+		return 0, fmt.Errorf("failed to back up config file: %w", err)
+	}
+	if err := os.WriteFile(filename, append(migrated, '\n'), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,344 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/logging"
+	"github.com/adb-cleaner/adb-cleaner/internal/report"
+	"github.com/adb-cleaner/adb-cleaner/internal/theme"
+)
+
+// CurrentVersion is the config format version written by this build
+const CurrentVersion = 1
+
+// migrations upgrade the raw keys of a config file; migrations[i] turns
+// version i into version i+1
+var migrations = []func(raw map[string]json.RawMessage) error{
+	migrateV0,
+}
+
+// KeyError reports a problem with one setting of one layer
+type KeyError struct {
+	Origin string // config file, "env NAME", "flag -name" or "default"
+	Key    string // dotted path of the setting, e.g. profiles.kiosk.users[1]
+	Err    error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Origin, e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// migrate upgrades raw config keys to CurrentVersion and returns the version
+// the file was written in. Files without a version predate versioning.
+func migrate(raw map[string]json.RawMessage, origin string) (int, error) {
+	version := 0
+	if value, ok := raw["version"]; ok {
+		if err := json.Unmarshal(value, &version); err != nil || version < 0 {
+			return 0, &KeyError{Origin: origin, Key: "version", Err: fmt.Errorf("must be a non-negative integer")}
+		}
+	}
+
+	if version > CurrentVersion {
+		return 0, &KeyError{Origin: origin, Key: "version", Err: fmt.Errorf("version %d is newer than this build supports (%d)", version, CurrentVersion)}
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if err := migrations[v](raw); err != nil {
+			return 0, err
+		}
+	}
+
+	raw["version"] = json.RawMessage(strconv.Itoa(CurrentVersion))
+	return version, nil
+}
+
+// migrateV0 upgrades files written before the format was versioned. Their
+// keys are those of version 1, which only adds the version field; version 1
+// files are the first to be read strictly.
+func migrateV0(raw map[string]json.RawMessage) error {
+	return nil
+}
+
+// decodeStrict decodes raw keys into the fields of the struct dst points to,
+// naming the offending key for unknown keys and mistyped values
+func decodeStrict(raw map[string]json.RawMessage, dst interface{}, origin string, prefix string) error {
+	fields := jsonFields(dst)
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := fields[key]
+		if !ok {
+			known := make([]string, 0, len(fields))
+			for name := range fields {
+				known = append(known, name)
+			}
+			err := fmt.Errorf("unknown key")
+			if hint := closest(key, known); hint != "" {
+				err = fmt.Errorf("unknown key, did you mean %q?", hint)
+			}
+			return &KeyError{Origin: origin, Key: prefix + key, Err: err}
+		}
+
+		if err := json.Unmarshal(raw[key], field.Addr().Interface()); err != nil {
+			return &KeyError{Origin: origin, Key: prefix + key, Err: describeJSONError(err)}
+		}
+	}
+
+	return nil
+}
+
+// jsonFields maps the JSON names of the exported fields of a struct to the
+// fields themselves
+func jsonFields(dst interface{}) map[string]reflect.Value {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+		fields[tag] = v.Field(i)
+	}
+	return fields
+}
+
+// describeJSONError rewords a decoding error without Go type names
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("expected %s, got %s", jsonKind(typeErr.Type), typeErr.Value)
+	}
+	return err
+}
+
+// jsonKind names the JSON type that decodes into a Go type
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array of " + jsonKind(t.Elem())
+	case reflect.Map, reflect.Struct, reflect.Ptr:
+		return "object"
+	}
+	return t.String()
+}
+
+// closest returns the known key nearest to key, if it is a likely typo
+func closest(key string, known []string) string {
+	best, bestDist := "", 3
+	for _, candidate := range known {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(candidate)); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+// Validate checks the values of the merged configuration. Each problem names
+// the key and the layer it came from.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key string, format string, args ...interface{}) {
+		errs = append(errs, &KeyError{Origin: c.Origin(key), Key: key, Err: fmt.Errorf(format, args...)})
+	}
+
+	if strings.TrimSpace(c.ADBPath) == "" {
+		fail("adbPath", "must not be empty")
+	}
+
+	if c.usesPackagesFile() {
+		if err := checkFile(c.PackagesFile); err != nil {
+			fail("packagesFile", "%v", err)
+		}
+	}
+	if err := checkNewFile(c.UserPack); err != nil {
+		fail("userPack", "%v", err)
+	}
+	if err := checkNewFile(c.OverridesFile); err != nil {
+		fail("overridesFile", "%v", err)
+	}
+	if err := checkDir(c.LogDir); err != nil {
+		fail("logDir", "%v", err)
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		fail("logLevel", "%v", err)
+	}
+	if err := logging.CheckFormat(c.LogFormat); err != nil {
+		fail("logFormat", "%v", err)
+	}
+	if err := checkDir(c.BackupDir); err != nil {
+		fail("backupDir", "%v", err)
+	}
+
+	if !validUserID(c.UserID) {
+		fail("userId", "invalid user ID %q, expected a non-negative integer", c.UserID)
+	}
+
+	if theme.IsFile(c.Theme) {
+		if _, err := theme.LoadFile(c.Theme); err != nil {
+			fail("theme", "%v", err)
+		}
+	} else if theme.Builtin(c.Theme) == nil {
+		fail("theme", "unknown theme %q, expected one of %s or a .json theme file", c.Theme, strings.Join(theme.Names(), ", "))
+	}
+
+	if _, err := report.ParseFormats(c.ReportFormats); err != nil {
+		fail("reportFormats", "%v", err)
+	}
+
+	actions := make([]string, 0, len(c.Keys))
+	for action := range c.Keys {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	for _, action := range actions {
+		key := "keys." + action
+		if len(c.Keys[action]) == 0 {
+			fail(key, "no keys given")
+		}
+		for i, k := range c.Keys[action] {
+			if k == "" {
+				errs = append(errs, &KeyError{Origin: c.Origin(key), Key: fmt.Sprintf("%s[%d]", key, i), Err: fmt.Errorf("must not be empty")})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := c.Profiles[name]
+		origin := c.Origin("profiles." + name)
+		failProfile := func(key string, format string, args ...interface{}) {
+			errs = append(errs, &KeyError{Origin: origin, Key: "profiles." + name + "." + key, Err: fmt.Errorf(format, args...)})
+		}
+
+		for i, pack := range profile.Packs {
+			if err := checkFile(pack); err != nil {
+				failProfile(fmt.Sprintf("packs[%d]", i), "%v", err)
+			}
+		}
+		for i, user := range profile.Users {
+			if !validUserID(user) {
+				failProfile(fmt.Sprintf("users[%d]", i), "invalid user ID %q, expected a non-negative integer", user)
+			}
+		}
+		for pkg, action := range profile.Actions {
+			if action != "uninstall" && action != "disable" {
+				failProfile("actions."+pkg, "unknown action %q, expected uninstall or disable", action)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// usesPackagesFile reports whether a profile falls back to packagesFile
+// because it declares no packs. Devices no profile matches get the default
+// profile, which does so unless the config declares it with packs.
+func (c *Config) usesPackagesFile() bool {
+	if _, ok := c.Profiles[DefaultProfile]; !ok {
+		return true
+	}
+	for _, profile := range c.Profiles {
+		if len(profile.Packs) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFile reports why a path cannot be read as a file
+func checkFile(path string) error {
+	if path == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, errors.Unwrap(err))
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// checkNewFile reports why a path cannot be used as a file that is created
+// when first written
+func checkNewFile(path string) error {
+	if path == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// checkDir reports why a path cannot be used as a directory. Directories
+// that do not exist yet are created on load.
+func checkDir(path string) error {
+	if path == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	info, err := os.Stat(path)
+	if err == nil && !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+func validUserID(id string) bool {
+	n, err := strconv.Atoi(id)
+	return err == nil && n >= 0
+}
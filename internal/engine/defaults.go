@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// ErrDefaultApps is returned when a run would remove default apps before
+// another app takes over their role
+var ErrDefaultApps = errors.New("selected packages are default apps")
+
+// DefaultConflict is a selected package a user relies on as a default app
+type DefaultConflict struct {
+	Package string `json:"package"`
+	User    string `json:"user"`
+	Role    string `json:"role"`
+	Message string `json:"message"`
+	Replace bool   `json:"replace,omitempty"` // a replacement must be set before removal
+}
+
+// Defaults returns the default apps of a user, read once per inventory
+func (e *Engine) Defaults(user string) (*adb.Defaults, error) {
+	if d := e.defaults[user]; d != nil {
+		return d, nil
+	}
+
+	d, err := e.client.Defaults(user)
+	if err != nil {
+		return nil, err
+	}
+	if e.defaults == nil {
+		e.defaults = make(map[string]*adb.Defaults)
+	}
+	e.defaults[user] = d
+	return d, nil
+}
+
+// DefaultConflicts returns the selected packages the target users rely on
+// as their SMS app, dialer, browser, home app, keyboard or accessibility
+// service
+func (e *Engine) DefaultConflicts() ([]DefaultConflict, error) {
+	var conflicts []DefaultConflict
+	for _, target := range e.selectionTargets() {
+		found, err := e.conflictsFor(target.user, target.packages)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, found...)
+	}
+	return conflicts, nil
+}
+
+// CheckDefaults returns the error Run would refuse the selection with
+// because the target users rely on some of it as default apps
+func (e *Engine) CheckDefaults(opts Options) error {
+	return e.checkDefaults(e.selectionTargets(), opts, &progress{})
+}
+
+// userTarget lists the packages a run removes for one user
+type userTarget struct {
+	user     string
+	packages []string
+}
+
+// selectionTargets returns the selected packages for each target user
+func (e *Engine) selectionTargets() []userTarget {
+	var names []string
+	for _, pkg := range e.manager.GetSelectedPackages() {
+		names = append(names, pkg.Name)
+	}
+
+	var targets []userTarget
+	for _, user := range e.Users() {
+		targets = append(targets, userTarget{user: user, packages: names})
+	}
+	return targets
+}
+
+// conflictsFor returns the packages among names a user relies on as default
+// apps
+func (e *Engine) conflictsFor(user string, names []string) ([]DefaultConflict, error) {
+	d, err := e.Defaults(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []DefaultConflict
+	for _, name := range names {
+		for _, role := range d.Held(name) {
+			conflict := DefaultConflict{Package: name, User: user, Role: role, Replace: role != adb.RoleAccessibility}
+			if conflict.Replace {
+				conflict.Message = fmt.Sprintf("%s is the %s of user %s", name, adb.RoleLabel(role), user)
+			} else {
+				conflict.Message = fmt.Sprintf("%s provides an enabled %s of user %s", name, adb.RoleLabel(role), user)
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+// checkDefaults refuses a run that would remove default apps before another
+// app takes over their role, or whose default apps cannot be read. Dry runs
+// and forced runs only warn.
+func (e *Engine) checkDefaults(targets []userTarget, opts Options, p *progress) error {
+	var blocking []string
+	for _, target := range targets {
+		if len(target.packages) == 0 {
+			continue
+		}
+		conflicts, err := e.conflictsFor(target.user, target.packages)
+		if err != nil {
+			if !opts.DryRun && !opts.Force {
+				return fmt.Errorf("default apps of user %s are not checked, force the run to remove the packages anyway: %w", target.user, err)
+			}
+			p.emit(Event{Type: EventWarning, Message: fmt.Sprintf("default apps of user %s are not checked: %v", target.user, err)})
+			continue
+		}
+		for _, conflict := range conflicts {
+			if conflict.Replace {
+				blocking = append(blocking, conflict.Message)
+			}
+		}
+	}
+
+	if len(blocking) == 0 {
+		return nil
+	}
+	if !opts.DryRun && !opts.Force {
+		return fmt.Errorf("%w: %s; make another app the default first or force the run", ErrDefaultApps, strings.Join(blocking, "; "))
+	}
+	for _, message := range blocking {
+		p.emit(Event{Type: EventWarning, Message: message + " and is removed without a replacement"})
+	}
+	return nil
+}
+
+// RoleCandidates returns what can take over a role for a user, leaving out
+// the selected packages
+func (e *Engine) RoleCandidates(user, role string) ([]string, error) {
+	all, err := e.client.RoleCandidates(role, user)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	for _, pkg := range e.manager.GetSelectedPackages() {
+		selected[pkg.Name] = true
+	}
+
+	var candidates []string
+	for _, candidate := range all {
+		if !selected[packageOf(candidate)] {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+// ReplaceDefault makes a package, or an input method for the keyboard, the
+// default for a role of a user
+func (e *Engine) ReplaceDefault(user, role, holder string) error {
+	delete(e.defaults, user)
+	return e.client.SetRoleHolder(role, holder, user)
+}
+
+// packageOf returns the package of a package or component name
+func packageOf(name string) string {
+	pkg, _, _ := strings.Cut(name, "/")
+	return pkg
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+)
+
+// SourceDiscovered is the source of packages adopted from the discover view
+// without being added to a pack
+const SourceDiscovered = "discovered"
+
+// Discovered is a package on the device that no loaded pack covers
+type Discovered struct {
+	Name   string
+	System bool
+	Record *adb.PackageRecord // nil when the inventory does not list it
+	Score  *packages.Score
+}
+
+// Discovery is the result of scanning the device for packages no loaded
+// pack covers
+type Discovery struct {
+	Packages []Discovered
+	Unscored string // why the packages only got the score of an unrated app
+}
+
+// Discover returns the system and third-party packages of the device that
+// no loaded pack covers, scored and sorted by name. When the device facts
+// cannot be read they get the score of an unrated app and the reason is
+// reported in Unscored.
+func (e *Engine) Discover() (*Discovery, error) {
+	system, err := e.client.ListSystemPackages()
+	if err != nil {
+		return nil, err
+	}
+	thirdParty, err := e.client.ListThirdPartyPackages()
+	if err != nil {
+		return nil, err
+	}
+	inv, err := e.client.Inventory(e.device.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Discovery{}
+	scorer, err := e.Scorer()
+	if err != nil {
+		d.Unscored = err.Error()
+	}
+	score := func(name string, isSystem bool) *packages.Score {
+		if scorer == nil {
+			return packages.UnratedScore(isSystem)
+		}
+		return scorer.Score(name, "")
+	}
+
+	known := make(map[string]bool)
+	for _, pkg := range e.manager.GetPackages() {
+		known[pkg.Name] = true
+	}
+
+	add := func(names []string, isSystem bool) {
+		for _, name := range names {
+			if known[name] {
+				continue
+			}
+			known[name] = true
+			d.Packages = append(d.Packages, Discovered{Name: name, System: isSystem, Record: inv.Get(name), Score: score(name, isSystem)})
+		}
+	}
+	add(system, true)
+	add(thirdParty, false)
+
+	sort.Slice(d.Packages, func(i, j int) bool {
+		return d.Packages[i].Name < d.Packages[j].Name
+	})
+	return d, nil
+}
+
+// PackageInfo returns the live metadata the device reports for a package
+func (e *Engine) PackageInfo(name string) (map[string]string, error) {
+	return e.client.GetPackageInfo(name)
+}
+
+// Adopt adds discovered packages to the list, selected for removal
+func (e *Engine) Adopt(names []string) {
+	inv, _ := e.client.Inventory(e.device.UserID)
+	for _, name := range names {
+		if e.manager.SetSelected(name, true) {
+			continue
+		}
+		e.manager.AddPackage(&packages.Package{
+			Name:      name,
+			Source:    SourceDiscovered,
+			Selected:  true,
+			Installed: inv.IsInstalled(name),
+		})
+	}
+}
+
+// Promote adds a discovered package to a user pack so later runs list it
+// with its description and risk
+func (e *Engine) Promote(pack string, pkg *packages.Package) error {
+	inv, _ := e.client.Inventory(e.device.UserID)
+	pkg.Installed = inv.IsInstalled(pkg.Name)
+	if err := e.manager.AddToPack(pack, pkg); err != nil {
+		return fmt.Errorf("failed to promote %s: %w", pkg.Name, err)
+	}
+	return nil
+}
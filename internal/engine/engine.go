@@ -0,0 +1,564 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+)
+
+// EventType identifies the kind of progress event
+type EventType string
+
+const (
+	EventStarted  EventType = "started"
+	EventPackage  EventType = "package"
+	EventBackup   EventType = "backup"
+	EventRestore  EventType = "restore"
+	EventWarning  EventType = "warning"
+	EventFinished EventType = "finished"
+)
+
+// Event reports progress of a run
+type Event struct {
+	Type    EventType `json:"type"`
+	Package string    `json:"package,omitempty"`
+	Result  string    `json:"result,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Done    int       `json:"done"`
+	Total   int       `json:"total"`
+	Time    time.Time `json:"time"`
+}
+
+// String formats the event as a log line
+func (e Event) String() string {
+	tag := ""
+	switch e.Type {
+	case EventBackup:
+		tag = "BACKUP"
+	case EventWarning:
+		tag = "WARN"
+	case EventRestore:
+		tag = "RESTORED"
+		if e.Result == backup.ResultFailed {
+			tag = "FAIL"
+		}
+	case EventPackage:
+		switch e.Result {
+		case backup.ResultSuccess:
+			tag = "SUCCESS"
+		case backup.ResultFailed:
+			tag = "FAIL"
+		case backup.ResultSkipped:
+			tag = "SKIP"
+		case backup.ResultDryRun:
+			tag = "DRY-RUN"
+		}
+	default:
+		return e.Message
+	}
+
+	if e.Package == "" {
+		return fmt.Sprintf("[%s] %s", tag, e.Message)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("[%s] %s: %s", tag, e.Package, e.Message)
+	}
+	return fmt.Sprintf("[%s] %s", tag, e.Package)
+}
+
+// Options controls a run
+type Options struct {
+	DryRun        bool
+	BackupAPKs    bool
+	BackupDir     string
+	ReportDir     string
+	ReportFormats []string // report.Formats to write once the run is done
+	Force         bool     // remove default apps that no other app has taken over from
+}
+
+// Summary is the outcome of a run
+type Summary struct {
+	Success  int      `json:"success"`
+	DryRun   int      `json:"dryRun,omitempty"` // packages a dry run would remove
+	Failed   int      `json:"failed"`
+	Skipped  int      `json:"skipped"`
+	Archives []string `json:"archives,omitempty"` // one archive per target user
+
+	// Free storage and memory of the device before and after the run
+	Measured *Measurement `json:"measured,omitempty"`
+
+	Reports []string `json:"reports,omitempty"` // report files written for the run
+
+	NotSimulated string `json:"notSimulated,omitempty"` // why a dry run only listed the packages
+}
+
+// PlanItem describes what a run would do with one selected package
+type PlanItem struct {
+	Package     string          `json:"package"`
+	User        string          `json:"user"`
+	Action      adb.Action      `json:"action"`
+	Result      string          `json:"result"`
+	Reason      string          `json:"reason,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Category    string          `json:"category,omitempty"`
+	RiskLevel   string          `json:"riskLevel,omitempty"`
+	Score       *packages.Score `json:"score,omitempty"`
+	Source      string          `json:"source,omitempty"`
+}
+
+// Engine removes the selected packages of a manager from one device. It is
+// shared by the terminal UI and the HTTP server.
+type Engine struct {
+	client   *adb.Client
+	manager  *packages.Manager
+	device   *adb.Device
+	profile  string
+	users    []string
+	facts    map[string]*adb.PackageFacts // device facts, cached per inventory
+	defaults map[string]*adb.Defaults     // user -> default apps, cached per inventory
+	dir      string                       // config directory plan files keep pack paths relative to
+}
+
+// New creates an engine for a device
+func New(client *adb.Client, manager *packages.Manager, device *adb.Device) *Engine {
+	return &Engine{
+		client:  client,
+		manager: manager,
+		device:  device,
+	}
+}
+
+// ForDevice creates an engine for a device, loads the user overrides,
+// applies the profile the config assigns to it and takes the device
+// inventory
+func ForDevice(client *adb.Client, device *adb.Device, cfg *config.Config) (*Engine, error) {
+	name, profile := cfg.ProfileFor(device.ID, device.Fingerprint)
+
+	eng := New(client, packages.NewManager(), device)
+	eng.dir = cfg.Dir()
+	if err := eng.manager.LoadOverrides(cfg.OverridesFile); err != nil {
+		return nil, err
+	}
+	if err := eng.ApplyProfile(name, profile); err != nil {
+		return nil, err
+	}
+	if _, err := eng.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return eng, nil
+}
+
+// Client returns the adb client of the engine
+func (e *Engine) Client() *adb.Client {
+	return e.client
+}
+
+// Manager returns the package manager of the engine
+func (e *Engine) Manager() *packages.Manager {
+	return e.manager
+}
+
+// Device returns the device the engine works on
+func (e *Engine) Device() *adb.Device {
+	return e.device
+}
+
+// Profile returns the name of the applied profile, if any
+func (e *Engine) Profile() string {
+	return e.profile
+}
+
+// Users returns the users a run removes packages for. The first one is the
+// user of the device whose installed status is shown.
+func (e *Engine) Users() []string {
+	if len(e.users) == 0 {
+		return []string{e.device.UserID}
+	}
+	return e.users
+}
+
+// SetUsers sets the users a run removes packages for
+func (e *Engine) SetUsers(users []string) {
+	e.users = append([]string(nil), users...)
+	if len(e.users) > 0 {
+		e.device.UserID = e.users[0]
+	}
+	e.facts = nil
+	e.defaults = nil
+}
+
+// ApplyProfile loads the packs of a profile, selects its packages, sets their
+// actions and targets its users
+func (e *Engine) ApplyProfile(name string, profile *config.Profile) error {
+	actions := make(map[string]adb.Action, len(profile.Actions))
+	for pkg, value := range profile.Actions {
+		action, err := adb.ParseAction(value)
+		if err != nil {
+			return fmt.Errorf("profile %s: %s: %w", name, pkg, err)
+		}
+		actions[pkg] = action
+	}
+
+	if _, err := e.manager.LoadPacks(profile.Packs); err != nil {
+		return err
+	}
+
+	for _, level := range profile.Risk {
+		e.manager.SelectByRiskLevel(level)
+	}
+	for _, category := range profile.Categories {
+		e.manager.SelectByCategory(category)
+	}
+	for _, pkg := range profile.Include {
+		if !e.manager.SetSelected(pkg, true) {
+			e.manager.AddPackage(&packages.Package{
+				Name:     pkg,
+				Source:   "profile " + name,
+				Selected: true,
+			})
+		}
+	}
+	for _, pkg := range profile.Exclude {
+		e.manager.SetSelected(pkg, false)
+	}
+	for pkg, action := range actions {
+		e.manager.SetAction(pkg, action)
+	}
+
+	e.profile = name
+	e.SetUsers(profile.Users)
+	return nil
+}
+
+// Refresh retakes the device inventory and updates the installed status
+func (e *Engine) Refresh() (*adb.Inventory, error) {
+	inv, err := e.client.RefreshInventory(e.device.UserID)
+	if err != nil {
+		return nil, err
+	}
+	e.facts = nil
+	e.defaults = nil
+	e.manager.UpdateInstalledStatus(inv)
+	return inv, nil
+}
+
+// Plan describes what a run would do with the current selection for every
+// target user without touching the device
+func (e *Engine) Plan() ([]PlanItem, error) {
+	var items []PlanItem
+	var primary *adb.Inventory
+	for _, user := range e.Users() {
+		inv, err := e.client.Inventory(user)
+		if err != nil {
+			return nil, err
+		}
+		if primary == nil {
+			primary = inv
+		}
+
+		for _, pkg := range e.manager.GetSelectedPackages() {
+			item := PlanItem{
+				Package:     pkg.Name,
+				User:        user,
+				Action:      pkg.GetAction(),
+				Result:      ResultPlanned,
+				Reason:      "selected from " + filepath.Base(pkg.Source),
+				Description: pkg.Description,
+				Category:    pkg.Category,
+				RiskLevel:   pkg.RiskLevel,
+				Score:       pkg.Score,
+				Source:      pkg.Source,
+			}
+			if reason := skipReason(inv, pkg); reason != "" {
+				item.Result = backup.ResultSkipped
+				item.Reason = reason
+			}
+			items = append(items, item)
+		}
+	}
+
+	e.manager.UpdateInstalledStatus(primary)
+
+	return items, nil
+}
+
+// skipReason returns why a package cannot be acted on for the inventory
+// user, or an empty string if it can
+func skipReason(inv *adb.Inventory, pkg *packages.Package) string {
+	rec := inv.Get(pkg.Name)
+	if rec == nil || !rec.Installed {
+		return reasonNotInstalled
+	}
+	if pkg.GetAction() == adb.ActionDisable && !rec.Enabled {
+		return reasonDisabled
+	}
+	return ""
+}
+
+// progress numbers the events of a run that may span several users
+type progress struct {
+	done    int
+	total   int
+	dryRun  bool
+	onEvent func(Event)
+	summary *Summary
+}
+
+// emit stamps an event and passes it on
+func (p *progress) emit(ev Event) {
+	ev.Time = time.Now()
+	ev.Done = p.done
+	ev.Total = p.total
+	if p.onEvent != nil {
+		p.onEvent(ev)
+	}
+}
+
+// finish reports the outcome of the run
+func (p *progress) finish() {
+	message := fmt.Sprintf("%d removed, %d failed, %d skipped",
+		p.summary.Success, p.summary.Failed, p.summary.Skipped)
+	freed := "freed"
+	if p.dryRun {
+		message = fmt.Sprintf("Dry run: %d would be removed, %d would fail, %d skipped",
+			p.summary.DryRun, p.summary.Failed, p.summary.Skipped)
+		freed = "would be freed"
+	}
+	if m := p.summary.Measured; m != nil {
+		message += fmt.Sprintf(", %s storage and %s memory %s",
+			adb.FormatBytes(m.StorageFreed()), adb.FormatBytes(m.MemoryFreed()), freed)
+	}
+	if p.summary.NotSimulated != "" {
+		message += " (dry run not simulated)"
+	}
+	p.emit(Event{Type: EventFinished, Message: message})
+}
+
+// Run removes the selected packages for every target user, records each
+// user's run in a backup archive and reports progress through onEvent
+func (e *Engine) Run(opts Options, onEvent func(Event)) (*Summary, error) {
+	selected := e.manager.GetSelectedPackages()
+	users := e.Users()
+	p := &progress{total: len(selected) * len(users), dryRun: opts.DryRun, onEvent: onEvent, summary: &Summary{}}
+
+	var packs []backup.PackSource
+	for _, source := range e.manager.Sources() {
+		pack, err := backup.NewPackSource(source)
+		if err != nil {
+			p.emit(Event{Type: EventWarning, Message: err.Error()})
+			continue
+		}
+		packs = append(packs, pack)
+	}
+
+	if err := e.checkDefaults(e.selectionTargets(), opts, p); err != nil {
+		return nil, err
+	}
+
+	p.emit(Event{Type: EventStarted, Message: startMessage(p.total, users)})
+	names := make([]string, len(selected))
+	for i, pkg := range selected {
+		names[i] = pkg.Name
+	}
+	client := e.runClient(users, names, opts, p)
+	before := e.measure(client, p)
+
+	for _, user := range users {
+		if err := e.execute(client, user, selected, packs, opts, p); err != nil {
+			return nil, err
+		}
+	}
+
+	e.refreshAfter(opts, p)
+	e.measureAfter(client, before, p)
+	e.writeReports(opts, p)
+	p.finish()
+
+	return p.summary, nil
+}
+
+// startMessage describes a run about to start
+func startMessage(count int, users []string) string {
+	if len(users) == 1 {
+		return fmt.Sprintf("Removing %d packages", count)
+	}
+	return fmt.Sprintf("Removing %d packages for users %s", count, strings.Join(users, ", "))
+}
+
+// refreshAfter retakes the inventory of the primary user once a run changed
+// the device
+func (e *Engine) refreshAfter(opts Options, p *progress) {
+	if opts.DryRun {
+		return
+	}
+	if _, err := e.Refresh(); err != nil {
+		p.emit(Event{Type: EventWarning, Message: err.Error()})
+	}
+}
+
+// execute removes packages for one user through client and records them in
+// a backup archive together with the packs they came from. Dry runs go to a
+// simulated device, or only pretend when client is nil.
+func (e *Engine) execute(client *adb.Client, userID string, selected []*packages.Package, packs []backup.PackSource, opts Options, p *progress) error {
+	run, err := backup.NewRun(e.device, userID)
+	if err != nil {
+		return err
+	}
+	run.Manifest.DryRun = opts.DryRun
+	run.Manifest.Packs = append(run.Manifest.Packs, packs...)
+
+	byName := make(map[string]*packages.Package, len(selected))
+	apks := make(map[string]*backup.APKManifest)
+	took := make(map[string]time.Duration)
+	record := func(name string, result string, err error) {
+		res := byName[name].RunResult(result)
+		res.DurationMS = took[name].Milliseconds()
+		res.APK = apks[name]
+		if err != nil {
+			res.Error = err.Error()
+		}
+		run.Record(res)
+
+		switch result {
+		case backup.ResultSuccess:
+			p.summary.Success++
+		case backup.ResultDryRun:
+			p.summary.DryRun++
+		case backup.ResultFailed:
+			p.summary.Failed++
+		case backup.ResultSkipped:
+			p.summary.Skipped++
+		}
+		p.done++
+		p.emit(Event{Type: EventPackage, Package: name, Result: result, Message: res.Error})
+	}
+
+	var inv *adb.Inventory
+	if client != nil {
+		inv, err = client.Inventory(userID)
+		if err != nil {
+			run.Discard()
+			return err
+		}
+	}
+
+	var cmds []adb.BatchCommand
+	for _, pkg := range selected {
+		byName[pkg.Name] = pkg
+
+		if client == nil {
+			record(pkg.Name, backup.ResultDryRun, nil)
+			continue
+		}
+
+		switch reason := skipReason(inv, pkg); reason {
+		case "":
+			cmds = append(cmds, adb.BatchCommand{Package: pkg.Name, Action: pkg.GetAction()})
+		case reasonNotInstalled:
+			record(pkg.Name, backup.ResultSkipped, nil)
+		default:
+			record(pkg.Name, backup.ResultSkipped, errors.New(reason))
+		}
+	}
+
+	if opts.BackupAPKs && !opts.DryRun && len(cmds) > 0 {
+		backedUp := cmds[:0]
+		for _, bc := range cmds {
+			if bc.Action != adb.ActionUninstall {
+				backedUp = append(backedUp, bc)
+				continue
+			}
+			apk, err := run.SaveAPKs(e.client, bc.Package)
+			if err != nil {
+				record(bc.Package, backup.ResultFailed, fmt.Errorf("backup failed: %w", err))
+				continue
+			}
+			apks[bc.Package] = apk
+			p.emit(Event{Type: EventBackup, Package: bc.Package})
+			backedUp = append(backedUp, bc)
+		}
+		cmds = backedUp
+	}
+
+	if len(cmds) > 0 {
+		_, err := client.RunBatch(userID, cmds, func(res adb.BatchResult) {
+			took[res.Package] = res.Duration
+			switch {
+			case res.Success && opts.DryRun:
+				record(res.Package, backup.ResultDryRun, nil)
+			case res.Success:
+				record(res.Package, backup.ResultSuccess, nil)
+			default:
+				record(res.Package, backup.ResultFailed, res.Err)
+			}
+		})
+		if err != nil {
+			p.emit(Event{Type: EventWarning, Message: err.Error()})
+		}
+	}
+
+	archive, err := run.Write(opts.BackupDir)
+	if err != nil {
+		p.emit(Event{Type: EventWarning, Message: err.Error()})
+	} else {
+		p.summary.Archives = append(p.summary.Archives, archive)
+	}
+
+	return nil
+}
+
+// Restore reinstalls every package a past run removed for the user of the
+// run, reporting progress through onEvent. Archives from another device are
+// refused unless forced, and archives that do not match their manifest
+// always are.
+func (e *Engine) Restore(archive *backup.Archive, force bool, onEvent func(Event)) (*Summary, error) {
+	if err := archive.CheckDevice(e.device, force); err != nil {
+		return nil, err
+	}
+
+	pkgs := archive.RemovedPackages()
+	summary := &Summary{}
+	done := 0
+
+	emit := func(ev Event) {
+		ev.Time = time.Now()
+		ev.Done = done
+		ev.Total = len(pkgs)
+		if onEvent != nil {
+			onEvent(ev)
+		}
+	}
+
+	emit(Event{Type: EventStarted, Message: fmt.Sprintf("Restoring %d packages", len(pkgs))})
+
+	err := archive.RestoreAll(e.client, pkgs, archive.Manifest.UserID, func(pkg string, err error) {
+		done++
+		if err != nil {
+			summary.Failed++
+			emit(Event{Type: EventRestore, Package: pkg, Result: backup.ResultFailed, Message: err.Error()})
+			return
+		}
+		summary.Success++
+		emit(Event{Type: EventRestore, Package: pkg, Result: backup.ResultSuccess})
+	})
+	if err != nil && done == 0 {
+		// The archive could not be extracted, nothing was installed
+		return nil, err
+	}
+
+	if _, err := e.Refresh(); err != nil {
+		emit(Event{Type: EventWarning, Message: err.Error()})
+	}
+
+	emit(Event{Type: EventFinished, Message: fmt.Sprintf("%d restored, %d failed", summary.Success, summary.Failed)})
+
+	return summary, nil
+}
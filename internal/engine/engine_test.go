@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/testutil"
+)
+
+// fixtureEngine replays a recorded session with the packs of
+// testdata/packs.txt. wrap, if given, wraps the transport the session is
+// replayed or recorded through.
+func fixtureEngine(t *testing.T, session string, wrap func(adb.Transport) adb.Transport) *Engine {
+	t.Helper()
+	transport := testutil.Session(t, session)
+	if wrap != nil {
+		transport = wrap(transport)
+	}
+	client, device := testutil.Client(t, transport)
+
+	eng, err := ForDevice(client, device, testutil.Config(t, filepath.Join("testdata", "packs.txt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eng
+}
+
+// TestRunAndRestore removes a package and one the device refuses to remove,
+// then restores the run from its archive, which is refused when it is
+// corrupt or taken on another device unless forced
+func TestRunAndRestore(t *testing.T) {
+	eng := fixtureEngine(t, "run.jsonl", nil)
+	eng.Manager().SetSelected("com.example.bloat", true)
+	eng.Manager().SetSelected("com.example.stuck", true)
+
+	results := make(map[string]Event)
+	summary, err := eng.Run(Options{BackupDir: t.TempDir()}, func(ev Event) {
+		if ev.Type == EventPackage {
+			results[ev.Package] = ev
+		}
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if summary.Success != 1 || summary.Failed != 1 || len(summary.Archives) != 1 {
+		t.Fatalf("summary = %+v, want 1 removed, 1 failed and one archive", summary)
+	}
+
+	tests := []struct {
+		pkg     string
+		result  string
+		message string
+	}{
+		{pkg: "com.example.bloat", result: backup.ResultSuccess},
+		{pkg: "com.example.stuck", result: backup.ResultFailed, message: "DELETE_FAILED_INTERNAL_ERROR"},
+	}
+	for _, tt := range tests {
+		ev := results[tt.pkg]
+		if ev.Result != tt.result || !strings.Contains(ev.Message, tt.message) {
+			t.Errorf("%s = %s %q, want %s %q", tt.pkg, ev.Result, ev.Message, tt.result, tt.message)
+		}
+	}
+	if eng.Manager().GetPackage("com.example.bloat").Installed {
+		t.Error("com.example.bloat still shown as installed after the run")
+	}
+
+	restores := []struct {
+		name    string
+		change  func(*backup.Manifest)
+		force   bool
+		wantErr error
+	}{
+		{
+			name: "corrupt archive",
+			change: func(m *backup.Manifest) {
+				m.Checksums = map[string]string{"apks/com.example.bloat/base.apk": strings.Repeat("0", 64)}
+			},
+			force:   true,
+			wantErr: backup.ErrCorruptArchive,
+		},
+		{
+			name:    "another device",
+			change:  func(m *backup.Manifest) { m.Device.Serial = "other-phone" },
+			wantErr: backup.ErrDeviceMismatch,
+		},
+		{
+			name:   "another device, forced",
+			change: func(m *backup.Manifest) { m.Device.Serial = "other-phone" },
+			force:  true,
+		},
+	}
+	for _, tt := range restores {
+		archive, err := backup.OpenArchive(summary.Archives[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.change(archive.Manifest)
+
+		restored, err := eng.Restore(archive, tt.force, nil)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: Restore = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if eng.Manager().GetPackage("com.example.bloat").Installed {
+				t.Errorf("%s: com.example.bloat restored", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Restore: %v", tt.name, err)
+		}
+		if restored.Success != 1 || restored.Failed != 0 {
+			t.Errorf("%s: restore = %+v, want 1 restored", tt.name, restored)
+		}
+		if !eng.Manager().GetPackage("com.example.bloat").Installed {
+			t.Errorf("%s: com.example.bloat not shown as installed after the restore", tt.name)
+		}
+	}
+}
+
+// failTransport fails the adb commands containing a string
+type failTransport struct {
+	adb.Transport
+	fail string
+}
+
+func (f *failTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if f.fail != "" && strings.Contains(strings.Join(args, " "), f.fail) {
+		return &adb.ExitError{Code: 1}
+	}
+	return f.Transport.Run(args, stdin, stdout, stderr)
+}
+
+// TestDryRun plays a dry run on a simulated copy of the device, or only
+// lists the packages when the device cannot be captured
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		fail    string
+		results map[string]string
+		reason  string
+	}{
+		{
+			name:    "simulated",
+			session: "dryrun.jsonl",
+			results: map[string]string{"com.example.bloat": backup.ResultDryRun, "com.example.admin": backup.ResultFailed},
+		},
+		{
+			name:    "not simulated",
+			session: "dryrun_facts_failed.jsonl",
+			fail:    "dumpsys package",
+			results: map[string]string{"com.example.bloat": backup.ResultDryRun, "com.example.admin": backup.ResultDryRun},
+			reason:  "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := fixtureEngine(t, tt.session, func(transport adb.Transport) adb.Transport {
+				return &failTransport{Transport: transport, fail: tt.fail}
+			})
+			for name := range tt.results {
+				eng.Manager().SetSelected(name, true)
+			}
+
+			results := make(map[string]string)
+			var finished string
+			summary, err := eng.Run(Options{DryRun: true, BackupDir: t.TempDir()}, func(ev Event) {
+				switch ev.Type {
+				case EventPackage:
+					results[ev.Package] = ev.Result
+				case EventFinished:
+					finished = ev.Message
+				}
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+
+			wouldRemove := 0
+			for name, want := range tt.results {
+				if results[name] != want {
+					t.Errorf("%s = %s, want %s", name, results[name], want)
+				}
+				if want == backup.ResultDryRun {
+					wouldRemove++
+				}
+			}
+			if summary.DryRun != wouldRemove || summary.Success != 0 {
+				t.Errorf("summary = %+v, want %d counted as would be removed", summary, wouldRemove)
+			}
+			if !strings.Contains(finished, fmt.Sprintf("%d would be removed", wouldRemove)) {
+				t.Errorf("finished = %q, want it to count what would be removed", finished)
+			}
+			if !strings.Contains(summary.NotSimulated, tt.reason) || (tt.reason == "") != (summary.NotSimulated == "") {
+				t.Errorf("not simulated = %q, want %q", summary.NotSimulated, tt.reason)
+			}
+			if simulated := !strings.Contains(finished, "not simulated"); simulated != (tt.reason == "") {
+				t.Errorf("finished = %q, want it to tell whether the run was simulated", finished)
+			}
+		})
+	}
+}
+
+// TestDiscover lists the packages no pack covers, scored as unrated apps
+// when the device facts cannot be read
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  string
+		fail     string
+		scores   map[string]int
+		unscored string
+	}{
+		{
+			name:    "scored",
+			session: "discover.jsonl",
+			scores:  map[string]int{"android": 35, "com.example.notes": 10},
+		},
+		{
+			name:     "unscored",
+			session:  "discover_unscored.jsonl",
+			fail:     "dumpsys package",
+			scores:   map[string]int{"android": 35, "com.example.notes": 10},
+			unscored: "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := fixtureEngine(t, tt.session, func(transport adb.Transport) adb.Transport {
+				return &failTransport{Transport: transport, fail: tt.fail}
+			})
+
+			d, err := eng.Discover()
+			if err != nil {
+				t.Fatalf("Discover: %v", err)
+			}
+			if len(d.Packages) != len(tt.scores) {
+				t.Fatalf("discovered %+v, want %d packages", d.Packages, len(tt.scores))
+			}
+			for _, found := range d.Packages {
+				want, ok := tt.scores[found.Name]
+				if !ok || found.Score == nil || found.Score.Value < want {
+					t.Errorf("%s scored %v, want at least %d", found.Name, found.Score, want)
+				}
+			}
+			if !strings.Contains(d.Unscored, tt.unscored) || (tt.unscored == "") != (d.Unscored == "") {
+				t.Errorf("unscored = %q, want %q", d.Unscored, tt.unscored)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+)
+
+// Impacts returns how removing the selected packages affects the packages
+// left installed, following the relations the packs declare and the facts
+// the device reports. The relations of the packs are returned even when the
+// device cannot be asked.
+func (e *Engine) Impacts() ([]packages.Impact, error) {
+	facts, err := e.deviceFacts()
+	if err != nil {
+		return e.manager.Impacts(nil), err
+	}
+	return e.manager.Impacts(facts), nil
+}
+
+// IncludeRelated selects the packages the impacts say can be removed along
+// with the selected ones and returns their names
+func (e *Engine) IncludeRelated(impacts []packages.Impact) []string {
+	return e.manager.IncludeRelated(impacts)
+}
+
+// deviceFacts returns the facts of the packages installed for the user,
+// read once per inventory
+func (e *Engine) deviceFacts() (map[string]*adb.PackageFacts, error) {
+	if e.facts != nil {
+		return e.facts, nil
+	}
+
+	all, err := e.client.PackageFacts()
+	if err != nil {
+		return nil, err
+	}
+	inv, err := e.client.Inventory(e.device.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	e.facts = make(map[string]*adb.PackageFacts)
+	for name, facts := range all {
+		if inv.IsInstalled(name) {
+			e.facts[name] = facts
+		}
+	}
+	return e.facts, nil
+}
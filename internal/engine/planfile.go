@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+)
+
+// PlanVersion is the format version of plan files
+const PlanVersion = 1
+
+// Skip reasons for selected packages that cannot be acted on
+const (
+	reasonNotInstalled = "not installed"
+	reasonDisabled     = "already disabled"
+)
+
+// ResultPlanned is the result of a plan action that has not run yet
+const ResultPlanned = "planned"
+
+// ErrPlanMismatch is returned when a plan no longer matches its device
+var ErrPlanMismatch = errors.New("plan does not match the device")
+
+// PlanFile is a reviewable description of a run on one device. It carries no
+// timestamps, so the same selection on the same device always produces the
+// same file.
+type PlanFile struct {
+	Version   int                 `json:"version"`
+	Device    backup.DeviceInfo   `json:"device"`
+	Profile   string              `json:"profile,omitempty"`
+	Users     []string            `json:"users"`
+	Packs     []backup.PackSource `json:"packs"`
+	Inventory map[string]string   `json:"inventory"` // user -> checksum of the installed packages
+	Actions   []PlanItem          `json:"actions"`
+	Skipped   []PlanItem          `json:"skipped"`
+}
+
+// relPath returns a pack path relative to the config directory, so a plan
+// made in one checkout applies in another. Paths on another volume stay
+// absolute.
+func (e *Engine) relPath(path string) string {
+	if e.dir == "" || path == "" {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(e.dir, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return abs
+}
+
+// absPath resolves a pack path of a plan file against the config directory
+func (e *Engine) absPath(path string) string {
+	if e.dir == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(e.dir, filepath.FromSlash(path))
+}
+
+// PlanFile builds a plan file for the current selection
+func (e *Engine) PlanFile() (*PlanFile, error) {
+	items, err := e.Plan()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &PlanFile{
+		Version:   PlanVersion,
+		Device:    backup.NewDeviceInfo(e.device),
+		Profile:   e.profile,
+		Users:     e.Users(),
+		Packs:     []backup.PackSource{},
+		Inventory: make(map[string]string),
+		Actions:   []PlanItem{},
+		Skipped:   []PlanItem{},
+	}
+
+	for _, user := range plan.Users {
+		inv, err := e.client.Inventory(user)
+		if err != nil {
+			return nil, err
+		}
+		plan.Inventory[user] = inv.Checksum()
+	}
+
+	for _, source := range e.manager.Sources() {
+		pack, err := backup.NewPackSource(source)
+		if err != nil {
+			return nil, err
+		}
+		pack.Path = e.relPath(pack.Path)
+		plan.Packs = append(plan.Packs, pack)
+	}
+
+	for _, item := range items {
+		item.Source = e.relPath(item.Source)
+		if item.Result == backup.ResultSkipped {
+			plan.Skipped = append(plan.Skipped, item)
+		} else {
+			plan.Actions = append(plan.Actions, item)
+		}
+	}
+
+	return plan, nil
+}
+
+// Write encodes the plan as indented JSON
+func (p *PlanFile) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	return nil
+}
+
+// Save writes the plan to a file
+func (p *PlanFile) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+	defer file.Close()
+
+	if err := p.Write(file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// LoadPlan reads a plan file
+func LoadPlan(filename string) (*PlanFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var plan PlanFile
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", plan.Version)
+	}
+
+	return &plan, nil
+}
+
+// CheckPlan retakes the inventory of every user of the plan and lists every
+// way the device has drifted from it. An empty list means the plan can be
+// applied as is.
+func (e *Engine) CheckPlan(plan *PlanFile) ([]string, error) {
+	var problems []string
+
+	if plan.Device.Serial != e.device.ID {
+		problems = append(problems, fmt.Sprintf("plan was made for device %s, this is %s", plan.Device.Serial, e.device.ID))
+	}
+	if plan.Device.Fingerprint != e.device.Fingerprint {
+		problems = append(problems, fmt.Sprintf("device fingerprint changed: plan has %q, device has %q",
+			plan.Device.Fingerprint, e.device.Fingerprint))
+	}
+
+	pinned := make(map[string]bool)
+	for _, item := range plan.Actions {
+		if pkg := e.manager.GetPackage(item.Package); pkg != nil && pkg.Pin == packages.PinNever && !pinned[item.Package] {
+			problems = append(problems, fmt.Sprintf("%s is pinned as never-remove", item.Package))
+			pinned[item.Package] = true
+		}
+	}
+
+	for _, user := range plan.Users {
+		inv, err := e.client.RefreshInventory(user)
+		if err != nil {
+			return nil, err
+		}
+
+		drifted := false
+		for _, item := range plan.Actions {
+			if item.User == user && !inv.IsInstalled(item.Package) {
+				problems = append(problems, fmt.Sprintf("%s is no longer installed for user %s", item.Package, user))
+				drifted = true
+			}
+		}
+		for _, item := range plan.Skipped {
+			if item.User == user && item.Reason == reasonNotInstalled && inv.IsInstalled(item.Package) {
+				problems = append(problems, fmt.Sprintf("%s has been installed for user %s since the plan was made", item.Package, user))
+				drifted = true
+			}
+		}
+
+		if !drifted && inv.Checksum() != plan.Inventory[user] {
+			problems = append(problems, fmt.Sprintf("installed packages of user %s changed since the plan was made", user))
+		}
+	}
+
+	if _, err := e.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+// Apply executes exactly the actions of a plan after checking that the device
+// still matches it. The current selection and users of the engine are not
+// used.
+func (e *Engine) Apply(plan *PlanFile, opts Options, onEvent func(Event)) (*Summary, error) {
+	problems, err := e.CheckPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPlanMismatch, strings.Join(problems, "; "))
+	}
+
+	p := &progress{total: len(plan.Actions), dryRun: opts.DryRun, onEvent: onEvent, summary: &Summary{}}
+
+	var targets []userTarget
+	for _, user := range plan.Users {
+		target := userTarget{user: user}
+		for _, item := range plan.Actions {
+			if item.User == user {
+				target.packages = append(target.packages, item.Package)
+			}
+		}
+		targets = append(targets, target)
+	}
+	if err := e.checkDefaults(targets, opts, p); err != nil {
+		return nil, err
+	}
+
+	// Archives record the pack files where they are on this machine
+	packs := make([]backup.PackSource, len(plan.Packs))
+	for i, pack := range plan.Packs {
+		pack.Path = e.absPath(pack.Path)
+		packs[i] = pack
+	}
+
+	p.emit(Event{Type: EventStarted, Message: startMessage(len(plan.Actions), plan.Users)})
+	names := make([]string, len(plan.Actions))
+	for i, item := range plan.Actions {
+		names[i] = item.Package
+	}
+	client := e.runClient(plan.Users, names, opts, p)
+	before := e.measure(client, p)
+
+	for _, user := range plan.Users {
+		var selected []*packages.Package
+		for _, item := range plan.Actions {
+			if item.User != user {
+				continue
+			}
+			selected = append(selected, &packages.Package{
+				Name:        item.Package,
+				Description: item.Description,
+				Category:    item.Category,
+				RiskLevel:   item.RiskLevel,
+				Source:      e.absPath(item.Source),
+				Action:      item.Action,
+				Installed:   true,
+				Selected:    true,
+			})
+		}
+		if len(selected) == 0 {
+			continue
+		}
+
+		if err := e.execute(client, user, selected, packs, opts, p); err != nil {
+			return nil, err
+		}
+	}
+
+	e.refreshAfter(opts, p)
+	e.measureAfter(client, before, p)
+	e.writeReports(opts, p)
+	p.finish()
+
+	return p.summary, nil
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"github.com/adb-cleaner/adb-cleaner/internal/report"
+)
+
+// Report builds the report of a run from the archives it wrote
+func (e *Engine) Report(summary *Summary) (*report.Report, error) {
+	r, err := report.Load(summary.Archives)
+	if err != nil {
+		return nil, err
+	}
+	if m := summary.Measured; m != nil {
+		r.Freed = &report.Freed{Storage: m.StorageFreed(), Memory: m.MemoryFreed()}
+	}
+	r.NotSimulated = summary.NotSimulated
+	return r, nil
+}
+
+// writeReports writes the report of a run in the formats the options ask
+// for and records the files in its summary
+func (e *Engine) writeReports(opts Options, p *progress) {
+	if len(opts.ReportFormats) == 0 || len(p.summary.Archives) == 0 {
+		return
+	}
+
+	r, err := e.Report(p.summary)
+	if err != nil {
+		p.emit(Event{Type: EventWarning, Message: "report is not written: " + err.Error()})
+		return
+	}
+	files, err := r.Save(opts.ReportDir, opts.ReportFormats)
+	if err != nil {
+		p.emit(Event{Type: EventWarning, Message: "report is not written: " + err.Error()})
+	}
+	p.summary.Reports = append(p.summary.Reports, files...)
+}
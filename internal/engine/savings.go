@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// Savings is the storage and memory removing the selected packages is
+// expected to free, in bytes
+type Savings struct {
+	Storage  int64 `json:"storage"`
+	Memory   int64 `json:"memory"`
+	Running  int   `json:"running"`  // selected packages with running processes
+	Unsized  int   `json:"unsized"`  // selected packages whose size is unknown
+	Packages int   `json:"packages"` // selected packages installed for the user
+}
+
+// Measurement is the free storage and memory of the device before and after
+// a run
+type Measurement struct {
+	Before *adb.DeviceUsage `json:"before"`
+	After  *adb.DeviceUsage `json:"after"`
+}
+
+// StorageFreed returns how much the free storage grew
+func (m *Measurement) StorageFreed() int64 {
+	return m.After.DataFree - m.Before.DataFree
+}
+
+// MemoryFreed returns how much the available memory grew
+func (m *Measurement) MemoryFreed() int64 {
+	return m.After.MemAvailable - m.Before.MemAvailable
+}
+
+// EstimateSavings estimates what removing the selected packages frees for
+// the device user. Uninstalling frees the data and cache of a package, and
+// its code unless it is a system app whose APK stays on the system
+// partition. Disabling frees no storage. Both stop its processes.
+func (e *Engine) EstimateSavings() (*Savings, error) {
+	usage, err := e.client.PackageUsage()
+	if err != nil {
+		return nil, err
+	}
+	inv, err := e.client.Inventory(e.device.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	savings := &Savings{}
+	for _, pkg := range e.manager.GetSelectedPackages() {
+		rec := inv.Get(pkg.Name)
+		if rec == nil || !rec.Installed {
+			continue
+		}
+		savings.Packages++
+
+		u := usage[pkg.Name]
+		if u == nil {
+			// Packages installed since the last storage scan are not listed
+			code, err := e.client.CodeSize(pkg.Name, e.device.UserID)
+			if err != nil {
+				savings.Unsized++
+				continue
+			}
+			u = &adb.PackageUsage{Code: code}
+		}
+
+		savings.Memory += u.Memory
+		if u.Running {
+			savings.Running++
+		}
+		if pkg.GetAction() == adb.ActionDisable {
+			continue
+		}
+		savings.Storage += u.Data + u.Cache
+		if !rec.System {
+			savings.Storage += u.Code
+		}
+	}
+	return savings, nil
+}
+
+// measure reads the free storage and memory of the device a run goes to.
+// Dry runs that only pretend are not measured.
+func (e *Engine) measure(client *adb.Client, p *progress) *adb.DeviceUsage {
+	if client == nil {
+		return nil
+	}
+	usage, err := client.DeviceUsage()
+	if err != nil {
+		p.emit(Event{Type: EventWarning, Message: "savings are not measured: " + err.Error()})
+		return nil
+	}
+	return usage
+}
+
+// measureAfter records what a run freed in its summary
+func (e *Engine) measureAfter(client *adb.Client, before *adb.DeviceUsage, p *progress) {
+	if before == nil {
+		return
+	}
+	if after := e.measure(client, p); after != nil {
+		p.summary.Measured = &Measurement{Before: before, After: after}
+	}
+}
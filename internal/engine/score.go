@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+)
+
+// ScorePackages scores the loaded packages from the facts and default apps
+// the device reports. Packages added later are scored as they are added.
+func (e *Engine) ScorePackages() error {
+	scorer, err := e.Scorer()
+	if err != nil {
+		return err
+	}
+	e.manager.ApplyScores(scorer)
+	return nil
+}
+
+// Scorer returns a scorer for the packages installed for the user, reading
+// the device once per inventory
+func (e *Engine) Scorer() (*packages.Scorer, error) {
+	facts, err := e.deviceFacts()
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := e.Defaults(e.device.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]string)
+	for name := range facts {
+		if held := defaults.Held(name); len(held) > 0 {
+			roles[name] = held
+		}
+	}
+	return packages.NewScorer(facts, roles), nil
+}
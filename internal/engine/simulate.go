@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// runClient returns the client a run of pkgs goes through. Dry runs go to a
+// simulated copy of the device so they report what the device would
+// actually do; when the device cannot be captured they only pretend, nil is
+// returned and the summary says why.
+func (e *Engine) runClient(users []string, pkgs []string, opts Options, p *progress) *adb.Client {
+	if !opts.DryRun {
+		return e.client
+	}
+
+	simulated, err := e.Simulate(users, pkgs)
+	if err != nil {
+		p.summary.NotSimulated = err.Error()
+		p.emit(Event{Type: EventWarning, Message: "dry run is not simulated, the packages are only listed: " + err.Error()})
+		return nil
+	}
+	return simulated
+}
+
+// Simulate returns a client for a simulated copy of the device holding the
+// state of the given packages for the given users. It reuses the device
+// facts and inventory the engine has read already. Changes made through it
+// never reach the device.
+func (e *Engine) Simulate(users []string, pkgs []string) (*adb.Client, error) {
+	facts, err := e.deviceFacts()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := e.client.RunSnapshot(e.device, users, pkgs, facts)
+	if err != nil {
+		return nil, err
+	}
+	return adb.NewClientWithTransport(adb.NewSimulator(snapshot)).WithSerial(snapshot.Device.Serial), nil
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of the log file in the log directory
+const FileName = "adb-cleaner.log"
+
+// Rotation limits of the log file
+const (
+	MaxSize  = 10 << 20 // bytes written to a file before it is rotated
+	MaxFiles = 5        // rotated files kept next to the current one
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Formats lists every log format
+var Formats = []string{FormatText, FormatJSON}
+
+// Levels lists every log level, most verbose first
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// Options configures logging
+type Options struct {
+	Dir    string
+	Level  string // one of Levels
+	Format string // one of Formats
+	Stderr bool   // mirror records to stderr
+}
+
+// ParseLevel parses a log level name
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	for _, known := range Levels {
+		if strings.EqualFold(name, known) {
+			return level, level.UnmarshalText([]byte(known))
+		}
+	}
+	return level, fmt.Errorf("unknown log level %q, expected %s", name, strings.Join(Levels, ", "))
+}
+
+// CheckFormat checks a log format name
+func CheckFormat(format string) error {
+	for _, known := range Formats {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log format %q, expected %s", format, strings.Join(Formats, ", "))
+}
+
+// Setup makes the default slog logger write to a rotating file in the log
+// directory, and to stderr as well when asked to. The returned closer closes
+// the file.
+func Setup(opts Options) (io.Closer, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckFormat(opts.Format); err != nil {
+		return nil, err
+	}
+
+	file, err := OpenRotator(filepath.Join(opts.Dir, FileName), MaxSize, MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	handlers := []slog.Handler{newHandler(file, opts.Format, handlerOpts)}
+	if opts.Stderr {
+		handlers = append(handlers, newHandler(os.Stderr, opts.Format, handlerOpts))
+	}
+
+	var handler slog.Handler = handlers[0]
+	if len(handlers) > 1 {
+		handler = fanout(handlers)
+	}
+	slog.SetDefault(slog.New(handler))
+	return file, nil
+}
+
+// newHandler creates a handler writing records in a format
+func newHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// fanout passes every record on to several handlers
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, record.Level) {
+			errs = append(errs, h.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// Truncate shortens text for a log attribute, trimming surrounding space and
+// cutting it at max bytes
+func Truncate(text string, max int) string {
+	text = strings.TrimSpace(text)
+	if len(text) <= max {
+		return text
+	}
+	return text[:max] + "..."
+}
@@ -0,0 +1,164 @@
+package packages
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sahilm/fuzzy"
+)
+
+// Installed states a Filter can narrow the list to
+const (
+	StatusInstalled    = "installed"
+	StatusNotInstalled = "not installed"
+)
+
+// Filter narrows the package list. Empty fields match every package.
+type Filter struct {
+	Query     string // fuzzy matched against the name, or /regexp
+	Category  string
+	RiskLevel string
+	Status    string // StatusInstalled or StatusNotInstalled
+	Source    string // pack file the package was loaded from
+}
+
+// IsZero reports whether the filter matches every package
+func (f Filter) IsZero() bool {
+	return f == Filter{}
+}
+
+// matchFields reports whether a package passes every field but the query
+func (f Filter) matchFields(pkg *Package) bool {
+	if f.Category != "" && pkg.Category != f.Category {
+		return false
+	}
+	if f.RiskLevel != "" && pkg.Risk() != f.RiskLevel {
+		return false
+	}
+	if f.Source != "" && pkg.Source != f.Source {
+		return false
+	}
+
+	switch f.Status {
+	case StatusInstalled:
+		return pkg.Installed
+	case StatusNotInstalled:
+		return !pkg.Installed
+	}
+	return true
+}
+
+// Match is a package passing a filter, with the byte offsets of the name
+// characters the query matched
+type Match struct {
+	Package     *Package
+	NameIndexes []int
+}
+
+// Search returns the packages passing a filter. Without a query they keep
+// the list order. A query is matched fuzzily against the names, best match
+// first, followed by the packages whose description, notes or tags contain
+// it. A query starting with "/" is a case-insensitive regular expression
+// matched against the name, description, notes and tags.
+func (m *Manager) Search(f Filter) ([]Match, error) {
+	var candidates []*Package
+	for _, pkg := range m.packages {
+		if f.matchFields(pkg) {
+			candidates = append(candidates, pkg)
+		}
+	}
+
+	switch {
+	case f.Query == "":
+		matches := make([]Match, len(candidates))
+		for i, pkg := range candidates {
+			matches[i] = Match{Package: pkg}
+		}
+		return matches, nil
+
+	case strings.HasPrefix(f.Query, "/"):
+		return searchRegexp(candidates, f.Query)
+	}
+
+	return searchFuzzy(candidates, f.Query), nil
+}
+
+// FilterPackages returns the packages passing a filter, in the order of
+// Search. An invalid regular expression matches nothing.
+func (m *Manager) FilterPackages(f Filter) []*Package {
+	matches, err := m.Search(f)
+	if err != nil {
+		return nil
+	}
+
+	results := make([]*Package, len(matches))
+	for i, match := range matches {
+		results[i] = match.Package
+	}
+	return results
+}
+
+// names lets fuzzy search the names of packages
+type names []*Package
+
+func (n names) String(i int) string {
+	return n[i].Name
+}
+
+func (n names) Len() int {
+	return len(n)
+}
+
+// searchText returns the text besides the name a query is matched against
+func searchText(pkg *Package) string {
+	return strings.Join(append([]string{pkg.Description, pkg.Notes}, pkg.Tags...), "\n")
+}
+
+func searchFuzzy(candidates []*Package, query string) []Match {
+	var matches []Match
+	matched := make(map[*Package]bool)
+
+	for _, found := range fuzzy.FindFrom(query, names(candidates)) {
+		pkg := candidates[found.Index]
+		matches = append(matches, Match{Package: pkg, NameIndexes: found.MatchedIndexes})
+		matched[pkg] = true
+	}
+
+	query = strings.ToLower(query)
+	for _, pkg := range candidates {
+		if !matched[pkg] && strings.Contains(strings.ToLower(searchText(pkg)), query) {
+			matches = append(matches, Match{Package: pkg})
+		}
+	}
+
+	return matches
+}
+
+func searchRegexp(candidates []*Package, query string) ([]Match, error) {
+	pattern := strings.TrimPrefix(query, "/")
+	if len(pattern) > 1 {
+		// A closing slash is optional
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+
+	var matches []Match
+	for _, pkg := range candidates {
+		if loc := re.FindStringIndex(pkg.Name); loc != nil {
+			indexes := make([]int, 0, loc[1]-loc[0])
+			for i := loc[0]; i < loc[1]; i++ {
+				indexes = append(indexes, i)
+			}
+			matches = append(matches, Match{Package: pkg, NameIndexes: indexes})
+		} else if re.MatchString(searchText(pkg)) {
+			matches = append(matches, Match{Package: pkg})
+		}
+	}
+
+	return matches, nil
+}
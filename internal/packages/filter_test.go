@@ -0,0 +1,107 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/testutil"
+)
+
+// newTestManager loads a pack written from lines, with every package
+// installed
+func newTestManager(t *testing.T, lines ...string) *Manager {
+	t.Helper()
+	m := NewManager()
+	if _, err := m.LoadPackages(testutil.Packs(t, lines...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range m.GetPackages() {
+		pkg.Installed = true
+	}
+	return m
+}
+
+func TestSearch(t *testing.T) {
+	m := newTestManager(t,
+		"com.example.bloat # Bloat | Ads | SAFE",
+		"com.bigloader.oat # Big loader | Ads | RISKY",
+		"com.example.weather # Shows ads and bloat | Tools | SAFE",
+		"com.example.camera # Camera | Media | DANGER",
+	)
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		want    []string
+		indexes []int // name indexes matched in the first result
+		wantErr bool
+	}{
+		{
+			name:   "no query keeps the list order",
+			filter: Filter{},
+			want:   []string{"com.example.bloat", "com.bigloader.oat", "com.example.weather", "com.example.camera"},
+		},
+		{
+			name:    "closest fuzzy match first, then descriptions",
+			filter:  Filter{Query: "bloat"},
+			want:    []string{"com.example.bloat", "com.bigloader.oat", "com.example.weather"},
+			indexes: []int{12, 13, 14, 15, 16},
+		},
+		{
+			name:   "fields narrow the fuzzy match",
+			filter: Filter{Query: "bloat", RiskLevel: "SAFE"},
+			want:   []string{"com.example.bloat", "com.example.weather"},
+		},
+		{
+			name:    "regexp with a closing slash",
+			filter:  Filter{Query: "/^com\\.example\\.[bc]/"},
+			want:    []string{"com.example.bloat", "com.example.camera"},
+			indexes: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		},
+		{
+			name:   "regexp without a closing slash",
+			filter: Filter{Query: "/CAMERA$"},
+			want:   []string{"com.example.camera"},
+		},
+		{
+			name:   "regexp matching a description only",
+			filter: Filter{Query: "/ads and/"},
+			want:   []string{"com.example.weather"},
+		},
+		{
+			name:   "a lone slash matches everything",
+			filter: Filter{Query: "/"},
+			want:   []string{"com.example.bloat", "com.bigloader.oat", "com.example.weather", "com.example.camera"},
+		},
+		{
+			name:   "a slash after the opening one is the pattern",
+			filter: Filter{Query: "//"},
+			want:   nil,
+		},
+		{
+			name:    "invalid regexp",
+			filter:  Filter{Query: "/[a-"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := m.Search(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Search = %v, want error %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, match := range matches {
+				got = append(got, match.Package.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search = %v, want %v", got, tt.want)
+			}
+			if tt.indexes != nil && !reflect.DeepEqual(matches[0].NameIndexes, tt.indexes) {
+				t.Errorf("name indexes = %v, want %v", matches[0].NameIndexes, tt.indexes)
+			}
+		})
+	}
+}
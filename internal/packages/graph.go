@@ -0,0 +1,205 @@
+package packages
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// Kinds of relations between packages
+const (
+	RelationRequires   = "requires"
+	RelationBreaks     = "breaks"
+	RelationOverlay    = "overlayTarget"
+	RelationSharedUser = "sharedUser"
+	RelationProvider   = "provider"
+)
+
+// parseRelations reads the relations field of a pack line, such as
+// "requires=com.a,com.b overlayTarget=android"
+func parseRelations(pkg *Package, field string) error {
+	for _, item := range strings.Fields(field) {
+		kind, value, ok := strings.Cut(item, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid relation %q, expected kind=package[,package]", item)
+		}
+		names := strings.Split(value, ",")
+
+		switch kind {
+		case RelationRequires:
+			pkg.Requires = append(pkg.Requires, names...)
+		case RelationBreaks:
+			pkg.Breaks = append(pkg.Breaks, names...)
+		case RelationOverlay:
+			if len(names) != 1 {
+				return fmt.Errorf("%s takes a single package", RelationOverlay)
+			}
+			pkg.OverlayTarget = value
+		default:
+			return fmt.Errorf("unknown relation %q, expected %s, %s or %s", kind, RelationRequires, RelationBreaks, RelationOverlay)
+		}
+	}
+	return nil
+}
+
+// formatRelations formats the relations of a package the way parseRelations
+// reads them
+func formatRelations(pkg *Package) string {
+	var items []string
+	if len(pkg.Requires) > 0 {
+		items = append(items, RelationRequires+"="+strings.Join(pkg.Requires, ","))
+	}
+	if len(pkg.Breaks) > 0 {
+		items = append(items, RelationBreaks+"="+strings.Join(pkg.Breaks, ","))
+	}
+	if pkg.OverlayTarget != "" {
+		items = append(items, RelationOverlay+"="+pkg.OverlayTarget)
+	}
+	return strings.Join(items, " ")
+}
+
+// Impact is a way removing a selected package affects a package left on the
+// device
+type Impact struct {
+	Package  string `json:"package"`           // selected package
+	Related  string `json:"related,omitempty"` // affected package, empty when there are many
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	Include  bool   `json:"include,omitempty"`  // Related is useless without Package and can go with it
+	Inferred bool   `json:"inferred,omitempty"` // reported by the device rather than declared by a pack
+}
+
+// Impacts returns how removing the selected packages affects the packages
+// left on the device, following the relations the packs declare and the
+// facts the device reports. device may be nil.
+func (m *Manager) Impacts(device map[string]*adb.PackageFacts) []Impact {
+	loaded := make(map[string]*Package, len(m.packages))
+	for _, pkg := range m.packages {
+		loaded[pkg.Name] = pkg
+	}
+
+	// remains reports whether a package stays on the device after the run
+	remains := func(name string) bool {
+		if pkg, ok := loaded[name]; ok {
+			return pkg.Installed && !pkg.Selected
+		}
+		return device[name] != nil
+	}
+
+	requiredBy := make(map[string][]string)
+	overlays := make(map[string][]string)
+	inferredOverlay := make(map[string]bool)
+	for _, pkg := range m.packages {
+		for _, required := range pkg.Requires {
+			requiredBy[required] = append(requiredBy[required], pkg.Name)
+		}
+		if pkg.OverlayTarget != "" {
+			overlays[pkg.OverlayTarget] = append(overlays[pkg.OverlayTarget], pkg.Name)
+		}
+	}
+
+	sharedUsers := make(map[string][]string)
+	for name, facts := range device {
+		if facts.OverlayTarget != "" && (loaded[name] == nil || loaded[name].OverlayTarget == "") {
+			overlays[facts.OverlayTarget] = append(overlays[facts.OverlayTarget], name)
+			inferredOverlay[name] = true
+		}
+		if facts.SharedUser != "" {
+			sharedUsers[facts.SharedUser] = append(sharedUsers[facts.SharedUser], name)
+		}
+	}
+
+	var impacts []Impact
+	seen := make(map[Impact]bool)
+	add := func(impact Impact) {
+		if !seen[impact] {
+			seen[impact] = true
+			impacts = append(impacts, impact)
+		}
+	}
+
+	for _, pkg := range m.GetSelectedPackages() {
+		name := pkg.Name
+
+		for _, dependent := range sortedNames(requiredBy[name]) {
+			if remains(dependent) {
+				add(Impact{Package: name, Related: dependent, Kind: RelationRequires, Include: true,
+					Message: fmt.Sprintf("%s requires %s", dependent, name)})
+			}
+		}
+		for _, broken := range pkg.Breaks {
+			if remains(broken) {
+				add(Impact{Package: name, Related: broken, Kind: RelationBreaks,
+					Message: fmt.Sprintf("removing %s breaks %s", name, broken)})
+			}
+		}
+		for _, overlay := range sortedNames(overlays[name]) {
+			if remains(overlay) {
+				add(Impact{Package: name, Related: overlay, Kind: RelationOverlay, Include: true, Inferred: inferredOverlay[overlay],
+					Message: fmt.Sprintf("%s is an overlay of %s", overlay, name)})
+			}
+		}
+
+		facts := device[name]
+		if facts == nil {
+			continue
+		}
+		if facts.SharedUser != "" {
+			var others []string
+			for _, member := range sortedNames(sharedUsers[facts.SharedUser]) {
+				if member != name && remains(member) {
+					others = append(others, member)
+				}
+			}
+			if len(others) > 0 {
+				add(Impact{Package: name, Kind: RelationSharedUser, Inferred: true,
+					Message: fmt.Sprintf("%s shares user ID %s with %s", name, facts.SharedUser, summarize(others, 3))})
+			}
+		}
+		if len(facts.Authorities) > 0 {
+			add(Impact{Package: name, Kind: RelationProvider, Inferred: true,
+				Message: fmt.Sprintf("%s provides %s, which other apps may use", name, summarize(facts.Authorities, 3))})
+		}
+	}
+
+	return impacts
+}
+
+// IncludeRelated selects the related packages the impacts say can be removed
+// along with the selected ones and returns their names. Packages only the
+// device knows are added to the list first.
+func (m *Manager) IncludeRelated(impacts []Impact) []string {
+	var included []string
+	for _, impact := range impacts {
+		if !impact.Include || impact.Related == "" {
+			continue
+		}
+
+		pkg := m.GetPackage(impact.Related)
+		if pkg == nil {
+			pkg = &Package{Name: impact.Related, Source: "related to " + impact.Package, Installed: true}
+			m.AddPackage(pkg)
+		}
+		if !pkg.Selected && pkg.SetSelected(true) {
+			included = append(included, pkg.Name)
+		}
+	}
+	return included
+}
+
+// sortedNames returns a sorted copy of names
+func sortedNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// summarize lists up to max items and counts the rest
+func summarize(items []string, max int) string {
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:max], ", "), len(items)-max)
+}
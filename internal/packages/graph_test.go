@@ -0,0 +1,112 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+func TestImpacts(t *testing.T) {
+	tests := []struct {
+		name     string
+		pack     []string
+		device   map[string]*adb.PackageFacts
+		selected []string
+		impacts  []string // package, kind and related of each impact
+		included []string // selected by IncludeRelated
+		after    []string // impacts left once they are included
+	}{
+		{
+			name: "requires and breaks",
+			pack: []string{
+				"com.example.core # Core | System | RISKY",
+				"com.example.app # App | System | SAFE | requires=com.example.core",
+				"com.example.widget # Widget | System | SAFE",
+				"com.example.bloat # Bloat | Ads | SAFE | breaks=com.example.widget",
+			},
+			selected: []string{"com.example.core", "com.example.bloat"},
+			impacts:  []string{"com.example.core requires com.example.app", "com.example.bloat breaks com.example.widget"},
+			included: []string{"com.example.app"},
+			after:    []string{"com.example.bloat breaks com.example.widget"},
+		},
+		{
+			name: "chain is followed one link at a time",
+			pack: []string{
+				"com.example.a # A | System | SAFE",
+				"com.example.b # B | System | SAFE | requires=com.example.a",
+				"com.example.c # C | System | SAFE | requires=com.example.b",
+			},
+			selected: []string{"com.example.a"},
+			impacts:  []string{"com.example.a requires com.example.b"},
+			included: []string{"com.example.b"},
+			after:    []string{"com.example.b requires com.example.c"},
+		},
+		{
+			name: "cycle",
+			pack: []string{
+				"com.example.a # A | System | SAFE | requires=com.example.b",
+				"com.example.b # B | System | SAFE | requires=com.example.a",
+			},
+			selected: []string{"com.example.a"},
+			impacts:  []string{"com.example.a requires com.example.b"},
+			included: []string{"com.example.b"},
+		},
+		{
+			name: "self requirement",
+			pack: []string{
+				"com.example.a # A | System | SAFE | requires=com.example.a",
+			},
+			selected: []string{"com.example.a"},
+		},
+		{
+			name: "breaks only packages left on the device",
+			pack: []string{
+				"com.example.a # A | System | SAFE | breaks=com.example.b,com.example.gone",
+				"com.example.b # B | System | SAFE | breaks=com.example.a",
+			},
+			selected: []string{"com.example.a", "com.example.b"},
+		},
+		{
+			name: "overlay reported by the device",
+			pack: []string{
+				"com.example.theme # Theme | System | SAFE",
+			},
+			device: map[string]*adb.PackageFacts{
+				"com.example.theme":         {},
+				"com.example.theme.overlay": {OverlayTarget: "com.example.theme"},
+			},
+			selected: []string{"com.example.theme"},
+			impacts:  []string{"com.example.theme overlayTarget com.example.theme.overlay"},
+			included: []string{"com.example.theme.overlay"},
+		},
+	}
+
+	describe := func(impacts []Impact) []string {
+		var described []string
+		for _, impact := range impacts {
+			described = append(described, impact.Package+" "+impact.Kind+" "+impact.Related)
+		}
+		return described
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, tt.pack...)
+			for _, name := range tt.selected {
+				m.SetSelected(name, true)
+			}
+
+			impacts := m.Impacts(tt.device)
+			if got := describe(impacts); !reflect.DeepEqual(got, tt.impacts) {
+				t.Errorf("impacts = %v, want %v", got, tt.impacts)
+			}
+			if got := m.IncludeRelated(impacts); !reflect.DeepEqual(got, tt.included) {
+				t.Errorf("included = %v, want %v", got, tt.included)
+			}
+			if got := describe(m.Impacts(tt.device)); !reflect.DeepEqual(got, tt.after) {
+				t.Errorf("impacts after including = %v, want %v", got, tt.after)
+			}
+		})
+	}
+}
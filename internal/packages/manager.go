@@ -3,9 +3,14 @@ package packages
 import (
 	"bufio"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
-	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
 )
 
 // Package represents a package to be removed
@@ -13,14 +18,90 @@ type Package struct {
 	Name        string
 	Description string
 	Category    string
-	RiskLevel   string // SAFE, RISKY, DANGER
+	RiskLevel   string     // SAFE, RISKY, DANGER
+	Source      string     // pack file the package was loaded from
+	Action      adb.Action // action applied on removal, uninstall when empty
 	Installed   bool
 	Selected    bool
+
+	// Relations declared by the pack
+	Requires      []string // packages it cannot work without
+	Breaks        []string // packages that stop working without it
+	OverlayTarget string   // package it is an overlay of
+
+	// Set from the user override file
+	Notes string
+	Pin   string // PinNever or PinAlways
+	Tags  []string
+
+	// Computed from the device facts, nil until the device is scored
+	Score *Score
+
+	packRisk   string // risk level given by the pack
+	overridden bool   // packRisk is recorded
+}
+
+// GetAction returns the action applied to the package on removal
+func (p *Package) GetAction() adb.Action {
+	if p.Action == "" {
+		return adb.ActionUninstall
+	}
+	return p.Action
+}
+
+// PackRiskLevel returns the risk level the pack gives, before overrides
+func (p *Package) PackRiskLevel() string {
+	if p.overridden {
+		return p.packRisk
+	}
+	return p.RiskLevel
+}
+
+// Risk returns the risk level the package is rated with, or the level of
+// its score when no pack or override rates it
+func (p *Package) Risk() string {
+	if p.RiskLevel == "" && p.Score != nil {
+		return p.Score.Level
+	}
+	return p.RiskLevel
+}
+
+// RunResult returns the record of the package in a run, with the given
+// result and the score it had at the time
+func (p *Package) RunResult(result string) backup.PackageResult {
+	res := backup.PackageResult{
+		Package:     p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		RiskLevel:   p.RiskLevel,
+		Source:      p.Source,
+		Action:      string(p.GetAction()),
+		Result:      result,
+	}
+	if p.Score != nil {
+		res.Score = p.Score.Value
+		res.Reasons = p.Score.Reasons
+	}
+	return res
+}
+
+// SetSelected selects or deselects the package unless its pin forbids it,
+// and reports whether it did
+func (p *Package) SetSelected(selected bool) bool {
+	if (selected && p.Pin == PinNever) || (!selected && p.Pin == PinAlways) {
+		return p.Selected == selected
+	}
+	p.Selected = selected
+	return true
 }
 
 // Manager manages packages
 type Manager struct {
-	packages []*Package
+	packages      []*Package
+	sources       []string
+	overrides     map[string]*Override // package -> user override
+	overridesFile string
+	scorer        *Scorer // set once the device is scored
 }
 
 // NewManager creates a new package manager
@@ -32,6 +113,41 @@ func NewManager() *Manager {
 
 // LoadPackages loads packages from a file
 func (m *Manager) LoadPackages(filename string) ([]*Package, error) {
+	return m.LoadPacks([]string{filename})
+}
+
+// LoadPacks loads packages from several pack files. A package listed in more
+// than one pack keeps the entry of the first one.
+func (m *Manager) LoadPacks(filenames []string) ([]*Package, error) {
+	var packages []*Package
+	seen := make(map[string]bool)
+
+	for _, filename := range filenames {
+		pack, err := parsePack(filename)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("pack loaded", "file", filename, "packages", len(pack))
+
+		for _, pkg := range pack {
+			if !seen[pkg.Name] {
+				seen[pkg.Name] = true
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	m.packages = packages
+	m.sources = append([]string(nil), filenames...)
+	for _, pkg := range packages {
+		m.applyOverride(pkg)
+	}
+	slog.Info("packs loaded", "files", filenames, "packages", len(packages))
+	return packages, nil
+}
+
+// parsePack reads the packages of one pack file
+func parsePack(filename string) ([]*Package, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open packages file: %w", err)
@@ -40,7 +156,9 @@ func (m *Manager) LoadPackages(filename string) ([]*Package, error) {
 
 	var packages []*Package
 	scanner := bufio.NewScanner(file)
+	lineNo := 0
 	for scanner.Scan() {
+		lineNo++
 		line := strings.TrimSpace(scanner.Text())
 
 		// Skip empty lines and comments
@@ -49,9 +167,10 @@ func (m *Manager) LoadPackages(filename string) ([]*Package, error) {
 		}
 
 		// Parse package line
-		// Format: package_name # Description | Category | RiskLevel
+		// Format: package_name # Description | Category | RiskLevel | Relations
 		pkg := &Package{
 			Name:      line,
+			Source:    filename,
 			Selected:  false,
 			Installed: false,
 		}
@@ -74,6 +193,11 @@ func (m *Manager) LoadPackages(filename string) ([]*Package, error) {
 				if len(metaParts) >= 3 {
 					pkg.RiskLevel = strings.TrimSpace(metaParts[2])
 				}
+				if len(metaParts) >= 4 {
+					if err := parseRelations(pkg, metaParts[3]); err != nil {
+						return nil, fmt.Errorf("%s:%d: %w", filename, lineNo, err)
+					}
+				}
 			} else {
 				pkg.Description = metadata
 			}
@@ -86,19 +210,19 @@ func (m *Manager) LoadPackages(filename string) ([]*Package, error) {
 		return nil, fmt.Errorf("error reading packages file: %w", err)
 	}
 
-	m.packages = packages
 	return packages, nil
 }
 
-// UpdateInstalledStatus updates the installed status of packages
-func (m *Manager) UpdateInstalledStatus(installedPackages []string) {
-	installedMap := make(map[string]bool)
-	for _, pkg := range installedPackages {
-		installedMap[pkg] = true
-	}
+// Sources returns the pack files the packages were loaded from
+func (m *Manager) Sources() []string {
+	return m.sources
+}
 
+// UpdateInstalledStatus updates the installed status of packages from a
+// device inventory
+func (m *Manager) UpdateInstalledStatus(inv *adb.Inventory) {
 	for _, pkg := range m.packages {
-		pkg.Installed = installedMap[pkg.Name]
+		pkg.Installed = inv.IsInstalled(pkg.Name)
 	}
 }
 
@@ -107,6 +231,16 @@ func (m *Manager) GetPackages() []*Package {
 	return m.packages
 }
 
+// GetPackage returns the loaded package with the given name, or nil
+func (m *Manager) GetPackage(name string) *Package {
+	for _, pkg := range m.packages {
+		if pkg.Name == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
 // GetSelectedPackages returns selected packages
 func (m *Manager) GetSelectedPackages() []*Package {
 	var selected []*Package
@@ -121,22 +255,22 @@ func (m *Manager) GetSelectedPackages() []*Package {
 // SelectAll selects all packages
 func (m *Manager) SelectAll() {
 	for _, pkg := range m.packages {
-		pkg.Selected = true
+		pkg.SetSelected(true)
 	}
 }
 
 // DeselectAll deselects all packages
 func (m *Manager) DeselectAll() {
 	for _, pkg := range m.packages {
-		pkg.Selected = false
+		pkg.SetSelected(false)
 	}
 }
 
 // SelectByRiskLevel selects packages by risk level
 func (m *Manager) SelectByRiskLevel(riskLevel string) {
 	for _, pkg := range m.packages {
-		if pkg.RiskLevel == riskLevel {
-			pkg.Selected = true
+		if pkg.Risk() == riskLevel {
+			pkg.SetSelected(true)
 		}
 	}
 }
@@ -145,7 +279,7 @@ func (m *Manager) SelectByRiskLevel(riskLevel string) {
 func (m *Manager) SelectByCategory(category string) {
 	for _, pkg := range m.packages {
 		if pkg.Category == category {
-			pkg.Selected = true
+			pkg.SetSelected(true)
 		}
 	}
 }
@@ -154,7 +288,7 @@ func (m *Manager) SelectByCategory(category string) {
 func (m *Manager) SelectInstalled() {
 	for _, pkg := range m.packages {
 		if pkg.Installed {
-			pkg.Selected = true
+			pkg.SetSelected(true)
 		}
 	}
 }
@@ -162,7 +296,7 @@ func (m *Manager) SelectInstalled() {
 // ToggleSelection toggles the selection of a package
 func (m *Manager) ToggleSelection(index int) {
 	if index >= 0 && index < len(m.packages) {
-		m.packages[index].Selected = !m.packages[index].Selected
+		m.packages[index].SetSelected(!m.packages[index].Selected)
 	}
 }
 
@@ -193,42 +327,86 @@ func (m *Manager) GetInstalledCount() int {
 	return count
 }
 
-// SaveBackup saves a backup of selected packages
-func (m *Manager) SaveBackup(backupDir string) error {
+// SaveBackup saves the current selection as a backup archive and returns
+// its path
+func (m *Manager) SaveBackup(backupDir string, device *adb.Device, userID string) (string, error) {
 	if backupDir == "" {
 		backupDir = "backups"
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
-	backupFile := fmt.Sprintf("%s/backup_%s.txt", backupDir, timestamp)
-
-	file, err := os.Create(backupFile)
+	run, err := backup.NewRun(device, userID)
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %w", err)
+		return "", err
+	}
+
+	for _, source := range m.sources {
+		if err := run.AddPack(source); err != nil {
+			run.Discard()
+			return "", err
+		}
 	}
-	defer file.Close()
 
 	for _, pkg := range m.packages {
 		if pkg.Selected {
-			_, err := file.WriteString(fmt.Sprintf("%s|%s|%s|%s\n",
-				pkg.Name, pkg.Description, pkg.Category, pkg.RiskLevel))
-			if err != nil {
-				return fmt.Errorf("failed to write backup: %w", err)
-			}
+			run.Record(pkg.RunResult(backup.ResultSelected))
 		}
 	}
 
-	return nil
+	return run.Write(backupDir)
 }
 
-// LoadBackup loads packages from a backup file
-func (m *Manager) LoadBackup(backupFile string) error {
+// LoadBackup selects the packages a backup removed, or would have removed in
+// a dry run, with the action they were removed with. Saved selections are
+// selected as a whole. Failed and skipped packages are left out. It returns
+// the packages that are not in the loaded packs. Archives taken on a
+// different device are refused unless force is set; legacy text backups
+// carry no device information and are always accepted.
+func (m *Manager) LoadBackup(backupFile string, device *adb.Device, force bool) ([]string, error) {
+	if !strings.HasSuffix(backupFile, backup.ArchiveExt) {
+		return m.loadTextBackup(backupFile)
+	}
+
+	archive, err := backup.OpenArchive(backupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := archive.CheckDevice(device, force); err != nil {
+		return nil, err
+	}
+
+	var unmatched []string
+	selected := 0
+	for _, res := range archive.Manifest.Packages {
+		switch res.Result {
+		case backup.ResultSuccess, backup.ResultDryRun, backup.ResultSelected:
+		default:
+			continue
+		}
+		if !m.selectByName(res.Package) {
+			unmatched = append(unmatched, res.Package)
+			continue
+		}
+		if res.Action != "" {
+			m.SetAction(res.Package, adb.Action(res.Action))
+		}
+		selected++
+	}
+
+	slog.Info("backup selection loaded", "file", backupFile, "packages", selected, "unmatched", len(unmatched), "force", force)
+	return unmatched, nil
+}
+
+// loadTextBackup selects packages from a legacy name|desc|category|risk file
+// and returns the ones that are not loaded
+func (m *Manager) loadTextBackup(backupFile string) ([]string, error) {
 	file, err := os.Open(backupFile)
 	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
 	}
 	defer file.Close()
 
+	var unmatched []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -237,26 +415,118 @@ func (m *Manager) LoadBackup(backupFile string) error {
 		}
 
 		parts := strings.Split(line, "|")
-		if len(parts) < 1 {
-			continue
+		if !m.selectByName(parts[0]) {
+			unmatched = append(unmatched, parts[0])
 		}
+	}
 
-		name := parts[0]
-		for _, pkg := range m.packages {
-			if pkg.Name == name {
-				pkg.Selected = true
-				break
-			}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading backup file: %w", err)
+	}
+
+	return unmatched, nil
+}
+
+// selectByName selects the package with the given name and reports whether
+// it is loaded
+func (m *Manager) selectByName(name string) bool {
+	return m.SetSelected(name, true)
+}
+
+// SetSelected selects or deselects a package by name, as far as its pin
+// allows, and reports whether the package is loaded
+func (m *Manager) SetSelected(name string, selected bool) bool {
+	for _, pkg := range m.packages {
+		if pkg.Name == name {
+			pkg.SetSelected(selected)
+			return true
 		}
 	}
+	return false
+}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading backup file: %w", err)
+// SetAction sets the removal action of a package by name and reports
+// whether the package is loaded
+func (m *Manager) SetAction(name string, action adb.Action) bool {
+	for _, pkg := range m.packages {
+		if pkg.Name == name {
+			pkg.Action = action
+			return true
+		}
+	}
+	return false
+}
+
+// AddPackage adds a package that is not listed in any pack
+func (m *Manager) AddPackage(pkg *Package) {
+	m.applyOverride(pkg)
+	m.packages = append(m.packages, pkg)
+}
+
+// AddToPack appends a package to a pack file, creating the file if needed,
+// and adds it to the list with that file as its source, replacing a listed
+// package of the same name
+func (m *Manager) AddToPack(filename string, pkg *Package) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pack file: %w", err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		fmt.Fprintln(file, "# Packages added from adb-cleaner")
+		fmt.Fprintln(file, "# Format: package_name # Description | Category | RiskLevel")
+	}
+
+	if _, err := fmt.Fprintln(file, formatPackLine(pkg)); err != nil {
+		return fmt.Errorf("failed to write pack file: %w", err)
+	}
+
+	slog.Info("package added to pack", "package", pkg.Name, "file", filename, "category", pkg.Category, "risk", pkg.RiskLevel)
+
+	pkg.Source = filename
+	m.applyOverride(pkg)
+	replaced := false
+	for i, listed := range m.packages {
+		if listed.Name == pkg.Name {
+			m.packages[i] = pkg
+			replaced = true
+		}
+	}
+	if !replaced {
+		m.packages = append(m.packages, pkg)
 	}
 
+	for _, source := range m.sources {
+		if source == filename {
+			return nil
+		}
+	}
+	m.sources = append(m.sources, filename)
 	return nil
 }
 
+// formatPackLine formats a package the way parsePack reads it
+func formatPackLine(pkg *Package) string {
+	relations := formatRelations(pkg)
+	if pkg.Description == "" && pkg.Category == "" && pkg.RiskLevel == "" && relations == "" {
+		return pkg.Name
+	}
+
+	clean := func(s string) string {
+		return strings.TrimSpace(strings.NewReplacer("|", "/", "#", "", "\n", " ").Replace(s))
+	}
+	line := fmt.Sprintf("%s # %s | %s | %s", pkg.Name, clean(pkg.Description), clean(pkg.Category), clean(pkg.RiskLevel))
+	if relations != "" {
+		line += " | " + relations
+	}
+	return line
+}
+
 // SearchPackages searches for packages by name or description
 func (m *Manager) SearchPackages(query string) []*Package {
 	query = strings.ToLower(query)
@@ -276,7 +546,7 @@ func (m *Manager) SearchPackages(query string) []*Package {
 func (m *Manager) FilterByRiskLevel(riskLevel string) []*Package {
 	var results []*Package
 	for _, pkg := range m.packages {
-		if pkg.RiskLevel == riskLevel {
+		if pkg.Risk() == riskLevel {
 			results = append(results, pkg)
 		}
 	}
@@ -294,7 +564,7 @@ func (m *Manager) FilterByCategory(category string) []*Package {
 	return results
 }
 
-// GetCategories returns all unique categories
+// GetCategories returns all unique categories, sorted
 func (m *Manager) GetCategories() []string {
 	categories := make(map[string]bool)
 	for _, pkg := range m.packages {
@@ -307,16 +577,17 @@ func (m *Manager) GetCategories() []string {
 	for cat := range categories {
 		result = append(result, cat)
 	}
+	sort.Strings(result)
 
 	return result
 }
 
-// GetRiskLevels returns all unique risk levels
+// GetRiskLevels returns all unique risk levels, from SAFE to DANGER
 func (m *Manager) GetRiskLevels() []string {
 	levels := make(map[string]bool)
 	for _, pkg := range m.packages {
-		if pkg.RiskLevel != "" {
-			levels[pkg.RiskLevel] = true
+		if risk := pkg.Risk(); risk != "" {
+			levels[risk] = true
 		}
 	}
 
@@ -324,6 +595,22 @@ func (m *Manager) GetRiskLevels() []string {
 	for level := range levels {
 		result = append(result, level)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return riskRank(result[i]) < riskRank(result[j])
+	})
 
 	return result
 }
+
+// riskRank orders risk levels from SAFE to DANGER, unknown levels last
+func riskRank(level string) int {
+	switch level {
+	case "SAFE":
+		return 0
+	case "RISKY":
+		return 1
+	case "DANGER":
+		return 2
+	}
+	return 3
+}
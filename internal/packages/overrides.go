@@ -0,0 +1,197 @@
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Pins of an override
+const (
+	PinNever  = "never"  // never selected for removal
+	PinAlways = "always" // always selected for removal
+)
+
+// RiskLevels are the risk levels a pack or an override can give
+var RiskLevels = []string{"SAFE", "RISKY", "DANGER"}
+
+// Override is a local annotation of a package, layered on top of the packs
+type Override struct {
+	RiskLevel string   `json:"riskLevel,omitempty"` // replaces the risk level of the pack
+	Notes     string   `json:"notes,omitempty"`
+	Pin       string   `json:"pin,omitempty"` // PinNever or PinAlways
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// IsZero reports whether the override changes nothing
+func (o *Override) IsZero() bool {
+	return o == nil || (o.RiskLevel == "" && o.Notes == "" && o.Pin == "" && len(o.Tags) == 0)
+}
+
+// validate reports why an override cannot be applied
+func (o *Override) validate() error {
+	if o.RiskLevel != "" && riskRank(o.RiskLevel) == len(RiskLevels) {
+		return fmt.Errorf("unknown risk level %q, expected one of %s", o.RiskLevel, strings.Join(RiskLevels, ", "))
+	}
+	if o.Pin != "" && o.Pin != PinNever && o.Pin != PinAlways {
+		return fmt.Errorf("unknown pin %q, expected %s or %s", o.Pin, PinNever, PinAlways)
+	}
+	return nil
+}
+
+// overridesFile is the format of the user override file
+type overridesFile struct {
+	Version  int                  `json:"version"`
+	Packages map[string]*Override `json:"packages"`
+}
+
+// overridesVersion is the format version of the override file
+const overridesVersion = 1
+
+// LoadOverrides reads the user override file and applies it to the loaded
+// packages and to the ones loaded later. A missing file holds no overrides.
+func (m *Manager) LoadOverrides(filename string) error {
+	m.overridesFile = filename
+	m.overrides = make(map[string]*Override)
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read overrides file: %w", err)
+	}
+
+	var file overridesFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("failed to parse overrides file %s: %w", filename, err)
+	}
+	if file.Version > overridesVersion {
+		return fmt.Errorf("overrides file %s: version %d is newer than the supported version %d", filename, file.Version, overridesVersion)
+	}
+
+	for name, o := range file.Packages {
+		if o == nil {
+			continue
+		}
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("overrides file %s: %s: %w", filename, name, err)
+		}
+		m.overrides[name] = o
+	}
+
+	for _, pkg := range m.packages {
+		m.applyOverride(pkg)
+	}
+	slog.Info("overrides loaded", "file", filename, "packages", len(m.overrides))
+	return nil
+}
+
+// GetOverride returns the override of a package, or nil
+func (m *Manager) GetOverride(name string) *Override {
+	return m.overrides[name]
+}
+
+// SetOverride replaces the override of a package, applies it and saves the
+// override file. An empty override removes it.
+func (m *Manager) SetOverride(name string, o *Override) error {
+	if m.overridesFile == "" {
+		return fmt.Errorf("no overrides file configured")
+	}
+	if err := o.validate(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if m.overrides == nil {
+		m.overrides = make(map[string]*Override)
+	}
+	previous, had := m.overrides[name]
+	if o.IsZero() {
+		delete(m.overrides, name)
+	} else {
+		m.overrides[name] = o
+	}
+
+	if err := m.saveOverrides(); err != nil {
+		if had {
+			m.overrides[name] = previous
+		} else {
+			delete(m.overrides, name)
+		}
+		return err
+	}
+	slog.Info("override saved", "package", name, "file", m.overridesFile, "removed", o.IsZero())
+
+	for _, pkg := range m.packages {
+		if pkg.Name == name {
+			m.applyOverride(pkg)
+		}
+	}
+	return nil
+}
+
+// saveOverrides writes the override file, keeping it intact if the write
+// fails half way
+func (m *Manager) saveOverrides() error {
+	data, err := json.MarshalIndent(overridesFile{Version: overridesVersion, Packages: m.overrides}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode overrides: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.overridesFile), 0755); err != nil {
+		return fmt.Errorf("failed to create overrides directory: %w", err)
+	}
+
+	tmp := m.overridesFile + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write overrides file: %w", err)
+	}
+	if err := os.Rename(tmp, m.overridesFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write overrides file: %w", err)
+	}
+	return nil
+}
+
+// applyOverride layers the override of a package over its pack entry. Pins
+// take effect on the selection right away and the package is rescored once
+// the device has been scored.
+func (m *Manager) applyOverride(pkg *Package) {
+	if !pkg.overridden {
+		pkg.packRisk = pkg.RiskLevel
+		pkg.overridden = true
+	}
+
+	o := m.overrides[pkg.Name]
+	if o == nil {
+		o = &Override{}
+	}
+
+	pkg.RiskLevel = pkg.packRisk
+	if o.RiskLevel != "" {
+		pkg.RiskLevel = o.RiskLevel
+	}
+	pkg.Notes = o.Notes
+	pkg.Pin = o.Pin
+	pkg.Tags = append([]string(nil), o.Tags...)
+	sort.Strings(pkg.Tags)
+
+	switch pkg.Pin {
+	case PinNever:
+		pkg.Selected = false
+	case PinAlways:
+		pkg.Selected = true
+	}
+
+	if m.scorer != nil {
+		pkg.Score = m.scorer.Score(pkg.Name, pkg.RiskLevel)
+	}
+}
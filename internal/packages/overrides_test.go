@@ -0,0 +1,107 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverridePrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string    // override file loaded before the change
+		set      *Override // override set afterwards, if any
+		selected bool      // selection made before the override
+		risk     string
+		packRisk string
+		pin      string
+		tags     []string
+		want     bool // selected once overridden
+	}{
+		{
+			name:     "pack only",
+			risk:     "SAFE",
+			packRisk: "SAFE",
+		},
+		{
+			name:     "file replaces the pack risk",
+			file:     `{"version": 1, "packages": {"com.example.bloat": {"riskLevel": "DANGER", "tags": ["b", "a"]}}}`,
+			risk:     "DANGER",
+			packRisk: "SAFE",
+			tags:     []string{"a", "b"},
+		},
+		{
+			name:     "set replaces the file",
+			file:     `{"version": 1, "packages": {"com.example.bloat": {"riskLevel": "DANGER"}}}`,
+			set:      &Override{RiskLevel: "RISKY"},
+			risk:     "RISKY",
+			packRisk: "SAFE",
+		},
+		{
+			name:     "empty override restores the pack",
+			file:     `{"version": 1, "packages": {"com.example.bloat": {"riskLevel": "DANGER", "pin": "never"}}}`,
+			set:      &Override{},
+			risk:     "SAFE",
+			packRisk: "SAFE",
+		},
+		{
+			name:     "pin never beats the selection",
+			set:      &Override{Pin: PinNever},
+			selected: true,
+			risk:     "SAFE",
+			packRisk: "SAFE",
+			pin:      PinNever,
+		},
+		{
+			name:     "pin always selects",
+			set:      &Override{Pin: PinAlways},
+			risk:     "SAFE",
+			packRisk: "SAFE",
+			pin:      PinAlways,
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, "com.example.bloat # Bloat | Ads | SAFE")
+			filename := filepath.Join(t.TempDir(), "overrides.json")
+			if tt.file != "" {
+				if err := os.WriteFile(filename, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.LoadOverrides(filename); err != nil {
+				t.Fatal(err)
+			}
+			m.SetSelected("com.example.bloat", tt.selected)
+			if tt.set != nil {
+				if err := m.SetOverride("com.example.bloat", tt.set); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pkg := m.GetPackage("com.example.bloat")
+			if pkg.RiskLevel != tt.risk || pkg.PackRiskLevel() != tt.packRisk {
+				t.Errorf("risk = %s from pack %s, want %s from pack %s", pkg.RiskLevel, pkg.PackRiskLevel(), tt.risk, tt.packRisk)
+			}
+			if pkg.Pin != tt.pin || !reflect.DeepEqual(pkg.Tags, tt.tags) {
+				t.Errorf("pin %q, tags %v, want %q, %v", pkg.Pin, pkg.Tags, tt.pin, tt.tags)
+			}
+			if pkg.Selected != tt.want {
+				t.Errorf("selected = %v, want %v", pkg.Selected, tt.want)
+			}
+
+			// Packages loaded later get the override too
+			again := NewManager()
+			if err := again.LoadOverrides(filename); err != nil {
+				t.Fatal(err)
+			}
+			again.AddPackage(&Package{Name: "com.example.bloat", RiskLevel: "SAFE"})
+			if later := again.GetPackage("com.example.bloat"); later.RiskLevel != tt.risk || later.Pin != tt.pin {
+				t.Errorf("added later: risk %s, pin %q, want %s, %q", later.RiskLevel, later.Pin, tt.risk, tt.pin)
+			}
+		})
+	}
+}
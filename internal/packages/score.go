@@ -0,0 +1,152 @@
+package packages
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+)
+
+// platformPackage is the package whose signing key is the platform key
+const platformPackage = "android"
+
+// Base scores by the risk level a pack or override gives
+var riskScores = map[string]int{
+	"SAFE":   10,
+	"RISKY":  45,
+	"DANGER": 80,
+}
+
+// Base scores of packages no pack rates
+const (
+	unratedSystemScore = 35
+	unratedUserScore   = 10
+)
+
+// Score is how risky removing a package is, from 0 to 100, with the reasons
+// that make it so
+type Score struct {
+	Value   int      `json:"value"`
+	Level   string   `json:"level"` // SAFE, RISKY or DANGER
+	Reasons []string `json:"reasons"`
+}
+
+// String formats the score as "45 RISKY"
+func (s *Score) String() string {
+	return fmt.Sprintf("%d %s", s.Value, s.Level)
+}
+
+// scoreLevel returns the risk level of a score value
+func scoreLevel(value int) string {
+	switch {
+	case value < 35:
+		return "SAFE"
+	case value < 70:
+		return "RISKY"
+	}
+	return "DANGER"
+}
+
+// Scorer scores packages from the facts and roles a device reports
+type Scorer struct {
+	facts    map[string]*adb.PackageFacts
+	roles    map[string][]string // package -> roles it holds
+	platform string              // signature of the platform key
+}
+
+// NewScorer creates a scorer for the facts and role holders of a device.
+// Either may be nil.
+func NewScorer(facts map[string]*adb.PackageFacts, roles map[string][]string) *Scorer {
+	s := &Scorer{facts: facts, roles: roles}
+	if f := facts[platformPackage]; f != nil {
+		s.platform = f.Signature
+	}
+	return s
+}
+
+// Score scores a package given the risk level it is rated with, which may be
+// empty for packages no pack covers. Packages the device does not report
+// are not installed and get no score.
+func (s *Scorer) Score(name, riskLevel string) *Score {
+	facts := s.facts[name]
+	if facts == nil {
+		return nil
+	}
+
+	score := &Score{}
+	add := func(points int, reason string) {
+		score.Value += points
+		score.Reasons = append(score.Reasons, fmt.Sprintf("%+d %s", points, reason))
+	}
+
+	if base, ok := riskScores[riskLevel]; ok {
+		add(base, "rated "+riskLevel)
+	} else {
+		add(unrated(facts.HasFlag("SYSTEM")))
+	}
+
+	if facts.HasFlag("PERSISTENT") {
+		add(30, "runs persistently")
+	}
+	if facts.HasFlag("PRIVILEGED") {
+		add(20, "privileged app")
+	}
+	if facts.DeviceAdmin {
+		add(25, "declares a device admin")
+	}
+	if facts.Accessibility {
+		add(15, "declares an accessibility service")
+	}
+	if roles := s.roles[name]; len(roles) > 0 {
+		add(25, "holds "+strings.Join(roleNames(roles), ", "))
+	}
+	if facts.SharedUser != "" {
+		add(15, "shares user ID "+facts.SharedUser)
+	}
+	if s.platform != "" && facts.Signature == s.platform && name != platformPackage {
+		add(15, "signed with the platform key")
+	}
+	if facts.OverlayTarget != "" {
+		add(-10, "overlay of "+facts.OverlayTarget)
+	}
+
+	score.Value = max(0, min(100, score.Value))
+	score.Level = scoreLevel(score.Value)
+	return score
+}
+
+// unrated returns the base score and reason of a package no pack rates
+func unrated(system bool) (int, string) {
+	if system {
+		return unratedSystemScore, "unrated system app"
+	}
+	return unratedUserScore, "unrated app"
+}
+
+// UnratedScore is the score of a package no pack rates when the device
+// facts about it are unknown
+func UnratedScore(system bool) *Score {
+	value, reason := unrated(system)
+	return &Score{
+		Value:   value,
+		Level:   scoreLevel(value),
+		Reasons: []string{fmt.Sprintf("%+d %s", value, reason)},
+	}
+}
+
+// roleNames shortens role names such as android.app.role.SMS to SMS
+func roleNames(roles []string) []string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = strings.TrimPrefix(role, "android.app.role.")
+	}
+	return names
+}
+
+// ApplyScores scores every loaded package
+func (m *Manager) ApplyScores(s *Scorer) {
+	for _, pkg := range m.packages {
+		pkg.Score = s.Score(pkg.Name, pkg.RiskLevel)
+	}
+	m.scorer = s
+}
@@ -0,0 +1,323 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+)
+
+// title returns the heading of the report
+func (r *Report) title() string {
+	name := strings.TrimSpace(r.Device.Manufacturer + " " + r.Device.Model)
+	if name == "" {
+		name = r.Device.Serial
+	}
+	title := "Cleanup report for " + name
+	if r.DryRun {
+		title += " (dry run)"
+	}
+	return title
+}
+
+// summary returns the result counts as "3 success, 1 failed". Packages of
+// a dry run are counted as would be removed.
+func (r *Report) summary() string {
+	var parts []string
+	for _, result := range r.results() {
+		label := result
+		if result == backup.ResultDryRun {
+			label = "would be removed"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", r.Counts[result], label))
+	}
+	if len(parts) == 0 {
+		return "no packages"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// freed returns the measured savings as text, or an empty string
+func (r *Report) freed() string {
+	if r.Freed == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s storage and %s memory freed", adb.FormatBytes(r.Freed.Storage), adb.FormatBytes(r.Freed.Memory))
+}
+
+// duration formats the time an entry took, or "-" when it was not timed
+func (e Entry) duration() string {
+	if e.DurationMS == 0 {
+		return "-"
+	}
+	return e.Duration().String()
+}
+
+// score formats the risk score of an entry, or "-" when it was not scored
+func (e Entry) score() string {
+	if len(e.Reasons) == 0 {
+		return "-"
+	}
+	return fmt.Sprint(e.Score)
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *Report) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	cell := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+
+	fmt.Fprintf(&b, "# %s\n\n", r.title())
+	fmt.Fprintf(&b, "- **Date:** %s\n", r.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "- **Device:** %s %s (%s)\n", r.Device.Manufacturer, r.Device.Model, r.Device.Serial)
+	fmt.Fprintf(&b, "- **Android:** %s\n", r.Device.AndroidVersion)
+	fmt.Fprintf(&b, "- **Fingerprint:** `%s`\n", r.Device.Fingerprint)
+	fmt.Fprintf(&b, "- **Users:** %s\n", strings.Join(r.Users, ", "))
+	fmt.Fprintf(&b, "- **Results:** %s\n", r.summary())
+	if r.DryRun {
+		b.WriteString("- **Dry run:** yes, nothing was removed\n")
+	}
+	if r.NotSimulated != "" {
+		fmt.Fprintf(&b, "- **Simulated:** no, the packages were only listed: %s\n", r.NotSimulated)
+	}
+	if freed := r.freed(); freed != "" {
+		fmt.Fprintf(&b, "- **Savings:** %s\n", freed)
+	}
+
+	if len(r.Packs) > 0 {
+		b.WriteString("\n## Packs\n\n")
+		for _, pack := range r.Packs {
+			fmt.Fprintf(&b, "- `%s` (sha256 `%s`)\n", pack.Path, pack.SHA256)
+		}
+	}
+
+	b.WriteString("\n## Packages\n\n")
+	b.WriteString("| User | Package | Risk | Score | Reasons | Action | Result | Duration | Error |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, e := range r.sorted() {
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %s | %s | %s | %s | %s | %s |\n",
+			e.User, e.Package, e.RiskLevel, e.score(), cell(strings.Join(e.Reasons, ", ")), e.Action, e.Result, e.duration(), cell(e.Error))
+	}
+
+	if len(r.Archives) > 0 {
+		b.WriteString("\n## Archives\n\n")
+		for _, archive := range r.Archives {
+			fmt.Fprintf(&b, "- `%s`\n", archive)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Report.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+code { font-size: 0.9em; }
+.success { color: #1a7f37; }
+.failed { color: #cf222e; font-weight: bold; }
+.skipped, .dry-run, .selected { color: #777; }
+</style>
+</head>
+<body>
+<h1>{{.Report.Title}}</h1>
+<table>
+<tr><th>Date</th><td>{{.Report.CreatedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Device</th><td>{{.Report.Device.Manufacturer}} {{.Report.Device.Model}} ({{.Report.Device.Serial}})</td></tr>
+<tr><th>Android</th><td>{{.Report.Device.AndroidVersion}}</td></tr>
+<tr><th>Fingerprint</th><td><code>{{.Report.Device.Fingerprint}}</code></td></tr>
+<tr><th>Users</th><td>{{range $i, $u := .Report.Users}}{{if $i}}, {{end}}{{$u}}{{end}}</td></tr>
+<tr><th>Results</th><td>{{.Summary}}</td></tr>
+{{if .Report.DryRun}}<tr><th>Dry run</th><td>yes, nothing was removed</td></tr>
+{{end}}{{if .Report.NotSimulated}}<tr><th>Simulated</th><td>no, the packages were only listed: {{.Report.NotSimulated}}</td></tr>
+{{end}}{{if .Freed}}<tr><th>Savings</th><td>{{.Freed}}</td></tr>
+{{end}}</table>
+{{if .Report.Packs}}<h2>Packs</h2>
+<ul>
+{{range .Report.Packs}}<li><code>{{.Path}}</code> (sha256 <code>{{.SHA256}}</code>)</li>
+{{end}}</ul>
+{{end}}<h2>Packages</h2>
+<table>
+<tr><th>User</th><th>Package</th><th>Risk</th><th>Score</th><th>Reasons</th><th>Action</th><th>Result</th><th>Duration</th><th>Error</th></tr>
+{{range .Entries}}<tr><td>{{.User}}</td><td><code>{{.Package}}</code></td><td>{{.RiskLevel}}</td><td>{{.Scored}}</td><td>{{range $i, $r := .Reasons}}{{if $i}}<br>{{end}}{{$r}}{{end}}</td><td>{{.Action}}</td><td class="{{.Result}}">{{.Result}}</td><td>{{.Took}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{if .Report.Archives}}<h2>Archives</h2>
+<ul>
+{{range .Report.Archives}}<li><code>{{.}}</code></li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+// htmlEntry is an entry with its score and duration formatted for the
+// template
+type htmlEntry struct {
+	Entry
+	Scored string
+	Took   string
+}
+
+func (r *Report) writeHTML(w io.Writer) error {
+	entries := r.sorted()
+	data := struct {
+		Report  *htmlReport
+		Summary string
+		Freed   string
+		Entries []htmlEntry
+	}{
+		Report:  &htmlReport{Report: r, Title: r.title()},
+		Summary: r.summary(),
+		Freed:   r.freed(),
+		Entries: make([]htmlEntry, len(entries)),
+	}
+	for i, e := range entries {
+		data.Entries[i] = htmlEntry{Entry: e, Scored: e.score(), Took: e.duration()}
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+// htmlReport adds the title to the report for the template
+type htmlReport struct {
+	*Report
+	Title string
+}
+
+// JUnit XML elements, one test suite per user and one test case per package
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// seconds formats milliseconds as JUnit seconds
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
+
+func (r *Report) writeJUnit(w io.Writer) error {
+	suites := junitSuites{Name: r.title()}
+	var total int64
+
+	for _, user := range r.Users {
+		suite := junitSuite{
+			Name:      "user " + user,
+			Timestamp: r.CreatedAt.Format("2006-01-02T15:04:05"),
+			Properties: []junitProperty{
+				{Name: "serial", Value: r.Device.Serial},
+				{Name: "model", Value: strings.TrimSpace(r.Device.Manufacturer + " " + r.Device.Model)},
+				{Name: "android", Value: r.Device.AndroidVersion},
+				{Name: "fingerprint", Value: r.Device.Fingerprint},
+				{Name: "dryRun", Value: fmt.Sprint(r.DryRun)},
+			},
+		}
+		if r.NotSimulated != "" {
+			suite.Properties = append(suite.Properties, junitProperty{Name: "notSimulated", Value: r.NotSimulated})
+		}
+		for _, pack := range r.Packs {
+			suite.Properties = append(suite.Properties, junitProperty{Name: "pack", Value: pack.Path})
+		}
+
+		var took int64
+		for _, e := range r.sorted() {
+			if e.User != user {
+				continue
+			}
+			c := junitCase{
+				Name:      e.Package,
+				ClassName: e.Action,
+				Time:      seconds(e.DurationMS),
+			}
+			if len(e.Reasons) > 0 {
+				c.Properties = append(c.Properties, junitProperty{Name: "score", Value: e.score()})
+				for _, reason := range e.Reasons {
+					c.Properties = append(c.Properties, junitProperty{Name: "reason", Value: reason})
+				}
+			}
+			switch e.Result {
+			case backup.ResultFailed:
+				c.Failure = &junitFailure{Message: e.Error, Type: e.Action, Text: e.Error}
+				suite.Failures++
+			case backup.ResultSkipped, backup.ResultDryRun, backup.ResultSelected:
+				c.Skipped = &junitSkipped{Message: e.Result}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, c)
+			suite.Tests++
+			took += e.DurationMS
+		}
+		suite.Time = seconds(took)
+
+		suites.Suites = append(suites.Suites, suite)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		total += took
+	}
+	suites.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
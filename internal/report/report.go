@@ -0,0 +1,276 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+)
+
+// Formats a report can be written in
+const (
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+	FormatJUnit    = "junit"
+)
+
+// Formats lists every report format
+var Formats = []string{FormatMarkdown, FormatHTML, FormatJSON, FormatJUnit}
+
+// Report describes a run on one device across its target users
+type Report struct {
+	CreatedAt time.Time           `json:"createdAt"`
+	Device    backup.DeviceInfo   `json:"device"`
+	DryRun    bool                `json:"dryRun"`
+	Users     []string            `json:"users"`
+	Packs     []backup.PackSource `json:"packs"`
+	Counts    map[string]int      `json:"counts"`
+	Packages  []Entry             `json:"packages"`
+	Archives  []string            `json:"archives,omitempty"`
+	Freed     *Freed              `json:"freed,omitempty"`
+
+	NotSimulated string `json:"notSimulated,omitempty"` // why a dry run only listed the packages
+}
+
+// Entry is the result of one package for one user
+type Entry struct {
+	User string `json:"user"`
+	backup.PackageResult
+}
+
+// Duration returns the time the device took to act on the package
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+// Freed is the storage and memory a run measurably freed, in bytes
+type Freed struct {
+	Storage int64 `json:"storage"`
+	Memory  int64 `json:"memory"`
+}
+
+// New builds a report from the archives of a run, one per target user
+func New(archives []*backup.Archive) (*Report, error) {
+	if len(archives) == 0 {
+		return nil, fmt.Errorf("no archives to report on")
+	}
+
+	first := archives[0].Manifest
+	r := &Report{
+		CreatedAt: first.CreatedAt,
+		Device:    first.Device,
+		DryRun:    first.DryRun,
+		Packs:     first.Packs,
+		Counts:    make(map[string]int),
+		Packages:  []Entry{},
+	}
+	for _, archive := range archives {
+		manifest := archive.Manifest
+		r.Users = append(r.Users, manifest.UserID)
+		r.Archives = append(r.Archives, archive.Path)
+		for _, res := range manifest.Packages {
+			r.Packages = append(r.Packages, Entry{User: manifest.UserID, PackageResult: res})
+			r.Counts[res.Result]++
+		}
+	}
+
+	return r, nil
+}
+
+// Load builds a report from archive files
+func Load(paths []string) (*Report, error) {
+	archives := make([]*backup.Archive, 0, len(paths))
+	for _, path := range paths {
+		archive, err := backup.OpenArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return New(archives)
+}
+
+// ParseFormats parses a comma-separated list of report formats
+func ParseFormats(value string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if Extension(format) == "" {
+			return nil, fmt.Errorf("unknown report format %q, expected %s", format, strings.Join(Formats, ", "))
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// Extension returns the file extension of a format, or an empty string for
+// an unknown one
+func Extension(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return ".md"
+	case FormatHTML:
+		return ".html"
+	case FormatJSON:
+		return ".json"
+	case FormatJUnit:
+		return ".xml"
+	}
+	return ""
+}
+
+// Write writes the report in a format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatMarkdown:
+		return r.writeMarkdown(w)
+	case FormatHTML:
+		return r.writeHTML(w)
+	case FormatJSON:
+		return r.writeJSON(w)
+	case FormatJUnit:
+		return r.writeJUnit(w)
+	}
+	return fmt.Errorf("unknown report format %q, expected %s", format, strings.Join(Formats, ", "))
+}
+
+// Save writes the report in each format to dir, named after the time of the
+// run, and returns the files written. Reports of runs started in the same
+// second get a counter, as backup archives do.
+func (r *Report) Save(dir string, formats []string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	var unique []string
+	for _, format := range formats {
+		if !containsFormat(unique, format) {
+			unique = append(unique, format)
+		}
+	}
+	formats = unique
+
+	files, names, err := createReports(dir, "report_"+r.CreatedAt.Format("20060102_150405"), formats)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		err := r.Write(file, formats[i])
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			for _, file := range files[i+1:] {
+				file.Close()
+			}
+			return names[:i], fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	return names, nil
+}
+
+// reportExists reports whether a report named name exists in any format
+func reportExists(dir string, name string) bool {
+	for _, format := range Formats {
+		if _, err := os.Lstat(filepath.Join(dir, name+Extension(format))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFormat reports whether formats lists format
+func containsFormat(formats []string, format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// createReports creates one new file per format under the first name
+// derived from base that no report uses yet, in any format
+func createReports(dir string, base string, formats []string) ([]*os.File, []string, error) {
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		if reportExists(dir, name) {
+			continue
+		}
+
+		var files []*os.File
+		var names []string
+		discard := func() {
+			for i, file := range files {
+				file.Close()
+				os.Remove(names[i])
+			}
+		}
+		taken := false
+		for _, format := range formats {
+			filename := filepath.Join(dir, name+Extension(format))
+			file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+			if errors.Is(err, fs.ErrExist) {
+				taken = true
+				break
+			}
+			if err != nil {
+				discard()
+				return nil, nil, fmt.Errorf("failed to create report: %w", err)
+			}
+			files = append(files, file)
+			names = append(names, filename)
+		}
+		if !taken {
+			return files, names, nil
+		}
+		discard()
+	}
+}
+
+// results returns the results present in the report, in a fixed order
+func (r *Report) results() []string {
+	var results []string
+	for _, result := range []string{backup.ResultSuccess, backup.ResultFailed, backup.ResultSkipped, backup.ResultDryRun, backup.ResultSelected} {
+		if r.Counts[result] > 0 {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// sorted returns the entries ordered by user, then failures first, then name
+func (r *Report) sorted() []Entry {
+	entries := append([]Entry(nil), r.Packages...)
+	rank := func(result string) int {
+		if result == backup.ResultFailed {
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if rank(a.Result) != rank(b.Result) {
+			return rank(a.Result) < rank(b.Result)
+		}
+		return a.Package < b.Package
+	})
+	return entries
+}
@@ -0,0 +1,157 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+)
+
+// testReport is a run for two users where one package failed for user 10
+func testReport() *Report {
+	return &Report{
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Device:    backup.DeviceInfo{Serial: "test-device", Manufacturer: "Test", Model: "Phone", AndroidVersion: "13"},
+		Users:     []string{"0", "10"},
+		Packs:     []backup.PackSource{{Path: "packs.txt", SHA256: "abc"}},
+		Counts:    map[string]int{backup.ResultSuccess: 2, backup.ResultFailed: 1},
+		Packages: []Entry{
+			{User: "0", PackageResult: backup.PackageResult{Package: "com.example.bloat", Action: "uninstall", Result: backup.ResultSuccess, DurationMS: 1500, Score: 10, Reasons: []string{"+10 rated SAFE"}}},
+			{User: "10", PackageResult: backup.PackageResult{Package: "com.example.bloat", Action: "uninstall", Result: backup.ResultSuccess, DurationMS: 500}},
+			{User: "10", PackageResult: backup.PackageResult{Package: "com.example.<stuck>", Action: "uninstall", Result: backup.ResultFailed, Error: "Failure [DELETE_FAILED_INTERNAL_ERROR]"}},
+		},
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().Write(&buf, FormatJUnit); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Time != "2.000" || len(suites.Suites) != 2 {
+		t.Fatalf("suites = %+v, want 3 tests, 1 failure, 2s and 2 suites", suites)
+	}
+
+	tests := []struct {
+		suite    int
+		name     string
+		time     string
+		failure  string
+		property string
+	}{
+		{suite: 0, name: "com.example.bloat", time: "1.500", property: "score=10"},
+		{suite: 1, name: "com.example.<stuck>", time: "0.000", failure: "DELETE_FAILED_INTERNAL_ERROR"},
+		{suite: 1, name: "com.example.bloat", time: "0.500"},
+	}
+	found := 0
+	for _, tt := range tests {
+		for _, c := range suites.Suites[tt.suite].Cases {
+			if c.Name != tt.name {
+				continue
+			}
+			found++
+			if c.Time != tt.time {
+				t.Errorf("%s time = %s, want %s", c.Name, c.Time, tt.time)
+			}
+			if (c.Failure == nil) != (tt.failure == "") || (c.Failure != nil && !strings.Contains(c.Failure.Message, tt.failure)) {
+				t.Errorf("%s failure = %+v, want %q", c.Name, c.Failure, tt.failure)
+			}
+			var props []string
+			for _, p := range c.Properties {
+				props = append(props, p.Name+"="+p.Value)
+			}
+			if tt.property != "" && !strings.Contains(strings.Join(props, " "), tt.property) {
+				t.Errorf("%s properties = %v, want %s", c.Name, props, tt.property)
+			}
+		}
+	}
+	if found != len(tests) {
+		t.Errorf("found %d of %d test cases", found, len(tests))
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().Write(&buf, FormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+
+	for _, want := range []string{
+		"<title>Cleanup report for Test Phone</title>",
+		`<td class="failed">failed</td>`,
+		"com.example.&lt;stuck&gt;",
+		"<td>1.5s</td>",
+		"<td>10</td><td>&#43;10 rated SAFE</td>",
+		"<code>packs.txt</code>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML report lacks %q", want)
+		}
+	}
+	if strings.Contains(html, "<stuck>") {
+		t.Error("HTML report does not escape package names")
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	r := testReport()
+
+	tests := []struct {
+		formats []string
+		want    []string
+	}{
+		{formats: []string{FormatMarkdown, FormatJUnit}, want: []string{"report_20240102_030405.md", "report_20240102_030405.xml"}},
+		{formats: []string{FormatJUnit}, want: []string{"report_20240102_030405_2.xml"}},
+		{formats: []string{FormatHTML, FormatHTML}, want: []string{"report_20240102_030405_3.html"}},
+	}
+	for _, tt := range tests {
+		files, err := r.Save(dir, tt.formats)
+		if err != nil {
+			t.Fatalf("Save %v: %v", tt.formats, err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, filepath.Base(file))
+		}
+		if strings.Join(names, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Save %v = %v, want %v", tt.formats, names, tt.want)
+		}
+	}
+}
+
+func TestWriteDryRun(t *testing.T) {
+	r := testReport()
+	r.DryRun = true
+	r.Counts = map[string]int{backup.ResultDryRun: 2, backup.ResultFailed: 1}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: FormatMarkdown, want: []string{"# Cleanup report for Test Phone (dry run)", "1 failed, 2 would be removed", "**Dry run:** yes, nothing was removed"}},
+		{format: FormatHTML, want: []string{"<h1>Cleanup report for Test Phone (dry run)</h1>", "1 failed, 2 would be removed", "<th>Dry run</th>"}},
+		{format: FormatJUnit, want: []string{`name="dryRun" value="true"`}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s report lacks %q", tt.format, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/report"
+)
+
+// historyJSON summarizes a backup archive
+type historyJSON struct {
+	Name      string                 `json:"name"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Device    backup.DeviceInfo      `json:"device"`
+	DryRun    bool                   `json:"dryRun"`
+	Counts    map[string]int         `json:"counts"`
+	Packs     []backup.PackSource    `json:"packs"`
+	Packages  []backup.PackageResult `json:"packages,omitempty"`
+}
+
+func newHistoryJSON(archive *backup.Archive, withPackages bool) historyJSON {
+	out := historyJSON{
+		Name:      filepath.Base(archive.Path),
+		CreatedAt: archive.Manifest.CreatedAt,
+		Device:    archive.Manifest.Device,
+		DryRun:    archive.Manifest.DryRun,
+		Counts:    archive.Manifest.Counts(),
+		Packs:     archive.Manifest.Packs,
+	}
+	if withPackages {
+		out.Packages = archive.Manifest.Packages
+	}
+	return out
+}
+
+// handleHistory lists past runs of the current device, or of every device
+// with ?all=1
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	archives, err := backup.ListArchives(s.opts.BackupDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	eng := s.currentEngine()
+	all := r.URL.Query().Get("all") != "" || eng == nil
+
+	result := []historyJSON{}
+	for _, archive := range archives {
+		if all || archive.Manifest.Device.Matches(eng.Device()) {
+			result = append(result, newHistoryJSON(archive, false))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleHistoryEntry serves /api/history/{name}, its report and its restore
+// and reapply actions
+func (s *Server) handleHistoryEntry(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/history/"), "/")
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, backup.ArchiveExt) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("backup %q not found", name))
+		return
+	}
+
+	archive, err := backup.OpenArchive(filepath.Join(s.opts.BackupDir, name))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch action {
+	case "":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, newHistoryJSON(archive, true))
+
+	case "report":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		s.writeReport(w, r, archive)
+
+	case "restore":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		var req struct {
+			Force bool `json:"force"`
+		}
+		if r.ContentLength != 0 && !readJSON(w, r, &req) {
+			return
+		}
+
+		// Archives of another device are refused before the restore
+		// starts, so the refusal can be confirmed and sent again with force
+		eng, ok := s.lockEngine(w)
+		if !ok {
+			return
+		}
+		err := archive.CheckDevice(eng.Device(), req.Force)
+		s.mu.Unlock()
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "force": true})
+			return
+		}
+
+		run, err := s.StartRestore(archive, req.Force)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		w.Header().Set("Location", "/api/runs/"+run.id)
+		writeJSON(w, http.StatusAccepted, run.snapshot(false))
+
+	case "reapply":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s.reapply(w, archive)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeReport serves the report of a past run in the format given by
+// ?format=, HTML by default
+func (s *Server) writeReport(w http.ResponseWriter, r *http.Request, archive *backup.Archive) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = report.FormatHTML
+	}
+	if report.Extension(format) == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown report format %q", format))
+		return
+	}
+
+	rep, err := report.New([]*backup.Archive{archive})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", reportTypes[format])
+	rep.Write(w, format)
+}
+
+// reportTypes are the content types of the report formats
+var reportTypes = map[string]string{
+	report.FormatMarkdown: "text/markdown; charset=utf-8",
+	report.FormatHTML:     "text/html; charset=utf-8",
+	report.FormatJSON:     "application/json",
+	report.FormatJUnit:    "application/xml",
+}
+
+// reapply replaces the selection with the packages a past run removed, which
+// may come from another device, and lists the ones the packs do not cover
+func (s *Server) reapply(w http.ResponseWriter, archive *backup.Archive) {
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+	defer s.mu.Unlock()
+
+	manager := eng.Manager()
+	manager.DeselectAll()
+	unmatched, err := manager.LoadBackup(archive.Path, eng.Device(), true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	selected := []string{}
+	for _, pkg := range manager.GetSelectedPackages() {
+		selected = append(selected, pkg.Name)
+	}
+	if unmatched == nil {
+		unmatched = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"packages": selected, "unmatched": unmatched})
+}
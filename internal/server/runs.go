@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+)
+
+// Run kinds
+const (
+	kindDebloat = "debloat"
+	kindRestore = "restore"
+)
+
+// Run states
+const (
+	runRunning  = "running"
+	runFinished = "finished"
+	runFailed   = "failed"
+)
+
+// run tracks one engine run and fans its events out to subscribers
+type run struct {
+	mu         sync.Mutex
+	id         string
+	kind       string
+	state      string
+	device     string
+	dryRun     bool
+	startedAt  time.Time
+	finishedAt time.Time
+	summary    *engine.Summary
+	err        error
+	events     []engine.Event
+	subs       map[chan engine.Event]bool
+}
+
+// runJSON is the API representation of a run
+type runJSON struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	State      string          `json:"state"`
+	Device     string          `json:"device"`
+	DryRun     bool            `json:"dryRun"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Summary    *engine.Summary `json:"summary,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Events     []engine.Event  `json:"events,omitempty"`
+}
+
+// snapshot returns the API representation of the run
+func (r *run) snapshot(withEvents bool) runJSON {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := runJSON{
+		ID:        r.id,
+		Kind:      r.kind,
+		State:     r.state,
+		Device:    r.device,
+		DryRun:    r.dryRun,
+		StartedAt: r.startedAt,
+		Summary:   r.summary,
+	}
+	if !r.finishedAt.IsZero() {
+		finished := r.finishedAt
+		out.FinishedAt = &finished
+	}
+	if r.err != nil {
+		out.Error = r.err.Error()
+	}
+	if withEvents {
+		out.Events = append([]engine.Event{}, r.events...)
+	}
+	return out
+}
+
+// publish records an event and passes it to every subscriber
+func (r *run) publish(ev engine.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, ev)
+	for ch := range r.subs {
+		select {
+		case ch <- ev:
+		default:
+			// Drop slow subscribers rather than stall the run
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// finish marks the run as done and closes every subscription
+func (r *run) finish(summary *engine.Summary, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summary = summary
+	r.err = err
+	r.finishedAt = time.Now()
+	r.state = runFinished
+	if err != nil {
+		r.state = runFailed
+	}
+
+	for ch := range r.subs {
+		close(ch)
+	}
+	r.subs = nil
+}
+
+// subscribe returns the events so far and a channel for the ones to come.
+// The channel is nil when the run has already finished.
+func (r *run) subscribe() ([]engine.Event, chan engine.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backlog := append([]engine.Event{}, r.events...)
+	if r.state != runRunning {
+		return backlog, nil
+	}
+
+	ch := make(chan engine.Event, 64)
+	r.subs[ch] = true
+	return backlog, ch
+}
+
+// unsubscribe stops delivering events to a channel
+func (r *run) unsubscribe(ch chan engine.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.subs[ch] {
+		delete(r.subs, ch)
+		close(ch)
+	}
+}
+
+// StartRun starts removing the current selection in the background
+func (s *Server) StartRun(opts engine.Options) (*run, error) {
+	return s.startJob(kindDebloat, opts.DryRun, func(eng *engine.Engine, onEvent func(engine.Event)) (*engine.Summary, error) {
+		return eng.Run(opts, onEvent)
+	})
+}
+
+// StartRestore starts restoring everything a past run removed. force
+// restores an archive taken on another device.
+func (s *Server) StartRestore(archive *backup.Archive, force bool) (*run, error) {
+	return s.startJob(kindRestore, false, func(eng *engine.Engine, onEvent func(engine.Event)) (*engine.Summary, error) {
+		return eng.Restore(archive, force, onEvent)
+	})
+}
+
+// startJob runs fn against the current engine in the background. Only one
+// job may touch the device at a time, and it has the engine to itself until
+// it finishes.
+func (s *Server) startJob(kind string, dryRun bool, fn func(*engine.Engine, func(engine.Event)) (*engine.Summary, error)) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.engine == nil {
+		return nil, fmt.Errorf("no device selected")
+	}
+	if s.active != nil {
+		return nil, errBusy
+	}
+
+	r := &run{
+		id:        fmt.Sprintf("%d", len(s.order)+1),
+		kind:      kind,
+		state:     runRunning,
+		device:    s.engine.Device().ID,
+		dryRun:    dryRun,
+		startedAt: time.Now(),
+		subs:      make(map[chan engine.Event]bool),
+	}
+	s.runs[r.id] = r
+	s.order = append(s.order, r.id)
+	s.active = r
+
+	// The finished event is held back until the engine is free again, so a
+	// client reloading on it is not refused as busy
+	eng := s.engine
+	go func() {
+		var finished *engine.Event
+		summary, err := fn(eng, func(ev engine.Event) {
+			if ev.Type == engine.EventFinished {
+				finished = &ev
+				return
+			}
+			r.publish(ev)
+		})
+
+		s.mu.Lock()
+		s.active = nil
+		s.mu.Unlock()
+
+		if finished != nil {
+			r.publish(*finished)
+		}
+		r.finish(summary, err)
+	}()
+
+	return r, nil
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			DryRun     bool  `json:"dryRun"`
+			BackupAPKs *bool `json:"backupApks"`
+			Force      bool  `json:"force"`
+		}
+		if r.ContentLength != 0 && !readJSON(w, r, &req) {
+			return
+		}
+
+		opts := engine.Options{
+			DryRun:        req.DryRun,
+			BackupAPKs:    s.opts.BackupAPKs,
+			BackupDir:     s.opts.BackupDir,
+			ReportDir:     s.opts.ReportDir,
+			ReportFormats: s.opts.ReportFormats,
+			Force:         req.Force,
+		}
+		if req.BackupAPKs != nil {
+			opts.BackupAPKs = *req.BackupAPKs
+		}
+
+		// Default apps are checked before the run starts, so a refusal can
+		// be confirmed and the run sent again with force
+		eng, ok := s.lockEngine(w)
+		if !ok {
+			return
+		}
+		err := eng.CheckDefaults(opts)
+		s.mu.Unlock()
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "force": true})
+			return
+		}
+
+		run, err := s.StartRun(opts)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/runs/"+run.id)
+		writeJSON(w, http.StatusAccepted, run.snapshot(false))
+		return
+	}
+
+	s.mu.Lock()
+	runs := make([]*run, 0, len(s.order))
+	for _, id := range s.order {
+		runs = append(runs, s.runs[id])
+	}
+	s.mu.Unlock()
+
+	result := make([]runJSON, 0, len(runs))
+	for _, run := range runs {
+		result = append(result, run.snapshot(false))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleRun serves /api/runs/{id} and /api/runs/{id}/events
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/runs/"), "/")
+
+	s.mu.Lock()
+	run := s.runs[id]
+	s.mu.Unlock()
+
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %q not found", id))
+		return
+	}
+
+	switch rest {
+	case "":
+		writeJSON(w, http.StatusOK, run.snapshot(true))
+	case "events":
+		s.streamEvents(w, r, run)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamEvents sends the events of a run as server-sent events, replaying
+// the ones that happened before the client connected
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, run *run) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev engine.Event) {
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		flusher.Flush()
+	}
+
+	backlog, ch := run.subscribe()
+	for _, ev := range backlog {
+		send(ev)
+	}
+	if ch == nil {
+		return
+	}
+	defer run.unsubscribe(ch)
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			send(ev)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
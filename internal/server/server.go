@@ -0,0 +1,539 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	"github.com/adb-cleaner/adb-cleaner/internal/web"
+)
+
+// errBusy is returned while a run is modifying the device
+var errBusy = errors.New("a run is in progress")
+
+// Options configures the server
+type Options struct {
+	Config        *config.Config // matches each selected device to its profile
+	BackupDir     string
+	BackupAPKs    bool
+	ReportDir     string
+	ReportFormats []string // reports written after each run
+}
+
+// Server exposes the cleaner engine as a JSON REST API
+type Server struct {
+	client *adb.Client
+	opts   Options
+
+	mu     sync.Mutex
+	engine *engine.Engine
+	runs   map[string]*run
+	order  []string
+	active *run
+}
+
+// New creates a server on top of an adb client. The client may use any
+// transport, which lets the API run against a fake device.
+func New(client *adb.Client, opts Options) *Server {
+	return &Server{
+		client: client,
+		opts:   opts,
+		runs:   make(map[string]*run),
+	}
+}
+
+// SelectDevice points the engine at the device with the given serial,
+// applying its profile and loading the device inventory
+func (s *Server) SelectDevice(serial string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		return errBusy
+	}
+
+	client := s.client.WithSerial(serial)
+	device, err := client.GetDevice()
+	if err != nil {
+		return err
+	}
+
+	eng, err := engine.ForDevice(client, device, s.opts.Config)
+	if err != nil {
+		return err
+	}
+	// Packages keep the risk of their pack when the device cannot be scored
+	if err := eng.ScorePackages(); err != nil {
+		slog.Warn("packages are not scored", "device", serial, "error", err)
+	}
+
+	s.engine = eng
+	return nil
+}
+
+// Handler returns the HTTP handler of the API and the web UI
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/devices", s.handleDevices)
+	mux.HandleFunc("/api/device", s.handleDevice)
+	mux.HandleFunc("/api/packs", s.handlePacks)
+	mux.HandleFunc("/api/packages", s.handlePackages)
+	mux.HandleFunc("/api/inventory", s.handleInventory)
+	mux.HandleFunc("/api/selection", s.handleSelection)
+	mux.HandleFunc("/api/plan", s.handlePlan)
+	mux.HandleFunc("/api/runs", s.handleRuns)
+	mux.HandleFunc("/api/runs/", s.handleRun)
+	mux.HandleFunc("/api/history", s.handleHistory)
+	mux.HandleFunc("/api/history/", s.handleHistoryEntry)
+	mux.Handle("/", web.Handler())
+	return guard(mux)
+}
+
+// guard refuses requests a web page of another site could send. The host
+// must be loopback or an IP address, so a DNS name rebound to the server is
+// refused; a browser Origin must be the server itself; and requests that
+// change anything must be JSON, which a cross-site form cannot send.
+func guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q not allowed", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, fmt.Errorf("origin %q not allowed", origin))
+				return
+			}
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("requests must be sent as application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether a Host header names the server by a loopback
+// name or an IP address
+func allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	return net.ParseIP(host) != nil
+}
+
+// deviceJSON is the API representation of a device
+type deviceJSON struct {
+	Serial         string   `json:"serial"`
+	Manufacturer   string   `json:"manufacturer"`
+	Model          string   `json:"model"`
+	AndroidVersion string   `json:"androidVersion"`
+	Fingerprint    string   `json:"fingerprint"`
+	UserID         string   `json:"userId"`
+	Selected       bool     `json:"selected"`
+	Profile        string   `json:"profile,omitempty"`
+	Users          []string `json:"users,omitempty"`
+}
+
+func newDeviceJSON(device *adb.Device, selected bool) deviceJSON {
+	return deviceJSON{
+		Serial:         device.ID,
+		Manufacturer:   device.Manufacturer,
+		Model:          device.Model,
+		AndroidVersion: device.AndroidVersion,
+		Fingerprint:    device.Fingerprint,
+		UserID:         device.UserID,
+		Selected:       selected,
+	}
+}
+
+// packageJSON is the API representation of a pack entry
+type packageJSON struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	RiskLevel   string `json:"riskLevel"`
+	Source      string `json:"source"`
+	Action      string `json:"action"`
+	Installed   bool   `json:"installed"`
+	Selected    bool   `json:"selected"`
+
+	Notes string   `json:"notes,omitempty"`
+	Pin   string   `json:"pin,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	Score *packages.Score `json:"score,omitempty"`
+}
+
+func newPackageJSON(pkg *packages.Package) packageJSON {
+	return packageJSON{
+		Name:        pkg.Name,
+		Description: pkg.Description,
+		Category:    pkg.Category,
+		RiskLevel:   pkg.RiskLevel,
+		Source:      pkg.Source,
+		Action:      string(pkg.GetAction()),
+		Installed:   pkg.Installed,
+		Selected:    pkg.Selected,
+		Notes:       pkg.Notes,
+		Pin:         pkg.Pin,
+		Tags:        pkg.Tags,
+		Score:       pkg.Score,
+	}
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	serials, err := s.client.ListDevices()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	current := ""
+	if eng := s.currentEngine(); eng != nil {
+		current = eng.Device().ID
+	}
+
+	devices := make([]deviceJSON, 0, len(serials))
+	for _, serial := range serials {
+		device, err := s.client.WithSerial(serial).GetDevice()
+		if err != nil {
+			device = &adb.Device{ID: serial}
+		}
+		devices = append(devices, newDeviceJSON(device, serial == current))
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var req struct {
+			Serial string `json:"serial"`
+		}
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if err := s.SelectDevice(req.Serial); err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+	}
+
+	eng, ok := s.requireEngine(w)
+	if !ok {
+		return
+	}
+
+	device := newDeviceJSON(eng.Device(), true)
+	device.Profile = eng.Profile()
+	device.Users = eng.Users()
+	writeJSON(w, http.StatusOK, device)
+}
+
+func (s *Server) handlePacks(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	type packJSON struct {
+		Path     string `json:"path"`
+		Packages int    `json:"packages"`
+	}
+
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+	counts := make(map[string]int)
+	for _, pkg := range eng.Manager().GetPackages() {
+		counts[pkg.Source]++
+	}
+	packs := []packJSON{}
+	for _, source := range eng.Manager().Sources() {
+		packs = append(packs, packJSON{Path: source, Packages: counts[source]})
+	}
+	categories := append([]string{}, eng.Manager().GetCategories()...)
+	riskLevels := append([]string{}, eng.Manager().GetRiskLevels()...)
+	s.mu.Unlock()
+
+	sort.Strings(categories)
+	sort.Strings(riskLevels)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"packs":      packs,
+		"categories": categories,
+		"riskLevels": riskLevels,
+	})
+}
+
+func (s *Server) handlePackages(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	pkgs := eng.Manager().GetPackages()
+	if q := query.Get("q"); q != "" {
+		pkgs = eng.Manager().SearchPackages(q)
+	}
+	result := make([]packageJSON, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if risk := query.Get("risk"); risk != "" && pkg.Risk() != risk {
+			continue
+		}
+		if category := query.Get("category"); category != "" && pkg.Category != category {
+			continue
+		}
+		result = append(result, newPackageJSON(pkg))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleInventory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+	defer s.mu.Unlock()
+
+	var inv *adb.Inventory
+	var err error
+	if r.URL.Query().Get("refresh") != "" {
+		inv, err = eng.Refresh()
+	} else {
+		inv, err = eng.Client().Inventory(eng.Device().UserID)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	type recordJSON struct {
+		Name      string `json:"name"`
+		Path      string `json:"path"`
+		Installer string `json:"installer"`
+		UID       int    `json:"uid"`
+		System    bool   `json:"system"`
+		Enabled   bool   `json:"enabled"`
+		Installed bool   `json:"installed"`
+	}
+
+	records := make([]recordJSON, 0, len(inv.Packages))
+	for _, rec := range inv.Packages {
+		records = append(records, recordJSON{
+			Name:      rec.Name,
+			Path:      rec.Path,
+			Installer: rec.Installer,
+			UID:       rec.UID,
+			System:    rec.System,
+			Enabled:   rec.Enabled,
+			Installed: rec.Installed,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":   inv.UserID,
+		"takenAt":  inv.TakenAt,
+		"packages": records,
+	})
+}
+
+func (s *Server) handleSelection(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodPost) {
+		return
+	}
+
+	var req struct {
+		Packages []string `json:"packages"`
+		Select   []string `json:"select"`
+		Deselect []string `json:"deselect"`
+	}
+	if r.Method != http.MethodGet && !readJSON(w, r, &req) {
+		return
+	}
+
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+	defer s.mu.Unlock()
+
+	manager := eng.Manager()
+	if r.Method != http.MethodGet {
+		// The selection is left alone unless every name is known
+		var unknown []string
+		for _, names := range [][]string{req.Packages, req.Select, req.Deselect} {
+			for _, name := range names {
+				if manager.GetPackage(name) == nil {
+					unknown = append(unknown, name)
+				}
+			}
+		}
+		if len(unknown) > 0 {
+			writeError(w, http.StatusUnprocessableEntity,
+				fmt.Errorf("unknown packages: %s", strings.Join(unknown, ", ")))
+			return
+		}
+
+		apply := func(names []string, selected bool) {
+			for _, name := range names {
+				manager.SetSelected(name, selected)
+			}
+		}
+
+		// PUT replaces the selection, POST adds and removes entries
+		if r.Method == http.MethodPut {
+			manager.DeselectAll()
+			apply(req.Packages, true)
+		}
+		apply(req.Select, true)
+		apply(req.Deselect, false)
+	}
+
+	selected := []string{}
+	for _, pkg := range manager.GetSelectedPackages() {
+		selected = append(selected, pkg.Name)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"packages": selected})
+}
+
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	eng, ok := s.lockEngine(w)
+	if !ok {
+		return
+	}
+	items, err := eng.Plan()
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	if items == nil {
+		items = []engine.PlanItem{}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// currentEngine returns the engine of the selected device, or nil
+func (s *Server) currentEngine() *engine.Engine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.engine
+}
+
+// requireEngine writes an error when no device has been selected yet
+func (s *Server) requireEngine(w http.ResponseWriter) (*engine.Engine, bool) {
+	eng := s.currentEngine()
+	if eng == nil {
+		writeError(w, http.StatusConflict, errors.New("no device selected"))
+		return nil, false
+	}
+	return eng, true
+}
+
+// lockEngine locks the server for a request that reads or changes the
+// engine, writing an error when no device has been selected yet. A run owns
+// the engine, its selection and its inventory until it finishes, so the
+// request is refused meanwhile. The caller unlocks s.mu.
+func (s *Server) lockEngine(w http.ResponseWriter) (*engine.Engine, bool) {
+	s.mu.Lock()
+	if s.engine == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, errors.New("no device selected"))
+		return nil, false
+	}
+	if s.active != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, errBusy)
+		return nil, false
+	}
+	return s.engine, true
+}
+
+// statusFor maps engine errors to HTTP status codes
+func statusFor(err error) int {
+	if errors.Is(err, errBusy) {
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+// allowMethods rejects requests with any other method
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// readJSON decodes the request body, writing an error on failure
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
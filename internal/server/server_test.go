@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/testutil"
+)
+
+const testSerial = "test-device"
+
+// gateTransport holds the first measurement of free storage once armed, so a
+// test can act while a run is in progress. It fails the commands containing
+// fail, if set.
+type gateTransport struct {
+	adb.Transport
+	armed   atomic.Bool
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+	fail    atomic.Value // string
+}
+
+func (g *gateTransport) Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if fail, _ := g.fail.Load().(string); fail != "" && strings.Contains(strings.Join(args, " "), fail) {
+		return &adb.ExitError{Code: 1}
+	}
+	if g.armed.Load() && strings.Contains(strings.Join(args, " "), "df -k") {
+		g.once.Do(func() {
+			close(g.entered)
+			<-g.release
+		})
+	}
+	return g.Transport.Run(args, stdin, stdout, stderr)
+}
+
+// newTestServer serves the API for a simulated device holding a few
+// packages, all of them in the pack. The tracker is the browser of the user.
+func newTestServer(t *testing.T) (*httptest.Server, *gateTransport) {
+	t.Helper()
+	snapshot := &adb.Snapshot{
+		Device: adb.SnapshotDevice{Serial: testSerial, Manufacturer: "Test", Model: "Phone", AndroidVersion: "13", Fingerprint: "test/phone/1"},
+		Users:  []string{"0"},
+		Packages: []*adb.SimPackage{
+			{Name: "android", Path: "/system/framework/framework-res.apk", System: true},
+			{Name: "com.example.bloat", Path: "/system/app/Bloat/Bloat.apk", System: true},
+			{Name: "com.example.tracker", Path: "/system/app/Tracker/Tracker.apk", System: true},
+		},
+		Roles: map[string]map[string][]string{"0": {adb.RoleBrowser: {"com.example.tracker"}}},
+	}
+	gate := &gateTransport{
+		Transport: adb.NewSimulator(snapshot),
+		entered:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+
+	packs := testutil.Packs(t,
+		"com.example.bloat # Bloat | Ads | SAFE",
+		"com.example.tracker # Tracker | Analytics | SAFE",
+	)
+	srv := New(adb.NewClientWithTransport(gate), Options{
+		Config:    testutil.Config(t, packs),
+		BackupDir: filepath.Join(t.TempDir(), "backups"),
+	})
+	if err := srv.SelectDevice(testSerial); err != nil {
+		t.Fatalf("SelectDevice: %v", err)
+	}
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts, gate
+}
+
+// do sends a JSON request to the test server and decodes the response
+func do(t *testing.T, ts *httptest.Server, method, path, body string, out interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestGuard(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{name: "read", method: http.MethodGet, want: http.StatusOK},
+		{name: "localhost", method: http.MethodGet, host: "localhost:8080", want: http.StatusOK},
+		{name: "ip address", method: http.MethodGet, host: "192.168.1.20:8080", want: http.StatusOK},
+		{name: "rebound name", method: http.MethodGet, host: "attacker.example:8080", want: http.StatusForbidden},
+		{name: "own origin", method: http.MethodPost, origin: "self", contentType: "application/json", want: http.StatusOK},
+		{name: "foreign origin", method: http.MethodPost, origin: "http://attacker.example", contentType: "application/json", want: http.StatusForbidden},
+		{name: "json with charset", method: http.MethodPost, contentType: "application/json; charset=utf-8", want: http.StatusOK},
+		{name: "form post", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", want: http.StatusUnsupportedMediaType},
+		{name: "text post", method: http.MethodPost, contentType: "text/plain", want: http.StatusUnsupportedMediaType},
+		{name: "no content type", method: http.MethodPost, want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+"/api/selection", strings.NewReader(`{"select":[]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.origin == "self" {
+				req.Header.Set("Origin", ts.URL)
+			} else if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectionAndPlan(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var selection struct {
+		Packages []string `json:"packages"`
+	}
+	if status := do(t, ts, http.MethodPut, "/api/selection", `{"packages":["com.example.bloat"]}`, &selection); status != http.StatusOK {
+		t.Fatalf("PUT /api/selection: status %d", status)
+	}
+	if len(selection.Packages) != 1 || selection.Packages[0] != "com.example.bloat" {
+		t.Fatalf("selection = %v, want [com.example.bloat]", selection.Packages)
+	}
+
+	// Changes naming an unknown package are refused as a whole
+	rejected := []struct {
+		method string
+		body   string
+	}{
+		{http.MethodPost, `{"select":["com.example.unknown"]}`},
+		{http.MethodPut, `{"packages":["com.example.tracker","com.example.unknown"]}`},
+		{http.MethodPost, `{"select":["com.example.tracker"],"deselect":["com.example.bloat","com.example.unknown"]}`},
+	}
+	for _, tt := range rejected {
+		if status := do(t, ts, tt.method, "/api/selection", tt.body, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.body, status, http.StatusUnprocessableEntity)
+		}
+		if status := do(t, ts, http.MethodGet, "/api/selection", "", &selection); status != http.StatusOK {
+			t.Fatalf("GET /api/selection: status %d", status)
+		}
+		if len(selection.Packages) != 1 || selection.Packages[0] != "com.example.bloat" {
+			t.Errorf("%s %s changed the selection to %v", tt.method, tt.body, selection.Packages)
+		}
+	}
+
+	var plan []struct {
+		Package string `json:"package"`
+		Result  string `json:"result"`
+	}
+	if status := do(t, ts, http.MethodPost, "/api/plan", "", &plan); status != http.StatusOK {
+		t.Fatalf("POST /api/plan: status %d", status)
+	}
+	if len(plan) != 1 || plan[0].Package != "com.example.bloat" {
+		t.Errorf("plan = %+v, want com.example.bloat only", plan)
+	}
+}
+
+// TestSelectUnscored selects a device whose packages cannot be scored, which
+// keep the risk of their pack
+func TestSelectUnscored(t *testing.T) {
+	ts, gate := newTestServer(t)
+	gate.fail.Store("dumpsys package")
+
+	if status := do(t, ts, http.MethodPut, "/api/device", `{"serial":"`+testSerial+`"}`, nil); status != http.StatusOK {
+		t.Fatalf("PUT /api/device: status %d", status)
+	}
+
+	var pkgs []struct {
+		Name      string          `json:"name"`
+		RiskLevel string          `json:"riskLevel"`
+		Score     *map[string]any `json:"score"`
+	}
+	if status := do(t, ts, http.MethodGet, "/api/packages", "", &pkgs); status != http.StatusOK {
+		t.Fatalf("GET /api/packages: status %d", status)
+	}
+	if len(pkgs) != 2 {
+		t.Fatalf("packages = %+v, want the two of the pack", pkgs)
+	}
+	for _, pkg := range pkgs {
+		if pkg.RiskLevel != "SAFE" || pkg.Score != nil {
+			t.Errorf("%s = %s scored %v, want SAFE without a score", pkg.Name, pkg.RiskLevel, pkg.Score)
+		}
+	}
+}
+
+func TestRunHoldsEngine(t *testing.T) {
+	ts, gate := newTestServer(t)
+
+	if status := do(t, ts, http.MethodPut, "/api/selection", `{"packages":["com.example.bloat"]}`, nil); status != http.StatusOK {
+		t.Fatalf("PUT /api/selection: status %d", status)
+	}
+
+	gate.armed.Store(true)
+	var started runJSON
+	if status := do(t, ts, http.MethodPost, "/api/runs", `{}`, &started); status != http.StatusAccepted {
+		t.Fatalf("POST /api/runs: status %d", status)
+	}
+	<-gate.entered
+
+	// The run owns the engine until it finishes
+	busy := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/api/packs", ""},
+		{http.MethodGet, "/api/packages", ""},
+		{http.MethodGet, "/api/inventory", ""},
+		{http.MethodGet, "/api/selection", ""},
+		{http.MethodPost, "/api/selection", `{"deselect":["com.example.bloat"]}`},
+		{http.MethodPost, "/api/plan", ""},
+		{http.MethodPost, "/api/runs", `{}`},
+	}
+	for _, req := range busy {
+		if status := do(t, ts, req.method, req.path, req.body, nil); status != http.StatusConflict {
+			t.Errorf("%s %s during a run: status %d, want %d", req.method, req.path, status, http.StatusConflict)
+		}
+	}
+	if status := do(t, ts, http.MethodGet, "/api/device", "", nil); status != http.StatusOK {
+		t.Errorf("GET /api/device during a run: status %d, want %d", status, http.StatusOK)
+	}
+
+	close(gate.release)
+
+	finished := waitRun(t, ts, started.ID)
+
+	if finished.State != runFinished || finished.Summary == nil || finished.Summary.Success != 1 {
+		t.Fatalf("run = %s %+v, want finished with 1 success", finished.State, finished.Summary)
+	}
+	if last := finished.Events[len(finished.Events)-1]; last.Type != "finished" {
+		t.Errorf("last event = %s, want finished", last.Type)
+	}
+
+	var pkgs []packageJSON
+	if status := do(t, ts, http.MethodGet, "/api/packages", "", &pkgs); status != http.StatusOK {
+		t.Fatalf("GET /api/packages after the run: status %d", status)
+	}
+	for _, pkg := range pkgs {
+		if want := pkg.Name != "com.example.bloat"; pkg.Installed != want {
+			t.Errorf("%s installed = %v, want %v", pkg.Name, pkg.Installed, want)
+		}
+	}
+}
+
+func TestRunRefusesDefaultApps(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	if status := do(t, ts, http.MethodPut, "/api/selection", `{"packages":["com.example.tracker"]}`, nil); status != http.StatusOK {
+		t.Fatalf("PUT /api/selection: status %d", status)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "refused", body: `{}`, want: http.StatusConflict},
+		{name: "dry run", body: `{"dryRun":true}`, want: http.StatusAccepted},
+		{name: "forced", body: `{"force":true}`, want: http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res struct {
+				ID    string `json:"id"`
+				Error string `json:"error"`
+				Force bool   `json:"force"`
+			}
+			if status := do(t, ts, http.MethodPost, "/api/runs", tt.body, &res); status != tt.want {
+				t.Fatalf("POST /api/runs %s: status %d, want %d", tt.body, status, tt.want)
+			}
+			if tt.want == http.StatusConflict {
+				if !res.Force || !strings.Contains(res.Error, "browser") {
+					t.Errorf("refusal = %+v, want the browser named and force offered", res)
+				}
+				return
+			}
+			waitRun(t, ts, res.ID)
+		})
+	}
+}
+
+// waitRun polls a run until it is no longer running
+func waitRun(t *testing.T, ts *httptest.Server, id string) runJSON {
+	t.Helper()
+
+	var run runJSON
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		do(t, ts, http.MethodGet, "/api/runs/"+id, "", &run)
+		if run.State != runRunning {
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
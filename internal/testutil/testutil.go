@@ -0,0 +1,83 @@
+// Package testutil holds the setup the tests of the other packages share:
+// recorded adb sessions, simulated devices, packs and configuration
+package testutil
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/config"
+)
+
+var update = flag.Bool("update", false, "record the sessions in testdata again from the device adb is connected to")
+
+// Session replays the adb session recorded in testdata. With -update it
+// records the session again through the adb of PATH, from the device of
+// ANDROID_SERIAL when several are connected.
+func Session(t *testing.T, session string) adb.Transport {
+	t.Helper()
+	filename := filepath.Join("testdata", session)
+
+	if !*update {
+		replay, err := adb.LoadReplay(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return replay
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	recording, err := adb.NewRecordingTransport(&adb.ExecTransport{Path: "adb"}, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recording.Close() })
+	return recording
+}
+
+// Simulator simulates the device of a snapshot file
+func Simulator(t *testing.T, filename string) *adb.Simulator {
+	t.Helper()
+	snapshot, err := adb.LoadSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adb.NewSimulator(snapshot)
+}
+
+// Client connects to the device transport reaches
+func Client(t *testing.T, transport adb.Transport) (*adb.Client, *adb.Device) {
+	t.Helper()
+	client := adb.NewClientWithTransport(transport)
+	device, err := client.GetDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, device
+}
+
+// Packs writes a pack of lines to a temporary file
+func Packs(t *testing.T, lines ...string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "packs.txt")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// Config is the default configuration with the packs of packsFile and the
+// overrides kept in a temporary directory
+func Config(t *testing.T, packsFile string) *config.Config {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.PackagesFile = packsFile
+	cfg.OverridesFile = filepath.Join(t.TempDir(), "overrides.json")
+	return cfg
+}
@@ -0,0 +1,281 @@
+package theme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Default is the name of the theme used when the config names none
+const Default = "default"
+
+// Monochrome is the name of the theme without colors, also used whenever
+// NO_COLOR is set
+const Monochrome = "monochrome"
+
+// Color is a color for light and dark terminal backgrounds. An empty color
+// leaves the terminal default in place.
+type Color struct {
+	Light string `json:"light"`
+	Dark  string `json:"dark"`
+}
+
+// Fixed returns a color that is the same on any background
+func Fixed(hex string) Color {
+	return Color{Light: hex, Dark: hex}
+}
+
+// UnmarshalJSON accepts a single color such as "#7D56F4" or an object with
+// light and dark variants
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*c = Fixed(single)
+		return nil
+	}
+
+	type variants Color
+	var v variants
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("expected a color or an object with light and dark colors")
+	}
+	*c = Color(v)
+	return nil
+}
+
+// IsZero reports whether the color leaves the terminal default in place
+func (c Color) IsZero() bool {
+	return c.Light == "" && c.Dark == ""
+}
+
+// Terminal returns the color for lipgloss, adapting to the terminal
+// background when the variants differ
+func (c Color) Terminal() lipgloss.TerminalColor {
+	switch {
+	case c.IsZero():
+		return lipgloss.NoColor{}
+	case c.Light == c.Dark:
+		return lipgloss.Color(c.Dark)
+	}
+	return lipgloss.AdaptiveColor{Light: c.Light, Dark: c.Dark}
+}
+
+// Hex returns the variant for the current terminal background
+func (c Color) Hex() string {
+	if lipgloss.HasDarkBackground() {
+		return c.Dark
+	}
+	return c.Light
+}
+
+// Palette holds the colors the interface is drawn with
+type Palette struct {
+	Primary   Color `json:"primary"`   // title bars and the list cursor
+	Accent    Color `json:"accent"`    // the status bar
+	OnPrimary Color `json:"onPrimary"` // text on primary and accent
+	Text      Color `json:"text"`
+	Muted     Color `json:"muted"` // help lines and descriptions
+	Success   Color `json:"success"`
+	Warning   Color `json:"warning"`
+	Error     Color `json:"error"`
+	Info      Color `json:"info"`
+	Safe      Color `json:"safe"`
+	Risky     Color `json:"risky"`
+	Danger    Color `json:"danger"`
+}
+
+// merge fills the colors p leaves empty from base
+func (p Palette) merge(base Palette) Palette {
+	fill := func(c *Color, b Color) {
+		if c.IsZero() {
+			*c = b
+		}
+	}
+	fill(&p.Primary, base.Primary)
+	fill(&p.Accent, base.Accent)
+	fill(&p.OnPrimary, base.OnPrimary)
+	fill(&p.Text, base.Text)
+	fill(&p.Muted, base.Muted)
+	fill(&p.Success, base.Success)
+	fill(&p.Warning, base.Warning)
+	fill(&p.Error, base.Error)
+	fill(&p.Info, base.Info)
+	fill(&p.Safe, base.Safe)
+	fill(&p.Risky, base.Risky)
+	fill(&p.Danger, base.Danger)
+	return p
+}
+
+// Theme is a named palette
+type Theme struct {
+	Name string
+	Palette
+	// Monochrome themes draw emphasis with bold, underline and reverse video
+	// instead of colors
+	Monochrome bool
+}
+
+// file is the format of a custom theme file
+type file struct {
+	Name   string  `json:"name"`
+	Base   string  `json:"base"` // built-in theme supplying the colors left out
+	Colors Palette `json:"colors"`
+}
+
+var builtin = map[string]*Theme{
+	Default: {
+		Name: Default,
+		Palette: Palette{
+			Primary:   Fixed("#7D56F4"),
+			Accent:    Fixed("#F25D94"),
+			OnPrimary: Fixed("#FAFAFA"),
+			Text:      Color{Light: "#1A1A1A", Dark: "#FAFAFA"},
+			Muted:     Color{Light: "#9CA3AF", Dark: "#6B7280"},
+			Success:   Color{Light: "#047857", Dark: "#04B575"},
+			Warning:   Color{Light: "#B45309", Dark: "#F59E0B"},
+			Error:     Color{Light: "#BE123C", Dark: "#F43F5E"},
+			Info:      Color{Light: "#1D4ED8", Dark: "#3B82F6"},
+			Safe:      Color{Light: "#047857", Dark: "#04B575"},
+			Risky:     Color{Light: "#B45309", Dark: "#F59E0B"},
+			Danger:    Color{Light: "#BE123C", Dark: "#F43F5E"},
+		},
+	},
+	"dark": {
+		Name: "dark",
+		Palette: Palette{
+			Primary:   Fixed("#7D56F4"),
+			Accent:    Fixed("#F25D94"),
+			OnPrimary: Fixed("#FAFAFA"),
+			Text:      Fixed("#FAFAFA"),
+			Muted:     Fixed("#6B7280"),
+			Success:   Fixed("#04B575"),
+			Warning:   Fixed("#F59E0B"),
+			Error:     Fixed("#F43F5E"),
+			Info:      Fixed("#3B82F6"),
+			Safe:      Fixed("#04B575"),
+			Risky:     Fixed("#F59E0B"),
+			Danger:    Fixed("#F43F5E"),
+		},
+	},
+	"light": {
+		Name: "light",
+		Palette: Palette{
+			Primary:   Fixed("#5B34D6"),
+			Accent:    Fixed("#C2185B"),
+			OnPrimary: Fixed("#FFFFFF"),
+			Text:      Fixed("#1A1A1A"),
+			Muted:     Fixed("#6B7280"),
+			Success:   Fixed("#047857"),
+			Warning:   Fixed("#B45309"),
+			Error:     Fixed("#BE123C"),
+			Info:      Fixed("#1D4ED8"),
+			Safe:      Fixed("#047857"),
+			Risky:     Fixed("#B45309"),
+			Danger:    Fixed("#BE123C"),
+		},
+	},
+	"high-contrast": {
+		Name: "high-contrast",
+		Palette: Palette{
+			Primary:   Color{Light: "#000000", Dark: "#FFFFFF"},
+			Accent:    Color{Light: "#0000FF", Dark: "#FFFF00"},
+			OnPrimary: Color{Light: "#FFFFFF", Dark: "#000000"},
+			Text:      Color{Light: "#000000", Dark: "#FFFFFF"},
+			Muted:     Color{Light: "#000000", Dark: "#FFFFFF"},
+			Success:   Color{Light: "#006400", Dark: "#00FF00"},
+			Warning:   Color{Light: "#8B4500", Dark: "#FFFF00"},
+			Error:     Color{Light: "#C00000", Dark: "#FF5555"},
+			Info:      Color{Light: "#0000FF", Dark: "#00FFFF"},
+			Safe:      Color{Light: "#006400", Dark: "#00FF00"},
+			Risky:     Color{Light: "#8B4500", Dark: "#FFFF00"},
+			Danger:    Color{Light: "#C00000", Dark: "#FF5555"},
+		},
+	},
+	Monochrome: {
+		Name:       Monochrome,
+		Monochrome: true,
+	},
+}
+
+// Names lists the built-in themes
+func Names() []string {
+	names := make([]string, 0, len(builtin))
+	for name := range builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builtin returns the built-in theme with the given name, or nil
+func Builtin(name string) *Theme {
+	return builtin[name]
+}
+
+// IsFile reports whether a theme setting names a custom theme file rather
+// than a built-in theme
+func IsFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".json")
+}
+
+// Load returns the built-in theme with the given name, or the custom theme
+// in the given .json file. NO_COLOR in the environment always selects the
+// monochrome theme.
+func Load(name string) (*Theme, error) {
+	if os.Getenv("NO_COLOR") != "" {
+		return builtin[Monochrome], nil
+	}
+	if name == "" {
+		name = Default
+	}
+
+	if IsFile(name) {
+		return LoadFile(name)
+	}
+
+	if t, ok := builtin[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown theme %q, expected one of %s or a .json theme file", name, strings.Join(Names(), ", "))
+}
+
+// LoadFile reads a custom theme. Colors the file leaves out come from its
+// base theme, the default theme when it names none.
+func LoadFile(filename string) (*Theme, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read theme file: %w", err)
+	}
+
+	var f file
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse theme file %s: %w", filename, err)
+	}
+
+	if f.Base == "" {
+		f.Base = Default
+	}
+	base, ok := builtin[f.Base]
+	if !ok {
+		return nil, fmt.Errorf("theme file %s: unknown base theme %q", filename, f.Base)
+	}
+
+	if f.Name == "" {
+		f.Name = filename
+	}
+
+	return &Theme{
+		Name:       f.Name,
+		Palette:    f.Colors.merge(base.Palette),
+		Monochrome: base.Monochrome,
+	}, nil
+}
@@ -2,11 +2,20 @@ package ui
 
 import (
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
 	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	"github.com/adb-cleaner/adb-cleaner/internal/theme"
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -14,34 +23,11 @@ import (
 	"github.com/charmbracelet/lipgloss"
 )
 
-// Styles
-var (
-	titleStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FAFAFA")).
-			Background(lipgloss.Color("#7D56F4")).
-			Padding(0, 1)
-
-	statusStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FAFAFA")).
-			Background(lipgloss.Color("#F25D94")).
-			Padding(0, 1)
-
-	successStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
-	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#F43F5E"))
-	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#F59E0B"))
-	infoStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#3B82F6"))
-
-	selectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4"))
-	normalStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA"))
-
-	safeStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
-	riskyStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#F59E0B"))
-	dangerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#F43F5E"))
-)
-
 // PackageItem represents a package in list
 type PackageItem struct {
-	pkg *packages.Package
+	pkg     *packages.Package
+	matched []int    // bytes of the name the search query matched
+	roles   []string // default app roles the package holds
 }
 
 func (p PackageItem) Title() string {
@@ -55,13 +41,31 @@ func (p PackageItem) Description() string {
 	}
 
 	risk := ""
-	switch p.pkg.RiskLevel {
-	case "SAFE":
-		risk = "[SAFE]"
-	case "RISKY":
-		risk = "[RISKY]"
-	case "DANGER":
-		risk = "[DANGER]"
+	switch level := p.pkg.Risk(); level {
+	case "SAFE", "RISKY", "DANGER":
+		risk = "[" + level + "]"
+	}
+	if p.pkg.Score != nil {
+		risk += fmt.Sprintf("[%d]", p.pkg.Score.Value)
+	}
+
+	if p.pkg.GetAction() == adb.ActionDisable {
+		risk += "[DISABLE]"
+	}
+
+	for _, role := range p.roles {
+		risk += roleBadge(role)
+	}
+
+	switch p.pkg.Pin {
+	case packages.PinNever:
+		risk += "[KEEP]"
+	case packages.PinAlways:
+		risk += "[ALWAYS]"
+	}
+
+	for _, tag := range p.pkg.Tags {
+		desc += " #" + tag
 	}
 
 	installed := ""
@@ -78,6 +82,8 @@ func (p PackageItem) FilterValue() string {
 
 // Model represents application model
 type Model struct {
+	engine         *engine.Engine
+	engineMu       sync.Mutex // held by background commands, the engine is not safe for concurrent use
 	adbClient      *adb.Client
 	packageManager *packages.Manager
 	device         *adb.Device
@@ -91,10 +97,56 @@ type Model struct {
 	state          AppState
 	selectedCount  int
 	successCount   int
+	wouldRemove    int  // packages the last run would remove, when it was a dry run
+	doneDryRun     bool // the last run was a dry run
 	failCount      int
 	skipCount      int
 	currentIndex   int
 	dryRun         bool
+	backupDir      string
+	backupAPKs     bool
+	archivePath    string
+	planStatus     string
+	unmatched      []string // packages of a re-applied run the packs do not cover
+	historyList    list.Model
+	resultList     list.Model
+	historyRun     *backup.Archive
+	historyStatus  string
+	forceRestore   string // archive of another device the user asked to restore once
+	showAllRuns    bool
+	styles         *styles
+	keys           keyMap
+	help           help.Model
+	showHelp       bool
+	filter         packages.Filter
+	visible        []*packages.Package
+	sidebar        sidebar
+	searchErr      string
+	discoverList   list.Model
+	discoverInfo   map[string]map[string]string
+	discoverStatus string
+	promote        promoteForm
+	userPack       string
+	detail         detailForm
+	listStatus     string
+	impacts        []packages.Impact
+	impactErr      string
+	includeRelated bool
+	defaults       *adb.Defaults
+	conflicts      []engine.DefaultConflict
+	conflictErr    string
+	checking       bool // the default apps and impacts of the selection are being looked up
+	checkID        int
+	replaceList    list.Model
+	replacing      engine.DefaultConflict
+	replaceStatus  string
+	savings        *engine.Savings
+	savingsStatus  string
+	measured       *engine.Measurement
+	notSimulated   string // why the last dry run only listed the packages
+	reportDir      string
+	reportFormats  []string
+	reports        []string
 }
 
 // AppState represents current application state
@@ -106,6 +158,12 @@ const (
 	StateProgress
 	StateDone
 	StateSearch
+	StateHistory
+	StateHistoryDetail
+	StateDiscover
+	StatePromote
+	StateDetail
+	StateReplace
 )
 
 // Messages
@@ -114,14 +172,29 @@ type progressMsg struct {
 	pkg    string
 	status string
 }
+type logMsg struct {
+	line  string
+	lines <-chan string
+}
+type planSavedMsg struct {
+	status string
+}
 type doneMsg struct {
-	success int
-	failed  int
-	skipped int
+	success      int
+	dryRun       bool
+	wouldRemove  int // packages a dry run would remove
+	failed       int
+	skipped      int
+	archive      string
+	measured     *engine.Measurement
+	reports      []string
+	notSimulated string
 }
 
 // NewApp creates a new application
-func NewApp(adbClient *adb.Client, pkgManager *packages.Manager, pkgs []*packages.Package, device *adb.Device) *Model {
+func NewApp(eng *engine.Engine) *Model {
+	pkgs := eng.Manager().GetPackages()
+
 	// Create list items
 	items := make([]list.Item, len(pkgs))
 	for i, pkg := range pkgs {
@@ -134,26 +207,88 @@ func NewApp(adbClient *adb.Client, pkgManager *packages.Manager, pkgs []*package
 	listModel.SetShowStatusBar(false)
 	listModel.SetFilteringEnabled(false)
 
-	// Initialize progress
-	progressModel := progress.New(progress.WithDefaultGradient())
-
 	// Initialize search input
 	searchInput := textinput.New()
 	searchInput.Placeholder = "Search packages..."
 	searchInput.CharLimit = 50
 
-	return &Model{
-		adbClient:      adbClient,
-		packageManager: pkgManager,
-		device:         device,
+	m := &Model{
+		engine:         eng,
+		adbClient:      eng.Client(),
+		packageManager: eng.Manager(),
+		device:         eng.Device(),
 		packages:       pkgs,
 		list:           listModel,
-		progress:       progressModel,
 		searchInput:    searchInput,
+		historyList:    newHistoryList("Run History"),
+		resultList:     newHistoryList("Run Results"),
+		discoverList:   newHistoryList("Discovered Packages"),
+		replaceList:    newHistoryList("Replacements"),
+		discoverInfo:   make(map[string]map[string]string),
 		state:          StateList,
+		selectedCount:  eng.Manager().GetSelectedCount(),
 		logMessages:    make([]string, 0),
 		dryRun:         false,
+		keys:           newKeyMap(),
+		help:           help.New(),
+		visible:        pkgs,
+		sidebar:        sidebar{manager: eng.Manager()},
+	}
+	m.SetTheme(theme.Builtin(theme.Default))
+	m.applyKeys()
+	return m
+}
+
+// SetKeys remaps actions to other keys, as given by the keys config setting
+func (m *Model) SetKeys(keys map[string][]string) error {
+	if err := m.keys.remap(keys); err != nil {
+		return err
+	}
+	m.applyKeys()
+	return nil
+}
+
+// applyKeys hands the keymap to the lists, which draw no help of their own
+func (m *Model) applyKeys() {
+	for _, l := range []*list.Model{&m.list, &m.historyList, &m.resultList, &m.discoverList, &m.replaceList} {
+		l.KeyMap = m.keys.list()
+		l.SetShowHelp(false)
+	}
+}
+
+// SetTheme draws the interface with a theme
+func (m *Model) SetTheme(t *theme.Theme) {
+	m.styles = newStyles(t)
+
+	m.list.SetDelegate(newPackageDelegate(m.styles))
+	m.styles.list(&m.list)
+	for _, l := range []*list.Model{&m.historyList, &m.resultList, &m.discoverList, &m.replaceList} {
+		l.SetDelegate(m.styles.delegate())
+		m.styles.list(l)
 	}
+
+	m.progress = m.styles.progress()
+
+	m.help.Styles.ShortKey = m.styles.helpKey
+	m.help.Styles.FullKey = m.styles.helpKey
+	m.help.Styles.ShortDesc = m.styles.help
+	m.help.Styles.FullDesc = m.styles.help
+	m.help.Styles.ShortSeparator = m.styles.help
+	m.help.Styles.FullSeparator = m.styles.help
+	m.help.Styles.Ellipsis = m.styles.help
+}
+
+// SetBackup configures whether APKs are pulled into backupDir before removal
+func (m *Model) SetBackup(backupDir string, apks bool) {
+	m.backupDir = backupDir
+	m.backupAPKs = apks
+}
+
+// SetReports configures the formats of the reports written to reportDir
+// after a run
+func (m *Model) SetReports(reportDir string, formats []string) {
+	m.reportDir = reportDir
+	m.reportFormats = formats
 }
 
 // Init initializes model
@@ -161,102 +296,41 @@ func (m *Model) Init() tea.Cmd {
 	return tea.Batch(
 		tea.EnterAltScreen,
 		m.tickCmd(),
+		tea.Sequence(m.scorePackages(), m.loadDefaults()),
 	)
 }
 
+// background runs fn outside of Update once no other command works on the
+// engine. Update never calls into the engine itself, so the interface stays
+// responsive while the device is queried.
+func (m *Model) background(fn func() tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		m.engineMu.Lock()
+		defer m.engineMu.Unlock()
+		return fn()
+	}
+}
+
 // Update updates model
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC:
-			return m, tea.Quit
-
-		case tea.KeyEnter:
-			switch m.state {
-			case StateList:
-				m.state = StateConfirm
-			case StateConfirm:
-				m.state = StateProgress
-				return m, m.startDebloat()
-			case StateDone:
-				return m, tea.Quit
-			}
-
-		case tea.KeyEsc:
-			if m.state == StateSearch {
-				m.state = StateList
-			}
-
-		case tea.KeyUp:
-			if m.state == StateList {
-				m.list.CursorUp()
-			}
-
-		case tea.KeyDown:
-			if m.state == StateList {
-				m.list.CursorDown()
-			}
-
-		case tea.KeySpace:
-			if m.state == StateList {
-				if idx := m.list.Index(); idx >= 0 && idx < len(m.packages) {
-					m.packages[idx].Selected = !m.packages[idx].Selected
-					m.updateSelectedCount()
-					m.list.SetItem(idx, PackageItem{pkg: m.packages[idx]})
-				}
-			}
-
-		case tea.KeyF1:
-			if m.state == StateList {
-				m.packageManager.SelectAll()
-				m.updateList()
-				m.updateSelectedCount()
-			}
-
-		case tea.KeyF2:
-			if m.state == StateList {
-				m.packageManager.DeselectAll()
-				m.updateList()
-				m.updateSelectedCount()
-			}
-
-		case tea.KeyF3:
-			if m.state == StateList {
-				m.packageManager.SelectInstalled()
-				m.updateList()
-				m.updateSelectedCount()
-			}
-
-		case tea.KeyF4:
-			if m.state == StateList {
-				m.packageManager.SelectByRiskLevel("SAFE")
-				m.updateList()
-				m.updateSelectedCount()
-			}
-
-		case tea.KeyF5:
-			if m.state == StateList {
-				m.state = StateSearch
-				m.searchInput.Focus()
-				return m, textinput.Blink
-			}
-
-		default:
-			// Handle rune input for search
-			if m.state == StateSearch {
-				m.searchInput, cmd = m.searchInput.Update(msg)
-				m.filterPackages()
-			}
+		if handled, cmd := m.handleKey(msg); handled {
+			return m, cmd
 		}
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
-		m.list.SetWidth(msg.Width - 4)
+		m.list.SetWidth(msg.Width - sidebarWidth - 4)
 		m.list.SetHeight(msg.Height - 10)
+		m.help.Width = msg.Width
+		m.historyList.SetSize(msg.Width-4, msg.Height-6)
+		m.resultList.SetSize(msg.Width-4, msg.Height-6)
+		m.discoverList.SetSize(msg.Width-4, msg.Height-6)
+		m.replaceList.SetSize(msg.Width-4, msg.Height-6)
 
 	case tickMsg:
 		return m, m.tickCmd()
@@ -264,24 +338,267 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case progressMsg:
 		m.addLog(fmt.Sprintf("[%s] %s", msg.status, msg.pkg))
 
+	case logMsg:
+		m.addLog(msg.line)
+		return m, waitLog(msg.lines)
+
 	case doneMsg:
 		m.successCount = msg.success
+		m.doneDryRun = msg.dryRun
+		m.wouldRemove = msg.wouldRemove
 		m.failCount = msg.failed
 		m.skipCount = msg.skipped
+		m.archivePath = msg.archive
+		m.measured = msg.measured
+		m.reports = msg.reports
+		m.notSimulated = msg.notSimulated
 		m.state = StateDone
+		m.updateList()
+
+	case restoreDoneMsg:
+		m.historyStatus = fmt.Sprintf("Restored %d packages, %d failed", msg.restored, msg.failed)
+		m.updateList()
+
+	case discoverMsg:
+		return m, m.showDiscovered(msg)
+
+	case discoverInfoMsg:
+		m.showInfo(msg)
+
+	case scoreMsg:
+		m.applyScores(msg)
+
+	case defaultsMsg:
+		m.showDefaults(msg)
+
+	case candidatesMsg:
+		m.showCandidates(msg)
+
+	case replacedMsg:
+		return m, m.showReplaced(msg)
+
+	case checkedMsg:
+		return m, m.showChecked(msg)
+
+	case planSavedMsg:
+		m.planStatus = msg.status
+
+	case adoptedMsg:
+		m.showAdopted()
+
+	case promotedMsg:
+		m.showPromoted(msg)
+
+	case savingsMsg:
+		m.showSavings(msg)
 	}
 
 	// Update components based on state
 	switch m.state {
 	case StateList:
 		m.list, cmd = m.list.Update(msg)
-	case StateSearch:
-		m.searchInput, cmd = m.searchInput.Update(msg)
+	case StateHistory:
+		m.historyList, cmd = m.historyList.Update(msg)
+	case StateHistoryDetail:
+		m.resultList, cmd = m.resultList.Update(msg)
+	case StateDiscover:
+		m.discoverList, cmd = m.discoverList.Update(msg)
+		cmd = tea.Batch(cmd, m.fetchInfo())
+	case StateReplace:
+		m.replaceList, cmd = m.replaceList.Update(msg)
 	}
 
 	return m, cmd
 }
 
+// handleKey runs the action bound to a key in the current state. Keys it
+// does not handle are passed on to the list of the state.
+func (m *Model) handleKey(msg tea.KeyMsg) (bool, tea.Cmd) {
+	// Ctrl+C quits from anywhere, whatever the keymap says
+	if msg.Type == tea.KeyCtrlC {
+		return true, tea.Quit
+	}
+
+	if m.state == StateSearch {
+		switch {
+		case key.Matches(msg, m.keys.Confirm):
+			m.searchInput.Blur()
+			m.state = StateList
+			return true, nil
+		case key.Matches(msg, m.keys.Back):
+			// Esc cancels the search and shows the whole list again
+			m.searchInput.Blur()
+			m.searchInput.SetValue("")
+			m.filterPackages()
+			m.state = StateList
+			return true, nil
+		}
+		var cmd tea.Cmd
+		m.searchInput, cmd = m.searchInput.Update(msg)
+		m.filterPackages()
+		return true, cmd
+	}
+
+	if m.state == StatePromote {
+		return true, m.handlePromoteKey(msg)
+	}
+	if m.state == StateDetail {
+		return true, m.handleDetailKey(msg)
+	}
+
+	if key.Matches(msg, m.keys.Help) {
+		m.showHelp = !m.showHelp
+		return true, nil
+	}
+	if m.showHelp {
+		if key.Matches(msg, m.keys.Back) {
+			m.showHelp = false
+		}
+		return true, nil
+	}
+
+	if m.state == StateList && m.sidebar.focused {
+		return true, m.handleSidebarKey(msg)
+	}
+
+	switch m.state {
+	case StateList:
+		m.listStatus = ""
+		switch {
+		case key.Matches(msg, m.keys.Confirm):
+			return true, m.openConfirm()
+		case key.Matches(msg, m.keys.Toggle):
+			if item, ok := m.list.SelectedItem().(PackageItem); ok {
+				if !item.pkg.SetSelected(!item.pkg.Selected) {
+					m.listStatus = fmt.Sprintf("%s is pinned as %s-remove, press %s to change its pin",
+						item.pkg.Name, item.pkg.Pin, m.keys.Details.Help().Key)
+				}
+				m.updateSelectedCount()
+				m.list.SetItem(m.list.Index(), item)
+			}
+		case key.Matches(msg, m.keys.Details):
+			return true, m.openDetail()
+		case key.Matches(msg, m.keys.SelectAll):
+			m.selectVisible(true)
+		case key.Matches(msg, m.keys.DeselectAll):
+			m.selectVisible(false)
+		case key.Matches(msg, m.keys.Filters):
+			m.sidebar.focused = true
+		case key.Matches(msg, m.keys.ClearFilters):
+			m.clearFilters()
+		case key.Matches(msg, m.keys.SelectInstalled):
+			m.packageManager.SelectInstalled()
+			m.updateList()
+			m.updateSelectedCount()
+		case key.Matches(msg, m.keys.SelectSafe):
+			m.packageManager.SelectByRiskLevel("SAFE")
+			m.updateList()
+			m.updateSelectedCount()
+		case key.Matches(msg, m.keys.Search):
+			m.state = StateSearch
+			m.searchInput.Focus()
+			return true, textinput.Blink
+		case key.Matches(msg, m.keys.History):
+			m.openHistory()
+		case key.Matches(msg, m.keys.Discover):
+			return true, m.openDiscover()
+		case key.Matches(msg, m.keys.Quit):
+			return true, tea.Quit
+		default:
+			return false, nil
+		}
+
+	case StateConfirm:
+		switch {
+		case key.Matches(msg, m.keys.Confirm):
+			if m.checking {
+				return true, nil
+			}
+			// Default apps are replaced before anything is removed
+			if conflict, ok := m.pendingConflict(); ok {
+				return true, m.openReplace(conflict)
+			}
+			m.state = StateProgress
+			return true, m.startDebloat()
+		case key.Matches(msg, m.keys.Back):
+			m.planStatus = ""
+			m.state = StateList
+		case key.Matches(msg, m.keys.DryRun):
+			m.dryRun = !m.dryRun
+		case key.Matches(msg, m.keys.BackupAPKs):
+			m.backupAPKs = !m.backupAPKs
+		case key.Matches(msg, m.keys.SavePlan):
+			return true, m.savePlan()
+		case key.Matches(msg, m.keys.IncludeRelated):
+			if m.checking {
+				return true, nil
+			}
+			return true, m.checkSelection(true)
+		}
+
+	case StateDone:
+		if key.Matches(msg, m.keys.Confirm, m.keys.Quit) {
+			return true, tea.Quit
+		}
+
+	case StateHistory:
+		switch {
+		case key.Matches(msg, m.keys.Confirm):
+			m.openRun()
+		case key.Matches(msg, m.keys.Back):
+			m.state = StateList
+		case key.Matches(msg, m.keys.AllRuns):
+			m.showAllRuns = !m.showAllRuns
+			m.loadHistory()
+		case key.Matches(msg, m.keys.Quit):
+			return true, tea.Quit
+		default:
+			return false, nil
+		}
+
+	case StateHistoryDetail:
+		switch {
+		case key.Matches(msg, m.keys.Back):
+			m.historyStatus = ""
+			m.state = StateHistory
+		case key.Matches(msg, m.keys.Restore):
+			return true, m.restoreRun()
+		case key.Matches(msg, m.keys.Reapply):
+			return true, m.reapplyRun()
+		default:
+			return false, nil
+		}
+
+	case StateDiscover:
+		return m.handleDiscoverKey(msg)
+
+	case StateReplace:
+		return m.handleReplaceKey(msg)
+	}
+
+	return true, nil
+}
+
+// handleSidebarKey moves through the filter sidebar and applies its options
+func (m *Model) handleSidebarKey(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, m.keys.Up):
+		m.sidebar.move(-1)
+	case key.Matches(msg, m.keys.Down):
+		m.sidebar.move(1)
+	case key.Matches(msg, m.keys.Toggle), key.Matches(msg, m.keys.Confirm):
+		m.sidebar.choose(&m.filter)
+		m.updateList()
+	case key.Matches(msg, m.keys.ClearFilters):
+		m.clearFilters()
+	case key.Matches(msg, m.keys.Filters), key.Matches(msg, m.keys.Back):
+		m.sidebar.focused = false
+	case key.Matches(msg, m.keys.Quit):
+		return tea.Quit
+	}
+	return nil
+}
+
 // View renders model
 func (m *Model) View() string {
 	var content strings.Builder
@@ -290,11 +607,20 @@ func (m *Model) View() string {
 	content.WriteString(m.renderHeader())
 	content.WriteString("\n")
 
+	if m.showHelp {
+		content.WriteString(m.renderFullHelp())
+		return content.String()
+	}
+
 	// Main content based on state
 	switch m.state {
 	case StateList:
 		content.WriteString(m.renderList())
 		content.WriteString("\n")
+		if m.listStatus != "" {
+			content.WriteString(m.styles.warning.Render(m.listStatus))
+			content.WriteString("\n")
+		}
 		content.WriteString(m.renderHelp())
 	case StateConfirm:
 		content.WriteString(m.renderConfirm())
@@ -304,49 +630,103 @@ func (m *Model) View() string {
 		content.WriteString(m.renderDone())
 	case StateSearch:
 		content.WriteString(m.renderSearch())
+	case StateHistory:
+		content.WriteString(m.renderHistory())
+	case StateHistoryDetail:
+		content.WriteString(m.renderRunDetail())
+	case StateDiscover:
+		content.WriteString(m.renderDiscover())
+	case StatePromote:
+		content.WriteString(m.renderPromote())
+	case StateDetail:
+		content.WriteString(m.renderDetail())
+	case StateReplace:
+		content.WriteString(m.renderReplace())
 	}
 
 	return content.String()
 }
 
 func (m *Model) renderHeader() string {
-	return fmt.Sprintf("%s %s %s %s",
-		titleStyle.Render("ADB Cleaner v2.0"),
-		infoStyle.Render(fmt.Sprintf("Device: %s %s", m.device.Manufacturer, m.device.Model)),
-		infoStyle.Render(fmt.Sprintf("Android: %s", m.device.AndroidVersion)),
-		statusStyle.Render(fmt.Sprintf("Selected: %d/%d", m.selectedCount, len(m.packages))),
+	return fmt.Sprintf("%s %s %s %s %s",
+		m.styles.title.Render("ADB Cleaner v2.0"),
+		m.styles.info.Render(fmt.Sprintf("Device: %s %s", m.device.Manufacturer, m.device.Model)),
+		m.styles.info.Render(fmt.Sprintf("Android: %s", m.device.AndroidVersion)),
+		m.styles.info.Render(fmt.Sprintf("Profile: %s", m.engine.Profile())),
+		m.styles.status.Render(fmt.Sprintf("Selected: %d/%d", m.selectedCount, len(m.packages))),
 	)
 }
 
+// renderList renders the filter sidebar next to the package list
 func (m *Model) renderList() string {
-	return m.list.View()
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		m.sidebar.view(m.filter, m.styles, m.list.Height()),
+		m.list.View(),
+	)
 }
 
+// activeKeys returns the bindings of the focused part of the current state
+func (m *Model) activeKeys() stateKeys {
+	if m.state == StateList && m.sidebar.focused {
+		return m.keys.forSidebar()
+	}
+	return m.keys.forState(m.state)
+}
+
+// renderHelp renders the short help of the current state
 func (m *Model) renderHelp() string {
-	help := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(
-		"↑/↓: Navigate | Space: Toggle | F1: Select All | F2: Deselect All | F3: Select Installed | F4: Select Safe | F5: Search | Enter: Confirm | Ctrl+C: Quit",
+	return m.help.ShortHelpView(m.activeKeys().ShortHelp())
+}
+
+// renderFullHelp renders every binding of the current state in a box
+func (m *Model) renderFullHelp() string {
+	box := m.styles.helpBox.Render(
+		m.styles.title.Render("Keys") + "\n\n" +
+			m.help.FullHelpView(m.activeKeys().FullHelp()) + "\n\n" +
+			m.styles.help.Render(fmt.Sprintf("%s or %s to close", m.keys.Help.Help().Key, m.keys.Back.Help().Key)),
 	)
-	return help
+	return "\n" + lipgloss.PlaceHorizontal(m.width, lipgloss.Center, box)
 }
 
 func (m *Model) renderConfirm() string {
 	var content strings.Builder
 
 	content.WriteString("\n")
-	content.WriteString(titleStyle.Render("Confirm Removal"))
+	content.WriteString(m.styles.title.Render("Confirm Removal"))
 	content.WriteString("\n\n")
 
 	selected := m.packageManager.GetSelectedPackages()
 	content.WriteString(fmt.Sprintf("You are about to remove %d packages.\n\n", len(selected)))
+	content.WriteString(m.renderSavings())
+	if m.checking {
+		content.WriteString(m.styles.help.Render("Checking default apps and related packages…"))
+		content.WriteString("\n\n")
+	}
+	content.WriteString(m.renderConflicts())
+	content.WriteString(m.renderImpacts())
+
+	if len(m.unmatched) > 0 {
+		content.WriteString(m.styles.warning.Render(fmt.Sprintf("Not in the loaded packs, left out: %s", strings.Join(m.unmatched, ", "))))
+		content.WriteString("\n\n")
+	}
 
 	if m.dryRun {
-		content.WriteString(warningStyle.Render("DRY RUN MODE - No packages will be removed"))
+		content.WriteString(m.styles.warning.Render("DRY RUN MODE - No packages will be removed"))
 		content.WriteString("\n\n")
 	}
 
-	content.WriteString("Press Tab to toggle dry run mode\n")
-	content.WriteString("Press Enter to continue\n")
-	content.WriteString("Press Esc to go back\n")
+	if m.backupAPKs {
+		content.WriteString(m.styles.info.Render(fmt.Sprintf("APK backup enabled - APKs are saved to %s", m.backupDir)))
+		content.WriteString("\n\n")
+	}
+
+	if m.planStatus != "" {
+		content.WriteString(m.styles.info.Render(m.planStatus))
+		content.WriteString("\n\n")
+	}
+
+	content.WriteString(m.renderHelp())
+	content.WriteString("\n")
 
 	return content.String()
 }
@@ -355,7 +735,7 @@ func (m *Model) renderProgress() string {
 	var content strings.Builder
 
 	content.WriteString("\n")
-	content.WriteString(titleStyle.Render("Removing Packages"))
+	content.WriteString(m.styles.title.Render("Removing Packages"))
 	content.WriteString("\n\n")
 
 	content.WriteString(m.progress.View())
@@ -380,17 +760,39 @@ func (m *Model) renderDone() string {
 	var content strings.Builder
 
 	content.WriteString("\n")
-	content.WriteString(titleStyle.Render("Debloat Complete"))
-	content.WriteString("\n\n")
-
-	content.WriteString(successStyle.Render(fmt.Sprintf("✓ Successfully removed: %d", m.successCount)))
-	content.WriteString("\n")
-	content.WriteString(errorStyle.Render(fmt.Sprintf("✗ Failed: %d", m.failCount)))
+	if m.doneDryRun {
+		content.WriteString(m.styles.title.Render("Dry Run Complete"))
+		content.WriteString("\n\n")
+		content.WriteString(m.styles.success.Render(fmt.Sprintf("✓ Would be removed: %d", m.wouldRemove)))
+		content.WriteString("\n")
+		content.WriteString(m.styles.err.Render(fmt.Sprintf("✗ Would fail: %d", m.failCount)))
+	} else {
+		content.WriteString(m.styles.title.Render("Debloat Complete"))
+		content.WriteString("\n\n")
+		content.WriteString(m.styles.success.Render(fmt.Sprintf("✓ Successfully removed: %d", m.successCount)))
+		content.WriteString("\n")
+		content.WriteString(m.styles.err.Render(fmt.Sprintf("✗ Failed: %d", m.failCount)))
+	}
 	content.WriteString("\n")
-	content.WriteString(warningStyle.Render(fmt.Sprintf("○ Skipped: %d", m.skipCount)))
+	content.WriteString(m.styles.warning.Render(fmt.Sprintf("○ Skipped: %d", m.skipCount)))
 	content.WriteString("\n\n")
+	content.WriteString(m.renderMeasured())
+	if m.notSimulated != "" {
+		content.WriteString(m.styles.warning.Render("Dry run not simulated, the packages were only listed: " + m.notSimulated))
+		content.WriteString("\n\n")
+	}
 
-	content.WriteString("Press Enter to exit\n")
+	if m.archivePath != "" {
+		content.WriteString(m.styles.info.Render(fmt.Sprintf("Backup saved to %s", m.archivePath)))
+		content.WriteString("\n\n")
+	}
+	if len(m.reports) > 0 {
+		content.WriteString(m.styles.info.Render(fmt.Sprintf("Report saved to %s", strings.Join(m.reports, ", "))))
+		content.WriteString("\n\n")
+	}
+
+	content.WriteString(m.renderHelp())
+	content.WriteString("\n")
 
 	return content.String()
 }
@@ -399,17 +801,23 @@ func (m *Model) renderSearch() string {
 	var content strings.Builder
 
 	content.WriteString("\n")
-	content.WriteString(titleStyle.Render("Search Packages"))
+	content.WriteString(m.styles.title.Render("Search Packages"))
 	content.WriteString("\n\n")
 
 	content.WriteString("Search: ")
 	content.WriteString(m.searchInput.View())
+	content.WriteString("\n")
+	if m.searchErr != "" {
+		content.WriteString(m.styles.err.Render(m.searchErr))
+	} else {
+		content.WriteString(m.styles.help.Render("Fuzzy match on names, or /regexp on names and descriptions"))
+	}
 	content.WriteString("\n\n")
 
-	content.WriteString(m.list.View())
+	content.WriteString(m.renderList())
 	content.WriteString("\n\n")
 
-	content.WriteString("Press Esc to exit search mode")
+	content.WriteString(m.renderHelp())
 
 	return content.String()
 }
@@ -420,78 +828,163 @@ func (m *Model) tickCmd() tea.Cmd {
 	})
 }
 
+// savePlan writes a plan file for the current selection to the backup
+// directory in the background, so it can be reviewed and applied later with
+// the apply command
+func (m *Model) savePlan() tea.Cmd {
+	m.planStatus = "Saving plan..."
+	backupDir := m.backupDir
+
+	return m.background(func() tea.Msg {
+		plan, err := m.engine.PlanFile()
+		if err != nil {
+			return planSavedMsg{status: err.Error()}
+		}
+
+		if err := os.MkdirAll(backupDir, 0755); err != nil {
+			return planSavedMsg{status: fmt.Sprintf("failed to create backup directory: %v", err)}
+		}
+
+		filename := filepath.Join(backupDir, fmt.Sprintf("plan_%s.json", time.Now().Format("20060102_150405")))
+		if err := plan.Save(filename); err != nil {
+			return planSavedMsg{status: err.Error()}
+		}
+
+		slog.Info("plan saved", "file", filename, "actions", len(plan.Actions), "skipped", len(plan.Skipped))
+		return planSavedMsg{status: fmt.Sprintf("Plan saved to %s (%d to remove, %d skipped)", filename, len(plan.Actions), len(plan.Skipped))}
+	})
+}
+
 func (m *Model) startDebloat() tea.Cmd {
-	return func() tea.Msg {
-		selected := m.packageManager.GetSelectedPackages()
-		success := 0
-		failed := 0
-		skipped := 0
-
-		for _, pkg := range selected {
-			if m.dryRun {
-				m.addLog(fmt.Sprintf("[DRY-RUN] %s", pkg.Name))
-				success++
-				continue
-			}
+	// Confirming with default apps that could not be checked removes the
+	// selection anyway; unreplaced ones never get this far
+	opts := engine.Options{
+		DryRun:        m.dryRun,
+		BackupAPKs:    m.backupAPKs,
+		BackupDir:     m.backupDir,
+		ReportDir:     m.reportDir,
+		ReportFormats: m.reportFormats,
+		Force:         m.conflictErr != "",
+	}
+	lines := make(chan string, logBuffer)
 
-			if !pkg.Installed {
-				m.addLog(fmt.Sprintf("[SKIP] %s", pkg.Name))
-				skipped++
-				continue
-			}
+	run := m.background(func() tea.Msg {
+		defer close(lines)
 
-			installed, err := m.adbClient.IsPackageInstalled(pkg.Name)
-			if err != nil || !installed {
-				m.addLog(fmt.Sprintf("[SKIP] %s", pkg.Name))
-				skipped++
-				continue
-			}
+		slog.Info("run started", "packages", m.packageManager.GetSelectedCount(), "users", m.engine.Users(),
+			"dryRun", opts.DryRun, "backupApks", opts.BackupAPKs)
 
-			ok, err := m.adbClient.UninstallPackage(pkg.Name, m.device.UserID)
-			if err != nil || !ok {
-				m.addLog(fmt.Sprintf("[FAIL] %s", pkg.Name))
-				failed++
-			} else {
-				m.addLog(fmt.Sprintf("[SUCCESS] %s", pkg.Name))
-				success++
+		summary, err := m.engine.Run(opts, func(ev engine.Event) {
+			logEvent(ev)
+			if ev.Type != engine.EventStarted && ev.Type != engine.EventFinished {
+				lines <- ev.String()
 			}
+		})
+		if err != nil {
+			slog.Error("run failed", "error", err)
+			lines <- fmt.Sprintf("[FAIL] %v", err)
+			return doneMsg{dryRun: opts.DryRun, failed: m.packageManager.GetSelectedCount()}
 		}
-
-		return doneMsg{success: success, failed: failed, skipped: skipped}
-	}
+		slog.Info("run finished", "success", summary.Success, "dryRun", summary.DryRun, "failed", summary.Failed, "skipped", summary.Skipped,
+			"archives", summary.Archives, "reports", summary.Reports)
+
+		return doneMsg{
+			success:      summary.Success,
+			dryRun:       opts.DryRun,
+			wouldRemove:  summary.DryRun,
+			failed:       summary.Failed,
+			skipped:      summary.Skipped,
+			archive:      strings.Join(summary.Archives, ", "),
+			measured:     summary.Measured,
+			reports:      summary.Reports,
+			notSimulated: summary.NotSimulated,
+		}
+	})
+	return tea.Batch(run, waitLog(lines))
 }
 
 func (m *Model) updateSelectedCount() {
 	m.selectedCount = m.packageManager.GetSelectedCount()
 }
 
+// updateList shows the packages passing the filter
 func (m *Model) updateList() {
-	items := make([]list.Item, len(m.packages))
-	for i, pkg := range m.packages {
-		items[i] = PackageItem{pkg: pkg}
+	matches, err := m.packageManager.Search(m.filter)
+	m.searchErr = ""
+	if err != nil {
+		m.searchErr = err.Error()
+	}
+
+	m.visible = make([]*packages.Package, len(matches))
+	items := make([]list.Item, len(matches))
+	for i, match := range matches {
+		m.visible[i] = match.Package
+		items[i] = PackageItem{pkg: match.Package, matched: match.NameIndexes, roles: m.defaults.Held(match.Package.Name)}
 	}
 	m.list.SetItems(items)
+
+	m.list.Title = "Packages to Remove"
+	if !m.filter.IsZero() {
+		m.list.Title = fmt.Sprintf("Packages to Remove (%d of %d)", len(m.visible), len(m.packages))
+	}
 }
 
+// filterPackages narrows the list to the search query, on top of the
+// sidebar filters
 func (m *Model) filterPackages() {
-	query := m.searchInput.Value()
-	if query == "" {
-		m.updateList()
-		return
-	}
+	m.filter.Query = m.searchInput.Value()
+	m.updateList()
+}
 
-	filtered := m.packageManager.SearchPackages(query)
-	items := make([]list.Item, len(filtered))
-	for i, pkg := range filtered {
-		items[i] = PackageItem{pkg: pkg}
+// clearFilters resets the sidebar filters and the search query
+func (m *Model) clearFilters() {
+	m.filter = packages.Filter{}
+	m.searchInput.SetValue("")
+	m.updateList()
+}
+
+// selectVisible selects or deselects the packages the filters show
+func (m *Model) selectVisible(selected bool) {
+	for _, pkg := range m.visible {
+		pkg.SetSelected(selected)
 	}
-	m.list.SetItems(items)
+	m.updateList()
+	m.updateSelectedCount()
 }
 
 func (m *Model) addLog(msg string) {
 	m.logMessages = append(m.logMessages, msg)
 }
 
+// logBuffer is the number of log lines a background run gets ahead of the
+// screen
+const logBuffer = 64
+
+// waitLog hands the next line a background command logs to Update, which
+// waits for the one after it in turn
+func waitLog(lines <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		line, ok := <-lines
+		if !ok {
+			return nil
+		}
+		return logMsg{line: line, lines: lines}
+	}
+}
+
+// logEvent records the warnings and outcome of a run in the log file. The
+// adb client logs each package itself.
+func logEvent(ev engine.Event) {
+	switch ev.Type {
+	case engine.EventWarning:
+		slog.Warn("run warning", "package", ev.Package, "message", ev.Message)
+	case engine.EventBackup:
+		slog.Info("apk backed up", "package", ev.Package)
+	case engine.EventFinished:
+		slog.Info(ev.Message)
+	}
+}
+
 // Run starts application
 func (m *Model) Run() error {
 	p := tea.NewProgram(m)
@@ -0,0 +1,137 @@
+package ui
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/adb-cleaner/adb-cleaner/internal/testutil"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestModel opens the demo snapshot with the packs of the repository
+func newTestModel(t *testing.T) *Model {
+	t.Helper()
+
+	client, device := testutil.Client(t, testutil.Simulator(t, filepath.Join("..", "..", "snapshots", "demo.json")))
+	cfg := testutil.Config(t, filepath.Join("..", "..", "packs.txt"))
+	eng, err := engine.ForDevice(client.WithSerial(device.ID), device, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewApp(eng)
+	m.SetBackup(t.TempDir(), false)
+	return m
+}
+
+// TestBackgroundCommands checks the selection while the commands of Init
+// still read the device. Run with -race.
+func TestBackgroundCommands(t *testing.T) {
+	m := newTestModel(t)
+	m.packageManager.SetSelected("com.mi.globalbrowser", true)
+	m.state = StateConfirm
+
+	cmds := []tea.Cmd{m.scorePackages(), m.loadDefaults(), m.startCheck(false)}
+	msgs := make(chan tea.Msg, len(cmds))
+	var wg sync.WaitGroup
+	for _, cmd := range cmds {
+		wg.Add(1)
+		go func(cmd tea.Cmd) {
+			defer wg.Done()
+			msgs <- cmd()
+		}(cmd)
+	}
+	wg.Wait()
+	close(msgs)
+
+	for msg := range msgs {
+		m.Update(msg)
+	}
+	if m.listStatus != "" {
+		t.Errorf("list status = %q", m.listStatus)
+	}
+	if _, ok := m.pendingConflict(); !ok {
+		t.Errorf("conflicts = %+v, want the browser pending a replacement", m.conflicts)
+	}
+}
+
+// TestConfirmWhileBusy opens the confirm screen while a background command
+// holds the engine
+func TestConfirmWhileBusy(t *testing.T) {
+	m := newTestModel(t)
+	m.packageManager.SetSelected("com.mi.globalbrowser", true)
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+
+	m.engineMu.Lock()
+	updated := make(chan struct{})
+	go func() {
+		m.Update(enter)
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("Update waited for the engine")
+	}
+
+	if m.state != StateConfirm || !m.checking {
+		t.Fatalf("state = %v, checking = %v, want the confirm screen checking", m.state, m.checking)
+	}
+	if view := m.View(); !strings.Contains(view, "Checking default apps") {
+		t.Errorf("view does not show the check:\n%s", view)
+	}
+	m.Update(enter)
+	if m.state != StateConfirm {
+		t.Errorf("state = %v, want confirming to wait for the check", m.state)
+	}
+	m.engineMu.Unlock()
+
+	m.Update(m.startCheck(false)())
+	if m.checking {
+		t.Error("still checking")
+	}
+	if _, ok := m.pendingConflict(); !ok {
+		t.Errorf("conflicts = %+v, want the browser pending a replacement", m.conflicts)
+	}
+}
+
+// TestRunLog delivers the log of a dry run to Update while the run goes on.
+// Run with -race.
+func TestRunLog(t *testing.T) {
+	m := newTestModel(t)
+	m.packageManager.SetSelected("com.miui.analytics", true)
+	m.dryRun = true
+	m.state = StateProgress
+
+	cmds, ok := m.startDebloat()().(tea.BatchMsg)
+	if !ok || len(cmds) != 2 {
+		t.Fatalf("startDebloat returned %d commands, want the run and its log", len(cmds))
+	}
+	done := make(chan tea.Msg)
+	go func() {
+		done <- cmds[0]()
+	}()
+
+	next := cmds[1]
+	for next != nil {
+		msg := next()
+		if msg == nil {
+			break
+		}
+		_, next = m.Update(msg)
+	}
+	m.Update(<-done)
+
+	if m.state != StateDone {
+		t.Errorf("state = %v, want done", m.state)
+	}
+	if len(m.logMessages) == 0 || !strings.Contains(strings.Join(m.logMessages, "\n"), "com.miui.analytics") {
+		t.Errorf("log = %q, want the package", m.logMessages)
+	}
+	if view := m.View(); !strings.Contains(view, "Dry Run Complete") || !strings.Contains(view, "Would fail: 1") {
+		t.Errorf("done screen does not count the package as a dry run:\n%s", view)
+	}
+}
@@ -0,0 +1,218 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// CandidateItem represents a package or input method that can take over a
+// role
+type CandidateItem struct {
+	name string
+}
+
+func (c CandidateItem) Title() string {
+	return c.name
+}
+
+func (c CandidateItem) Description() string {
+	return "Make this the new default"
+}
+
+func (c CandidateItem) FilterValue() string {
+	return c.name
+}
+
+// Messages
+type defaultsMsg struct {
+	defaults *adb.Defaults
+	err      error
+}
+type candidatesMsg struct {
+	candidates []string
+	err        error
+}
+type replacedMsg struct {
+	conflict engine.DefaultConflict
+	holder   string
+	err      error
+}
+
+// roleBadge returns the short badge of a role in the package list
+func roleBadge(role string) string {
+	switch role {
+	case adb.RoleKeyboard:
+		return "[KEYBOARD]"
+	case adb.RoleAccessibility:
+		return "[A11Y]"
+	}
+	return "[" + strings.TrimPrefix(role, "android.app.role.") + "]"
+}
+
+// loadDefaults reads the default apps of the device user in the background,
+// so the list can badge them
+func (m *Model) loadDefaults() tea.Cmd {
+	return m.background(func() tea.Msg {
+		d, err := m.engine.Defaults(m.device.UserID)
+		return defaultsMsg{defaults: d, err: err}
+	})
+}
+
+// showDefaults badges the default apps in the list
+func (m *Model) showDefaults(msg defaultsMsg) {
+	if msg.err != nil {
+		m.listStatus = fmt.Sprintf("Default apps are not known: %v", msg.err)
+		return
+	}
+
+	m.defaults = msg.defaults
+	m.updateList()
+}
+
+// pendingConflict returns the first default app that still needs a
+// replacement, if any
+func (m *Model) pendingConflict() (engine.DefaultConflict, bool) {
+	for _, conflict := range m.conflicts {
+		if conflict.Replace {
+			return conflict, true
+		}
+	}
+	return engine.DefaultConflict{}, false
+}
+
+// openReplace switches to the screen picking a new default for a role and
+// lists the candidates in the background
+func (m *Model) openReplace(conflict engine.DefaultConflict) tea.Cmd {
+	m.replacing = conflict
+	m.replaceStatus = "Looking for replacements..."
+	m.replaceList.Title = fmt.Sprintf("New %s for user %s (replacing %s)", adb.RoleLabel(conflict.Role), conflict.User, conflict.Package)
+	m.replaceList.SetItems(nil)
+	m.state = StateReplace
+
+	return m.background(func() tea.Msg {
+		candidates, err := m.engine.RoleCandidates(conflict.User, conflict.Role)
+		return candidatesMsg{candidates: candidates, err: err}
+	})
+}
+
+// showCandidates lists the packages that can take over the role
+func (m *Model) showCandidates(msg candidatesMsg) {
+	if msg.err != nil {
+		m.replaceStatus = msg.err.Error()
+		return
+	}
+
+	items := make([]list.Item, len(msg.candidates))
+	for i, candidate := range msg.candidates {
+		items[i] = CandidateItem{name: candidate}
+	}
+	m.replaceList.SetItems(items)
+	m.replaceList.ResetSelected()
+
+	m.replaceStatus = ""
+	if len(items) == 0 {
+		m.replaceStatus = fmt.Sprintf("No other %s is installed: deselect %s to keep it", adb.RoleLabel(m.replacing.Role), m.replacing.Package)
+	}
+}
+
+// handleReplaceKey picks the highlighted candidate as the new default
+func (m *Model) handleReplaceKey(msg tea.KeyMsg) (bool, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Confirm):
+		item, ok := m.replaceList.SelectedItem().(CandidateItem)
+		if !ok {
+			return true, nil
+		}
+		conflict := m.replacing
+		m.replaceStatus = fmt.Sprintf("Making %s the %s...", item.name, adb.RoleLabel(conflict.Role))
+		return true, m.background(func() tea.Msg {
+			err := m.engine.ReplaceDefault(conflict.User, conflict.Role, item.name)
+			if err != nil {
+				slog.Warn("default not replaced", "role", conflict.Role, "user", conflict.User, "holder", item.name, "error", err)
+			} else {
+				slog.Info("default replaced", "role", conflict.Role, "user", conflict.User, "holder", item.name, "previous", conflict.Package)
+			}
+			return replacedMsg{conflict: conflict, holder: item.name, err: err}
+		})
+	case key.Matches(msg, m.keys.Back):
+		m.state = StateConfirm
+	case key.Matches(msg, m.keys.Quit):
+		return true, tea.Quit
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// showReplaced returns to the confirm screen once a new default is set and
+// checks the selection again
+func (m *Model) showReplaced(msg replacedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.replaceStatus = msg.err.Error()
+		return nil
+	}
+
+	m.state = StateConfirm
+	cmd := m.checkSelection(false)
+	m.planStatus = fmt.Sprintf("%s is now the %s of user %s", msg.holder, adb.RoleLabel(msg.conflict.Role), msg.conflict.User)
+	if msg.conflict.User == m.device.UserID {
+		cmd = tea.Batch(cmd, m.loadDefaults())
+	}
+	return cmd
+}
+
+// renderConflicts lists the selected default apps on the confirm screen
+func (m *Model) renderConflicts() string {
+	if len(m.conflicts) == 0 && m.conflictErr == "" {
+		return ""
+	}
+
+	var content strings.Builder
+	pending := 0
+	for _, conflict := range m.conflicts {
+		style := m.styles.warning
+		if conflict.Replace {
+			style = m.styles.err
+			pending++
+		}
+		content.WriteString(style.Render("! " + conflict.Message))
+		content.WriteString("\n")
+	}
+	if pending > 0 {
+		content.WriteString(m.styles.help.Render(fmt.Sprintf("Press %s to pick a replacement for each default app before removal",
+			m.keys.Confirm.Help().Key)))
+		content.WriteString("\n")
+	}
+	if m.conflictErr != "" {
+		content.WriteString(m.styles.err.Render(m.conflictErr))
+		content.WriteString("\n")
+		content.WriteString(m.styles.help.Render(fmt.Sprintf("Press %s to remove the selection anyway",
+			m.keys.Confirm.Help().Key)))
+		content.WriteString("\n")
+	}
+
+	return content.String() + "\n"
+}
+
+func (m *Model) renderReplace() string {
+	var content strings.Builder
+
+	content.WriteString(m.replaceList.View())
+	content.WriteString("\n")
+
+	if m.replaceStatus != "" {
+		content.WriteString(m.styles.warning.Render(m.replaceStatus))
+		content.WriteString("\n")
+	}
+
+	content.WriteString(m.renderHelp())
+
+	return content.String()
+}
@@ -0,0 +1,255 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pins are the pin choices of the detail form; the empty pin leaves the
+// selection free
+var pins = []string{"", packages.PinNever, packages.PinAlways}
+
+// Fields of the detail form
+const (
+	detailRisk = iota
+	detailPin
+	detailNotes
+	detailTags
+	detailFields
+)
+
+// detailForm edits the user override of a package
+type detailForm struct {
+	pkg    *packages.Package
+	risk   int // index into the risk choices, 0 keeps the pack risk
+	pin    int // index into pins
+	notes  textinput.Model
+	tags   textinput.Model
+	field  int
+	status string
+}
+
+// openDetail shows the highlighted package with its override
+func (m *Model) openDetail() tea.Cmd {
+	item, ok := m.list.SelectedItem().(PackageItem)
+	if !ok {
+		return nil
+	}
+	pkg := item.pkg
+
+	o := m.packageManager.GetOverride(pkg.Name)
+	if o == nil {
+		o = &packages.Override{}
+	}
+
+	notes := textinput.New()
+	notes.Prompt = ""
+	notes.Placeholder = "Why this package is kept or removed"
+	notes.CharLimit = 200
+	notes.SetValue(o.Notes)
+
+	tags := textinput.New()
+	tags.Prompt = ""
+	tags.Placeholder = "Comma separated tags"
+	tags.CharLimit = 100
+	tags.SetValue(strings.Join(o.Tags, ", "))
+
+	m.detail = detailForm{pkg: pkg, notes: notes, tags: tags}
+	for i, level := range packages.RiskLevels {
+		if o.RiskLevel == level {
+			m.detail.risk = i + 1
+		}
+	}
+	for i, pin := range pins {
+		if o.Pin == pin {
+			m.detail.pin = i
+		}
+	}
+
+	m.state = StateDetail
+	return nil
+}
+
+// handleDetailKey edits the detail form, saving the override on Confirm
+func (m *Model) handleDetailKey(msg tea.KeyMsg) tea.Cmd {
+	form := &m.detail
+
+	switch {
+	case key.Matches(msg, m.keys.Confirm):
+		m.saveOverride()
+		return nil
+	case key.Matches(msg, m.keys.Back):
+		m.state = StateList
+		return nil
+	case key.Matches(msg, m.keys.NextField):
+		return form.focus((form.field + 1) % detailFields)
+	case key.Matches(msg, m.keys.PrevField):
+		return form.focus((form.field + detailFields - 1) % detailFields)
+	}
+
+	// Choices cycle forward on Toggle or right and back on left
+	delta := 0
+	switch {
+	case key.Matches(msg, m.keys.Toggle), msg.Type == tea.KeyRight:
+		delta = 1
+	case msg.Type == tea.KeyLeft:
+		delta = -1
+	}
+
+	var cmd tea.Cmd
+	switch form.field {
+	case detailRisk:
+		form.risk = cycle(form.risk, len(packages.RiskLevels)+1, delta)
+	case detailPin:
+		form.pin = cycle(form.pin, len(pins), delta)
+	case detailNotes:
+		form.notes, cmd = form.notes.Update(msg)
+	case detailTags:
+		form.tags, cmd = form.tags.Update(msg)
+	}
+	return cmd
+}
+
+// cycle moves an index over n choices by delta, wrapping around
+func cycle(i, n, delta int) int {
+	return (i + delta + n) % n
+}
+
+// focus moves the form cursor to a field
+func (f *detailForm) focus(field int) tea.Cmd {
+	f.field = field
+	f.notes.Blur()
+	f.tags.Blur()
+	switch field {
+	case detailNotes:
+		return f.notes.Focus()
+	case detailTags:
+		return f.tags.Focus()
+	}
+	return nil
+}
+
+// override returns the override the form describes
+func (f *detailForm) override() *packages.Override {
+	o := &packages.Override{
+		Notes: strings.TrimSpace(f.notes.Value()),
+		Pin:   pins[f.pin],
+	}
+	if f.risk > 0 {
+		o.RiskLevel = packages.RiskLevels[f.risk-1]
+	}
+	for _, tag := range strings.Split(f.tags.Value(), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			o.Tags = append(o.Tags, tag)
+		}
+	}
+	return o
+}
+
+// saveOverride writes the override of the form to the user override file
+func (m *Model) saveOverride() {
+	form := &m.detail
+	if err := m.packageManager.SetOverride(form.pkg.Name, form.override()); err != nil {
+		form.status = err.Error()
+		return
+	}
+
+	m.updateList()
+	m.updateSelectedCount()
+	m.state = StateList
+}
+
+func (m *Model) renderDetail() string {
+	var content strings.Builder
+	form := m.detail
+	pkg := form.pkg
+
+	content.WriteString("\n")
+	content.WriteString(m.styles.title.Render(pkg.Name))
+	content.WriteString("\n\n")
+
+	row := func(name, value string) {
+		if value == "" {
+			value = "-"
+		}
+		content.WriteString(m.styles.helpKey.Render(fmt.Sprintf("%-12s", name)))
+		content.WriteString(m.styles.sidebarRow.Render(value))
+		content.WriteString("\n")
+	}
+	row("Description", pkg.Description)
+	row("Category", pkg.Category)
+	row("Pack", filepath.Base(pkg.Source))
+	row("Action", string(pkg.GetAction()))
+	row("Installed", fmt.Sprint(pkg.Installed))
+	row("Selected", fmt.Sprint(pkg.Selected))
+	if pkg.Score != nil {
+		row("Score", pkg.Score.String())
+		for _, reason := range pkg.Score.Reasons {
+			row("", reason)
+		}
+	}
+	content.WriteString("\n")
+
+	content.WriteString(m.styles.title.Render("Override"))
+	content.WriteString("\n\n")
+
+	label := func(field int, name string) string {
+		name = fmt.Sprintf("%-8s", name)
+		if form.field == field {
+			return m.styles.sidebarCursor.Render("> " + name)
+		}
+		return m.styles.sidebarRow.Render("  " + name)
+	}
+	choice := func(text string, chosen bool, style func(...string) string) string {
+		marker := "○ "
+		if chosen {
+			marker = "● "
+		}
+		return style(marker+text) + "  "
+	}
+	plain := m.styles.sidebarRow.Render
+
+	content.WriteString(label(detailRisk, "Risk"))
+	packRisk := pkg.PackRiskLevel()
+	if packRisk == "" {
+		packRisk = "none"
+	}
+	content.WriteString(choice("pack ("+packRisk+")", form.risk == 0, plain))
+	for i, level := range packages.RiskLevels {
+		content.WriteString(choice(level, form.risk == i+1, m.styles.risk(level).Render))
+	}
+	content.WriteString("\n")
+
+	content.WriteString(label(detailPin, "Pin"))
+	for i, pin := range pins {
+		text := "none"
+		if pin != "" {
+			text = pin + "-remove"
+		}
+		content.WriteString(choice(text, form.pin == i, plain))
+	}
+	content.WriteString("\n")
+
+	content.WriteString(label(detailNotes, "Notes"))
+	content.WriteString(form.notes.View())
+	content.WriteString("\n")
+	content.WriteString(label(detailTags, "Tags"))
+	content.WriteString(form.tags.View())
+	content.WriteString("\n\n")
+
+	if form.status != "" {
+		content.WriteString(m.styles.err.Render(form.status))
+		content.WriteString("\n\n")
+	}
+
+	content.WriteString(m.renderHelp())
+	content.WriteString("\n")
+
+	return content.String()
+}
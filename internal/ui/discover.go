@@ -0,0 +1,425 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DiscoverItem represents a device package no loaded pack covers
+type DiscoverItem struct {
+	found  engine.Discovered
+	info   map[string]string // live metadata, nil until fetched
+	marked bool
+}
+
+func (d DiscoverItem) Title() string {
+	if d.marked {
+		return "● " + d.found.Name
+	}
+	return "○ " + d.found.Name
+}
+
+func (d DiscoverItem) Description() string {
+	parts := []string{"third-party"}
+	if d.found.System {
+		parts[0] = "system"
+	}
+	if score := d.found.Score; score != nil {
+		parts = append(parts, "score "+score.String())
+	}
+
+	if version := d.info["versionName"]; version != "" {
+		if code := d.info["versionCode"]; code != "" {
+			version += fmt.Sprintf(" (%s)", code)
+		}
+		parts = append(parts, "v"+version)
+	}
+
+	if rec := d.found.Record; rec != nil {
+		if !rec.Installed {
+			parts = append(parts, "not installed")
+		} else if !rec.Enabled {
+			parts = append(parts, "disabled")
+		}
+		if rec.Installer != "" {
+			parts = append(parts, "installer: "+rec.Installer)
+		}
+		if rec.Path != "" {
+			parts = append(parts, rec.Path)
+		}
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+func (d DiscoverItem) FilterValue() string {
+	return d.found.Name
+}
+
+// promoteForm collects the description, category and risk of a package
+// promoted into the user pack
+type promoteForm struct {
+	item        DiscoverItem
+	description textinput.Model
+	category    textinput.Model
+	risk        int // index into packages.RiskLevels
+	field       int // 0 description, 1 category, 2 risk
+	status      string
+}
+
+// Messages
+type discoverMsg struct {
+	found *engine.Discovery
+	err   error
+}
+type adoptedMsg struct{}
+type promotedMsg struct {
+	pkg *packages.Package
+	err error
+}
+type discoverInfoMsg struct {
+	name string
+	info map[string]string
+}
+
+// SetUserPack sets the pack file packages are promoted into
+func (m *Model) SetUserPack(filename string) {
+	m.userPack = filename
+}
+
+// openDiscover switches to the discover screen and scans the device for
+// packages no pack covers
+func (m *Model) openDiscover() tea.Cmd {
+	m.discoverStatus = "Scanning device packages..."
+	m.discoverList.SetItems(nil)
+	m.state = StateDiscover
+
+	return m.background(func() tea.Msg {
+		found, err := m.engine.Discover()
+		return discoverMsg{found: found, err: err}
+	})
+}
+
+// showDiscovered lists the packages found by a scan
+func (m *Model) showDiscovered(msg discoverMsg) tea.Cmd {
+	if msg.err != nil {
+		m.discoverStatus = msg.err.Error()
+		return nil
+	}
+
+	items := make([]list.Item, len(msg.found.Packages))
+	for i, found := range msg.found.Packages {
+		items[i] = DiscoverItem{found: found, info: m.discoverInfo[found.Name]}
+	}
+	m.discoverList.SetItems(items)
+	m.discoverList.ResetSelected()
+	m.updateDiscoverTitle()
+
+	m.discoverStatus = ""
+	if len(items) == 0 {
+		m.discoverStatus = "Every package on the device is covered by a loaded pack"
+	} else if msg.found.Unscored != "" {
+		m.discoverStatus = "Packages scored as unrated apps, the device facts could not be read: " + msg.found.Unscored
+	}
+	return m.fetchInfo()
+}
+
+// updateDiscoverTitle shows the number of discovered packages in the title
+func (m *Model) updateDiscoverTitle() {
+	m.discoverList.Title = fmt.Sprintf("Discovered Packages (%d not in any pack)", len(m.discoverList.Items()))
+}
+
+// fetchInfo asks the device for the metadata of the highlighted package,
+// unless it was asked already
+func (m *Model) fetchInfo() tea.Cmd {
+	item, ok := m.discoverList.SelectedItem().(DiscoverItem)
+	if !ok {
+		return nil
+	}
+	name := item.found.Name
+	if _, asked := m.discoverInfo[name]; asked {
+		return nil
+	}
+	m.discoverInfo[name] = nil
+
+	return m.background(func() tea.Msg {
+		info, err := m.engine.PackageInfo(name)
+		if err != nil {
+			info = map[string]string{}
+		}
+		return discoverInfoMsg{name: name, info: info}
+	})
+}
+
+// showInfo fills in the metadata of a discovered package
+func (m *Model) showInfo(msg discoverInfoMsg) {
+	m.discoverInfo[msg.name] = msg.info
+	for i, listed := range m.discoverList.Items() {
+		if item, ok := listed.(DiscoverItem); ok && item.found.Name == msg.name {
+			item.info = msg.info
+			m.discoverList.SetItem(i, item)
+			return
+		}
+	}
+}
+
+// handleDiscoverKey marks discovered packages, adopts them into the list
+// or opens the promote form
+func (m *Model) handleDiscoverKey(msg tea.KeyMsg) (bool, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Toggle):
+		if item, ok := m.discoverList.SelectedItem().(DiscoverItem); ok {
+			item.marked = !item.marked
+			m.discoverList.SetItem(m.discoverList.Index(), item)
+		}
+	case key.Matches(msg, m.keys.Confirm):
+		return true, m.adoptMarked()
+	case key.Matches(msg, m.keys.Promote):
+		if item, ok := m.discoverList.SelectedItem().(DiscoverItem); ok {
+			m.openPromote(item)
+			return true, textinput.Blink
+		}
+	case key.Matches(msg, m.keys.Back):
+		m.discoverStatus = ""
+		m.state = StateList
+	case key.Matches(msg, m.keys.Quit):
+		return true, tea.Quit
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// adoptMarked adds the marked packages to the list in the background,
+// selected for removal
+func (m *Model) adoptMarked() tea.Cmd {
+	var names []string
+	for _, listed := range m.discoverList.Items() {
+		if item, ok := listed.(DiscoverItem); ok && item.marked {
+			names = append(names, item.found.Name)
+		}
+	}
+	if len(names) == 0 {
+		m.discoverStatus = fmt.Sprintf("Nothing marked: press %s to mark packages", m.keys.Toggle.Help().Key)
+		return nil
+	}
+
+	m.discoverStatus = fmt.Sprintf("Adding %d packages...", len(names))
+	return m.background(func() tea.Msg {
+		m.engine.Adopt(names)
+		return adoptedMsg{}
+	})
+}
+
+// showAdopted returns to the list once the marked packages are on it
+func (m *Model) showAdopted() {
+	m.refreshPackages()
+	m.discoverStatus = ""
+	m.state = StateList
+}
+
+// refreshPackages picks up packages added to the manager
+func (m *Model) refreshPackages() {
+	m.packages = m.packageManager.GetPackages()
+	m.updateList()
+	m.updateSelectedCount()
+}
+
+// openPromote opens the form promoting a discovered package into the user
+// pack
+func (m *Model) openPromote(item DiscoverItem) {
+	description := textinput.New()
+	description.Prompt = ""
+	description.Placeholder = "What the package does"
+	description.CharLimit = 120
+	description.Focus()
+
+	category := textinput.New()
+	category.Prompt = ""
+	category.Placeholder = "Category"
+	category.CharLimit = 40
+
+	m.promote = promoteForm{
+		item:        item,
+		description: description,
+		category:    category,
+	}
+	// The score suggests the risk to rate the package with
+	if score := item.found.Score; score != nil {
+		for i, level := range packages.RiskLevels {
+			if level == score.Level {
+				m.promote.risk = i
+			}
+		}
+	}
+	m.state = StatePromote
+}
+
+// handlePromoteKey edits the promote form, saving it on Confirm
+func (m *Model) handlePromoteKey(msg tea.KeyMsg) tea.Cmd {
+	form := &m.promote
+
+	switch {
+	case key.Matches(msg, m.keys.Confirm):
+		return m.savePromoted()
+	case key.Matches(msg, m.keys.Back):
+		m.state = StateDiscover
+		return nil
+	case key.Matches(msg, m.keys.NextField):
+		form.focus((form.field + 1) % 3)
+		return nil
+	case key.Matches(msg, m.keys.PrevField):
+		form.focus((form.field + 2) % 3)
+		return nil
+	}
+
+	var cmd tea.Cmd
+	switch form.field {
+	case 0:
+		form.description, cmd = form.description.Update(msg)
+	case 1:
+		form.category, cmd = form.category.Update(msg)
+	case 2:
+		switch {
+		case key.Matches(msg, m.keys.Toggle), msg.Type == tea.KeyRight:
+			form.risk = cycle(form.risk, len(packages.RiskLevels), 1)
+		case msg.Type == tea.KeyLeft:
+			form.risk = cycle(form.risk, len(packages.RiskLevels), -1)
+		}
+	}
+	return cmd
+}
+
+// focus moves the form cursor to a field
+func (f *promoteForm) focus(field int) {
+	f.field = field
+	f.description.Blur()
+	f.category.Blur()
+	switch field {
+	case 0:
+		f.description.Focus()
+	case 1:
+		f.category.Focus()
+	}
+}
+
+// savePromoted writes the promoted package to the user pack in the
+// background
+func (m *Model) savePromoted() tea.Cmd {
+	form := &m.promote
+	if m.userPack == "" {
+		form.status = "No user pack configured: set userPack in the config"
+		return nil
+	}
+
+	pkg := &packages.Package{
+		Name:        form.item.found.Name,
+		Description: strings.TrimSpace(form.description.Value()),
+		Category:    strings.TrimSpace(form.category.Value()),
+		RiskLevel:   packages.RiskLevels[form.risk],
+		Selected:    form.item.marked,
+	}
+	userPack := m.userPack
+	form.status = "Saving..."
+	return m.background(func() tea.Msg {
+		return promotedMsg{pkg: pkg, err: m.engine.Promote(userPack, pkg)}
+	})
+}
+
+// showPromoted lists the promoted package once it is saved
+func (m *Model) showPromoted(msg promotedMsg) {
+	if msg.err != nil {
+		m.promote.status = msg.err.Error()
+		return
+	}
+
+	// The package is covered by a pack now
+	for i, listed := range m.discoverList.Items() {
+		if item, ok := listed.(DiscoverItem); ok && item.found.Name == msg.pkg.Name {
+			m.discoverList.RemoveItem(i)
+			break
+		}
+	}
+	m.updateDiscoverTitle()
+
+	m.refreshPackages()
+	m.discoverStatus = fmt.Sprintf("Added %s to %s", msg.pkg.Name, filepath.Base(m.userPack))
+	m.state = StateDiscover
+}
+
+func (m *Model) renderDiscover() string {
+	var content strings.Builder
+
+	content.WriteString(m.discoverList.View())
+	content.WriteString("\n")
+
+	if m.discoverStatus != "" {
+		content.WriteString(m.styles.warning.Render(m.discoverStatus))
+		content.WriteString("\n")
+	}
+
+	content.WriteString(m.renderHelp())
+
+	return content.String()
+}
+
+func (m *Model) renderPromote() string {
+	var content strings.Builder
+	form := m.promote
+
+	content.WriteString("\n")
+	content.WriteString(m.styles.title.Render("Promote " + form.item.found.Name))
+	content.WriteString("\n\n")
+	content.WriteString(m.styles.help.Render(form.item.Description()))
+	content.WriteString("\n\n")
+
+	label := func(field int, name string) string {
+		if form.field == field {
+			return m.styles.sidebarCursor.Render("> " + name)
+		}
+		return m.styles.sidebarRow.Render("  " + name)
+	}
+
+	content.WriteString(label(0, "Description: "))
+	content.WriteString(form.description.View())
+	content.WriteString("\n")
+	content.WriteString(label(1, "Category:    "))
+	content.WriteString(form.category.View())
+	content.WriteString("\n")
+	content.WriteString(label(2, "Risk:        "))
+	for i, level := range packages.RiskLevels {
+		marker := "○ "
+		if i == form.risk {
+			marker = "● "
+		}
+		content.WriteString(m.styles.risk(level).Render(marker + level))
+		content.WriteString("  ")
+	}
+	content.WriteString("\n\n")
+
+	target := m.userPack
+	if target == "" {
+		target = "no user pack configured"
+	}
+	content.WriteString(m.styles.info.Render("Saved to " + target))
+	content.WriteString("\n")
+
+	if form.status != "" {
+		content.WriteString(m.styles.err.Render(form.status))
+		content.WriteString("\n")
+	}
+
+	content.WriteString("\n")
+	content.WriteString(m.renderHelp())
+	content.WriteString("\n")
+
+	return content.String()
+}
@@ -0,0 +1,250 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/backup"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// RunItem represents a past run in the history list
+type RunItem struct {
+	archive *backup.Archive
+}
+
+func (r RunItem) Title() string {
+	manifest := r.archive.Manifest
+	title := fmt.Sprintf("%s  %s %s",
+		manifest.CreatedAt.Format("2006-01-02 15:04:05"),
+		manifest.Device.Manufacturer, manifest.Device.Model)
+	if manifest.DryRun {
+		title += " [DRY-RUN]"
+	}
+	return title
+}
+
+func (r RunItem) Description() string {
+	counts := r.archive.Manifest.Counts()
+
+	var parts []string
+	for _, result := range []string{backup.ResultSuccess, backup.ResultFailed, backup.ResultSkipped, backup.ResultDryRun, backup.ResultSelected} {
+		if counts[result] > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", result, counts[result]))
+		}
+	}
+
+	var packs []string
+	for _, pack := range r.archive.Manifest.Packs {
+		packs = append(packs, pack.Path)
+	}
+	if len(packs) > 0 {
+		parts = append(parts, "packs: "+strings.Join(packs, ", "))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+func (r RunItem) FilterValue() string {
+	return r.archive.Path
+}
+
+// ResultItem represents a package result of a past run
+type ResultItem struct {
+	result backup.PackageResult
+}
+
+func (r ResultItem) Title() string {
+	return r.result.Package
+}
+
+func (r ResultItem) Description() string {
+	desc := fmt.Sprintf("[%s] %s", strings.ToUpper(r.result.Result), r.result.Action)
+	if r.result.APK != nil {
+		desc += fmt.Sprintf(" | APK %s", r.result.APK.VersionName)
+	}
+	if r.result.Error != "" {
+		desc += " | " + r.result.Error
+	}
+	return desc
+}
+
+func (r ResultItem) FilterValue() string {
+	return r.result.Package
+}
+
+// Messages
+type restoreDoneMsg struct {
+	restored int
+	failed   int
+}
+
+// newHistoryList creates a list for the history screens
+func newHistoryList(title string) list.Model {
+	l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	l.Title = title
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	return l
+}
+
+// openHistory switches to the history screen
+func (m *Model) openHistory() {
+	m.historyStatus = ""
+	m.loadHistory()
+	m.state = StateHistory
+}
+
+// loadHistory reads the backup archives and lists the runs for the current
+// device, or for every device when showAllRuns is set
+func (m *Model) loadHistory() {
+	archives, err := backup.ListArchives(m.backupDir)
+	if err != nil {
+		m.historyStatus = err.Error()
+	}
+
+	var items []list.Item
+	for _, archive := range archives {
+		if m.showAllRuns || archive.Manifest.Device.Matches(m.device) {
+			items = append(items, RunItem{archive: archive})
+		}
+	}
+
+	if m.showAllRuns {
+		m.historyList.Title = "Run History (all devices)"
+	} else {
+		m.historyList.Title = fmt.Sprintf("Run History (%s %s)", m.device.Manufacturer, m.device.Model)
+	}
+	m.historyList.SetItems(items)
+}
+
+// openRun shows the per-package results of the highlighted run
+func (m *Model) openRun() {
+	item, ok := m.historyList.SelectedItem().(RunItem)
+	if !ok {
+		return
+	}
+
+	m.historyRun = item.archive
+	m.historyStatus = ""
+
+	results := append([]backup.PackageResult(nil), item.archive.Manifest.Packages...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Result < results[j].Result
+	})
+
+	items := make([]list.Item, len(results))
+	for i, res := range results {
+		items[i] = ResultItem{result: res}
+	}
+	m.resultList.Title = RunItem{archive: item.archive}.Title()
+	m.resultList.SetItems(items)
+	m.resultList.ResetSelected()
+	m.state = StateHistoryDetail
+}
+
+// restoreRun reinstalls every package the open run removed
+func (m *Model) restoreRun() tea.Cmd {
+	archive := m.historyRun
+	if archive == nil {
+		return nil
+	}
+
+	// Archives of another device are restored once asked twice
+	force := m.forceRestore == archive.Path
+	if err := archive.CheckDevice(m.device, force); err != nil {
+		m.forceRestore = archive.Path
+		m.historyStatus = fmt.Sprintf("%v: press %s again to restore onto this device anyway", err, m.keys.Restore.Help().Key)
+		return nil
+	}
+	m.forceRestore = ""
+
+	pkgs := archive.RemovedPackages()
+	if len(pkgs) == 0 {
+		m.historyStatus = "Nothing to restore: this run removed no packages"
+		return nil
+	}
+
+	m.historyStatus = fmt.Sprintf("Restoring %d packages...", len(pkgs))
+	slog.Info("restore started", "archive", archive.Path, "packages", len(pkgs))
+
+	lines := make(chan string, logBuffer)
+	restore := m.background(func() tea.Msg {
+		defer close(lines)
+
+		summary, err := m.engine.Restore(archive, force, func(ev engine.Event) {
+			logEvent(ev)
+			if ev.Type == engine.EventRestore {
+				slog.Info("package restored", "package", ev.Package, "result", ev.Result, "error", ev.Message)
+			}
+			if ev.Type != engine.EventStarted && ev.Type != engine.EventFinished {
+				lines <- ev.String()
+			}
+		})
+		if err != nil {
+			slog.Error("restore failed", "archive", archive.Path, "error", err)
+			return restoreDoneMsg{failed: len(pkgs)}
+		}
+
+		return restoreDoneMsg{restored: summary.Success, failed: summary.Failed}
+	})
+	return tea.Batch(restore, waitLog(lines))
+}
+
+// reapplyRun selects the packages the open run removed, with the same
+// actions, and moves to the confirm screen, so the run can be repeated on
+// the current device
+func (m *Model) reapplyRun() tea.Cmd {
+	if m.historyRun == nil {
+		return nil
+	}
+
+	m.packageManager.DeselectAll()
+	unmatched, err := m.packageManager.LoadBackup(m.historyRun.Path, m.device, true)
+	if err != nil {
+		m.historyStatus = err.Error()
+		return nil
+	}
+
+	m.updateList()
+	m.updateSelectedCount()
+	cmd := m.openConfirm()
+	m.unmatched = unmatched
+	return cmd
+}
+
+func (m *Model) renderHistory() string {
+	var content strings.Builder
+
+	content.WriteString(m.historyList.View())
+	content.WriteString("\n")
+
+	if m.historyStatus != "" {
+		content.WriteString(m.styles.warning.Render(m.historyStatus))
+		content.WriteString("\n")
+	}
+
+	content.WriteString(m.renderHelp())
+
+	return content.String()
+}
+
+func (m *Model) renderRunDetail() string {
+	var content strings.Builder
+
+	content.WriteString(m.resultList.View())
+	content.WriteString("\n")
+
+	if m.historyStatus != "" {
+		content.WriteString(m.styles.warning.Render(m.historyStatus))
+		content.WriteString("\n")
+	}
+
+	content.WriteString(m.renderHelp())
+
+	return content.String()
+}
@@ -0,0 +1,154 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxImpacts is the number of impacts the confirm screen lists
+const maxImpacts = 8
+
+// Messages
+type checkedMsg struct {
+	id          int
+	include     bool
+	impacts     []packages.Impact
+	impactErr   error
+	conflicts   []engine.DefaultConflict
+	conflictErr error
+}
+
+// SetIncludeRelated configures whether the packages the selection leaves
+// useless are included whenever the confirm screen opens
+func (m *Model) SetIncludeRelated(include bool) {
+	m.includeRelated = include
+}
+
+// openConfirm switches to the confirm screen, checks which packages the
+// selection affects and estimates what removing it frees
+func (m *Model) openConfirm() tea.Cmd {
+	m.planStatus = ""
+	m.unmatched = nil
+	m.state = StateConfirm
+
+	return m.checkSelection(m.includeRelated)
+}
+
+// checkSelection looks up in the background the default apps and the
+// packages the selection affects, then estimates the savings. With include,
+// the related packages are selected once the impacts are known and the
+// selection is checked again.
+func (m *Model) checkSelection(include bool) tea.Cmd {
+	check := m.startCheck(include)
+	if include {
+		return check
+	}
+	return tea.Sequence(check, m.estimateSavings())
+}
+
+// startCheck marks the confirm screen as checking and returns the lookup
+// of the selection
+func (m *Model) startCheck(include bool) tea.Cmd {
+	m.checkID++
+	id := m.checkID
+	m.checking = true
+	m.impacts = nil
+	m.impactErr = ""
+	m.conflicts = nil
+	m.conflictErr = ""
+
+	return m.background(func() tea.Msg {
+		msg := checkedMsg{id: id, include: include}
+		msg.impacts, msg.impactErr = m.engine.Impacts()
+		msg.conflicts, msg.conflictErr = m.engine.DefaultConflicts()
+		return msg
+	})
+}
+
+// showChecked shows the outcome of the last check on the confirm screen;
+// checks a newer one superseded are dropped
+func (m *Model) showChecked(msg checkedMsg) tea.Cmd {
+	if msg.id != m.checkID {
+		return nil
+	}
+	m.checking = false
+
+	m.impacts = msg.impacts
+	if msg.impactErr != nil {
+		m.impactErr = fmt.Sprintf("Only the relations declared by the packs are checked: %v", msg.impactErr)
+	}
+	m.conflicts = msg.conflicts
+	if msg.conflictErr != nil {
+		m.conflictErr = fmt.Sprintf("Default apps are not checked: %v", msg.conflictErr)
+	}
+
+	if !msg.include {
+		return nil
+	}
+	included := m.engine.IncludeRelated(msg.impacts)
+	if len(included) == 0 {
+		m.planStatus = "No related packages to include"
+		return m.estimateSavings()
+	}
+	m.refreshPackages()
+	m.planStatus = fmt.Sprintf("Included %d related packages: %s", len(included), strings.Join(included, ", "))
+	return m.checkSelection(false)
+}
+
+// renderImpacts lists the packages the selection affects, marking the ones
+// that can go with it
+func (m *Model) renderImpacts() string {
+	if len(m.impacts) == 0 && m.impactErr == "" {
+		return ""
+	}
+
+	var content strings.Builder
+	includable := 0
+	for i, impact := range m.impacts {
+		if impact.Include {
+			includable++
+		}
+		if i >= maxImpacts {
+			continue
+		}
+
+		line := "! " + impact.Message
+		if impact.Include {
+			line = "+ " + impact.Message
+		}
+		if impact.Inferred {
+			line += " (device)"
+		}
+		content.WriteString(m.impactStyle(impact).Render(line))
+		content.WriteString("\n")
+	}
+	if len(m.impacts) > maxImpacts {
+		content.WriteString(m.styles.help.Render(fmt.Sprintf("… and %d more", len(m.impacts)-maxImpacts)))
+		content.WriteString("\n")
+	}
+
+	if includable > 0 {
+		content.WriteString(m.styles.help.Render(fmt.Sprintf("Press %s to also remove the %d packages marked +",
+			m.keys.IncludeRelated.Help().Key, includable)))
+		content.WriteString("\n")
+	}
+	if m.impactErr != "" {
+		content.WriteString(m.styles.err.Render(m.impactErr))
+		content.WriteString("\n")
+	}
+
+	return content.String() + "\n"
+}
+
+// impactStyle returns the style of an impact; broken packages stand out
+func (m *Model) impactStyle(impact packages.Impact) lipgloss.Style {
+	if impact.Kind == packages.RelationBreaks || impact.Kind == packages.RelationRequires {
+		return m.styles.err
+	}
+	return m.styles.warning
+}
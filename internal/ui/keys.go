@@ -0,0 +1,291 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// keyMap holds the key bindings of every screen
+type keyMap struct {
+	Up              key.Binding
+	Down            key.Binding
+	Toggle          key.Binding
+	SelectAll       key.Binding
+	DeselectAll     key.Binding
+	SelectInstalled key.Binding
+	SelectSafe      key.Binding
+	Search          key.Binding
+	Filters         key.Binding
+	ClearFilters    key.Binding
+	History         key.Binding
+	Discover        key.Binding
+	Details         key.Binding
+	Confirm         key.Binding
+	Back            key.Binding
+	Quit            key.Binding
+	Help            key.Binding
+
+	// Confirm screen
+	DryRun         key.Binding
+	BackupAPKs     key.Binding
+	SavePlan       key.Binding
+	IncludeRelated key.Binding
+
+	// History screens
+	AllRuns key.Binding
+	Restore key.Binding
+	Reapply key.Binding
+
+	// Discover screens
+	Promote   key.Binding
+	NextField key.Binding
+	PrevField key.Binding
+}
+
+// newKeyMap returns the default bindings. Function keys are kept next to
+// the letter keys for terminals that send them.
+func newKeyMap() keyMap {
+	return keyMap{
+		Up:              binding("move up", "up", "k"),
+		Down:            binding("move down", "down", "j"),
+		Toggle:          binding("toggle", " "),
+		SelectAll:       binding("select visible", "a", "f1"),
+		DeselectAll:     binding("deselect visible", "A", "f2"),
+		SelectInstalled: binding("select installed", "i", "f3"),
+		SelectSafe:      binding("select safe", "s", "f4"),
+		Search:          binding("search", "/", "f5"),
+		Filters:         binding("filters", "tab", "f"),
+		ClearFilters:    binding("clear filters", "c"),
+		History:         binding("history", "h", "f6"),
+		Discover:        binding("discover", "d", "f7"),
+		Details:         binding("details", "e", "f8"),
+		Confirm:         binding("confirm", "enter"),
+		Back:            binding("back", "esc"),
+		Quit:            binding("quit", "q"),
+		Help:            binding("help", "?"),
+		DryRun:          binding("toggle dry run", "tab", "d"),
+		BackupAPKs:      binding("toggle APK backup", "b"),
+		SavePlan:        binding("save plan", "p"),
+		IncludeRelated:  binding("include related", "r"),
+		AllRuns:         binding("toggle all devices", "tab"),
+		Restore:         binding("restore everything", "r"),
+		Reapply:         binding("re-apply run", "a"),
+		Promote:         binding("promote to user pack", "p"),
+		NextField:       binding("next field", "tab", "down"),
+		PrevField:       binding("previous field", "shift+tab", "up"),
+	}
+}
+
+func binding(desc string, keys ...string) key.Binding {
+	return key.NewBinding(key.WithKeys(keys...), key.WithHelp(keyHelp(keys), desc))
+}
+
+// keyHelp names keys the way the help shows them
+func keyHelp(keys []string) string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		switch k {
+		case " ":
+			names[i] = "space"
+		case "up":
+			names[i] = "↑"
+		case "down":
+			names[i] = "↓"
+		default:
+			names[i] = k
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// actions maps the names used in the keys config setting to the bindings
+func (k *keyMap) actions() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":              &k.Up,
+		"down":            &k.Down,
+		"toggle":          &k.Toggle,
+		"selectAll":       &k.SelectAll,
+		"deselectAll":     &k.DeselectAll,
+		"selectInstalled": &k.SelectInstalled,
+		"selectSafe":      &k.SelectSafe,
+		"search":          &k.Search,
+		"filters":         &k.Filters,
+		"clearFilters":    &k.ClearFilters,
+		"history":         &k.History,
+		"discover":        &k.Discover,
+		"details":         &k.Details,
+		"confirm":         &k.Confirm,
+		"back":            &k.Back,
+		"quit":            &k.Quit,
+		"help":            &k.Help,
+		"dryRun":          &k.DryRun,
+		"backupApks":      &k.BackupAPKs,
+		"savePlan":        &k.SavePlan,
+		"includeRelated":  &k.IncludeRelated,
+		"allRuns":         &k.AllRuns,
+		"restore":         &k.Restore,
+		"reapply":         &k.Reapply,
+		"promote":         &k.Promote,
+		"nextField":       &k.NextField,
+		"prevField":       &k.PrevField,
+	}
+}
+
+// remap replaces the keys of the named actions. "space" stands for the
+// space bar.
+func (k *keyMap) remap(keys map[string][]string) error {
+	actions := k.actions()
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := actions[name]; !ok {
+			return fmt.Errorf("keys.%s: unknown action", name)
+		}
+		if len(keys[name]) == 0 {
+			return fmt.Errorf("keys.%s: no keys given", name)
+		}
+	}
+
+	for _, name := range names {
+		b := actions[name]
+		bound := make([]string, len(keys[name]))
+		for i, key := range keys[name] {
+			if key == "space" {
+				key = " "
+			}
+			bound[i] = key
+		}
+		*b = binding(b.Help().Desc, bound...)
+	}
+
+	return nil
+}
+
+// list returns the bindings of a list, navigated with the Up and Down keys.
+// Keys the screens use for their own actions are left out.
+func (k keyMap) list() list.KeyMap {
+	keys := list.DefaultKeyMap()
+	keys.CursorUp = k.Up
+	keys.CursorDown = k.Down
+	keys.PrevPage = binding("prev page", "left", "pgup")
+	keys.NextPage = binding("next page", "right", "pgdown")
+	keys.Filter.SetEnabled(false)
+	keys.Quit.SetEnabled(false)
+	keys.ShowFullHelp.SetEnabled(false)
+	keys.CloseFullHelp.SetEnabled(false)
+	return keys
+}
+
+// stateKeys are the bindings of one screen, for the help
+type stateKeys struct {
+	short []key.Binding
+	full  [][]key.Binding
+}
+
+func (s stateKeys) ShortHelp() []key.Binding {
+	return s.short
+}
+
+func (s stateKeys) FullHelp() [][]key.Binding {
+	return s.full
+}
+
+// forSidebar returns the bindings active while the sidebar has the focus
+func (k keyMap) forSidebar() stateKeys {
+	return stateKeys{
+		short: []key.Binding{k.Toggle, k.ClearFilters, k.Filters, k.Help},
+		full: [][]key.Binding{
+			{k.Up, k.Down, k.Toggle, k.Confirm},
+			{k.ClearFilters, k.Filters, k.Back},
+			{k.Help, k.Quit},
+		},
+	}
+}
+
+// forState returns the bindings active in a state
+func (k keyMap) forState(state AppState) stateKeys {
+	switch state {
+	case StateList:
+		return stateKeys{
+			short: []key.Binding{k.Toggle, k.SelectAll, k.Search, k.Filters, k.Confirm, k.Help, k.Quit},
+			full: [][]key.Binding{
+				{k.Up, k.Down, k.Toggle},
+				{k.SelectAll, k.DeselectAll, k.SelectInstalled, k.SelectSafe},
+				{k.Search, k.Filters, k.ClearFilters},
+				{k.Details, k.History, k.Discover, k.Confirm},
+				{k.Help, k.Quit},
+			},
+		}
+	case StateConfirm:
+		return stateKeys{
+			short: []key.Binding{k.DryRun, k.BackupAPKs, k.SavePlan, k.IncludeRelated, k.Confirm, k.Back},
+			full: [][]key.Binding{
+				{k.DryRun, k.BackupAPKs, k.SavePlan, k.IncludeRelated},
+				{k.Confirm, k.Back, k.Help},
+			},
+		}
+	case StateSearch:
+		return stateKeys{
+			short: []key.Binding{k.Confirm, k.Back},
+			full:  [][]key.Binding{{k.Confirm, k.Back}},
+		}
+	case StateDone:
+		return stateKeys{
+			short: []key.Binding{k.Confirm, k.Quit},
+			full:  [][]key.Binding{{k.Confirm, k.Quit}},
+		}
+	case StateHistory:
+		return stateKeys{
+			short: []key.Binding{k.Confirm, k.AllRuns, k.Back, k.Help},
+			full: [][]key.Binding{
+				{k.Up, k.Down, k.Confirm},
+				{k.AllRuns, k.Back, k.Help, k.Quit},
+			},
+		}
+	case StateHistoryDetail:
+		return stateKeys{
+			short: []key.Binding{k.Restore, k.Reapply, k.Back, k.Help},
+			full: [][]key.Binding{
+				{k.Up, k.Down},
+				{k.Restore, k.Reapply, k.Back, k.Help},
+			},
+		}
+	case StateDiscover:
+		return stateKeys{
+			short: []key.Binding{k.Toggle, k.Confirm, k.Promote, k.Back, k.Help},
+			full: [][]key.Binding{
+				{k.Up, k.Down, k.Toggle},
+				{k.Confirm, k.Promote, k.Back},
+				{k.Help, k.Quit},
+			},
+		}
+	case StateDetail:
+		return stateKeys{
+			short: []key.Binding{k.NextField, k.Toggle, k.Confirm, k.Back},
+			full:  [][]key.Binding{{k.NextField, k.PrevField, k.Toggle}, {k.Confirm, k.Back}},
+		}
+	case StateReplace:
+		return stateKeys{
+			short: []key.Binding{k.Confirm, k.Back, k.Help},
+			full: [][]key.Binding{
+				{k.Up, k.Down, k.Confirm},
+				{k.Back, k.Help, k.Quit},
+			},
+		}
+	case StatePromote:
+		return stateKeys{
+			short: []key.Binding{k.NextField, k.PrevField, k.Confirm, k.Back},
+			full:  [][]key.Binding{{k.NextField, k.PrevField, k.Toggle}, {k.Confirm, k.Back}},
+		}
+	}
+	return stateKeys{}
+}
@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/adb"
+	"github.com/adb-cleaner/adb-cleaner/internal/engine"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Messages
+type savingsMsg struct {
+	savings *engine.Savings
+	err     error
+}
+
+// estimateSavings estimates in the background what removing the selection
+// frees
+func (m *Model) estimateSavings() tea.Cmd {
+	m.savings = nil
+	m.savingsStatus = "Estimating savings..."
+
+	return m.background(func() tea.Msg {
+		savings, err := m.engine.EstimateSavings()
+		return savingsMsg{savings: savings, err: err}
+	})
+}
+
+// showSavings shows the estimate on the confirm screen
+func (m *Model) showSavings(msg savingsMsg) {
+	m.savingsStatus = ""
+	if msg.err != nil {
+		m.savingsStatus = fmt.Sprintf("Savings are not estimated: %v", msg.err)
+		return
+	}
+	m.savings = msg.savings
+}
+
+// renderSavings describes the estimated savings on the confirm screen
+func (m *Model) renderSavings() string {
+	if m.savingsStatus != "" {
+		return m.styles.help.Render(m.savingsStatus) + "\n\n"
+	}
+	s := m.savings
+	if s == nil {
+		return ""
+	}
+
+	var content strings.Builder
+	content.WriteString(m.styles.info.Render(fmt.Sprintf("Estimated savings: %s storage, %s memory (%d running)",
+		adb.FormatBytes(s.Storage), adb.FormatBytes(s.Memory), s.Running)))
+	content.WriteString("\n")
+	if s.Unsized > 0 {
+		content.WriteString(m.styles.help.Render(fmt.Sprintf("%d of %d installed packages could not be sized", s.Unsized, s.Packages)))
+		content.WriteString("\n")
+	}
+	return content.String() + "\n"
+}
+
+// renderMeasured compares what the run freed with the estimate
+func (m *Model) renderMeasured() string {
+	measured := m.measured
+	if measured == nil {
+		return ""
+	}
+
+	line := func(name string, freed int64, estimate func(*engine.Savings) int64) string {
+		verb := "freed"
+		if m.doneDryRun {
+			verb = "would be freed"
+		}
+		text := fmt.Sprintf("%s %s: %s", name, verb, adb.FormatBytes(freed))
+		if m.savings != nil {
+			text += fmt.Sprintf(" (estimated %s)", adb.FormatBytes(estimate(m.savings)))
+		}
+		return m.styles.info.Render(text) + "\n"
+	}
+
+	return line("Storage", measured.StorageFreed(), func(s *engine.Savings) int64 { return s.Storage }) +
+		line("Memory", measured.MemoryFreed(), func(s *engine.Savings) int64 { return s.Memory }) + "\n"
+}
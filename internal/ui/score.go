@@ -0,0 +1,34 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Messages
+type scoreMsg struct {
+	scorer *packages.Scorer
+	err    error
+}
+
+// scorePackages reads the device facts the scores are computed from in the
+// background
+func (m *Model) scorePackages() tea.Cmd {
+	return m.background(func() tea.Msg {
+		scorer, err := m.engine.Scorer()
+		return scoreMsg{scorer: scorer, err: err}
+	})
+}
+
+// applyScores scores the listed packages once the device facts are read
+func (m *Model) applyScores(msg scoreMsg) {
+	if msg.err != nil {
+		m.listStatus = fmt.Sprintf("Packages are not scored: %v", msg.err)
+		return
+	}
+
+	m.packageManager.ApplyScores(msg.scorer)
+	m.updateList()
+}
@@ -0,0 +1,189 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/packages"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/truncate"
+)
+
+// sidebarWidth is the width of the filter sidebar, borders included
+const sidebarWidth = 32
+
+// facet is a sidebar section narrowing one field of the filter
+type facet struct {
+	title   string
+	options func(m *packages.Manager) []string
+	label   func(value string) string
+	style   func(st *styles, value string) lipgloss.Style
+	get     func(f packages.Filter) string
+	set     func(f *packages.Filter, value string)
+}
+
+var facets = []facet{
+	{
+		title:   "Category",
+		options: (*packages.Manager).GetCategories,
+		get:     func(f packages.Filter) string { return f.Category },
+		set:     func(f *packages.Filter, v string) { f.Category = v },
+	},
+	{
+		title:   "Risk",
+		options: (*packages.Manager).GetRiskLevels,
+		style:   (*styles).risk,
+		get:     func(f packages.Filter) string { return f.RiskLevel },
+		set:     func(f *packages.Filter, v string) { f.RiskLevel = v },
+	},
+	{
+		title: "Status",
+		options: func(*packages.Manager) []string {
+			return []string{packages.StatusInstalled, packages.StatusNotInstalled}
+		},
+		get: func(f packages.Filter) string { return f.Status },
+		set: func(f *packages.Filter, v string) { f.Status = v },
+	},
+	{
+		title:   "Pack",
+		options: (*packages.Manager).Sources,
+		label:   filepath.Base,
+		get:     func(f packages.Filter) string { return f.Source },
+		set:     func(f *packages.Filter, v string) { f.Source = v },
+	},
+}
+
+// sidebarRow is an option of a facet; the empty value stands for all
+type sidebarRow struct {
+	facet int
+	value string
+}
+
+// sidebar lists the facets of the package list with the number of packages
+// each option would show
+type sidebar struct {
+	manager *packages.Manager
+	cursor  int
+	focused bool
+}
+
+// rows returns the options of every facet, each facet starting with "All"
+func (s *sidebar) rows() []sidebarRow {
+	var rows []sidebarRow
+	for i, f := range facets {
+		rows = append(rows, sidebarRow{facet: i})
+		for _, value := range f.options(s.manager) {
+			rows = append(rows, sidebarRow{facet: i, value: value})
+		}
+	}
+	return rows
+}
+
+// move moves the cursor by delta rows
+func (s *sidebar) move(delta int) {
+	rows := s.rows()
+	s.cursor += delta
+	if s.cursor < 0 {
+		s.cursor = 0
+	}
+	if s.cursor >= len(rows) {
+		s.cursor = len(rows) - 1
+	}
+}
+
+// choose applies the option under the cursor to the filter. Choosing the
+// active option again clears the facet.
+func (s *sidebar) choose(filter *packages.Filter) {
+	rows := s.rows()
+	if s.cursor < 0 || s.cursor >= len(rows) {
+		return
+	}
+
+	row := rows[s.cursor]
+	f := facets[row.facet]
+	if f.get(*filter) == row.value {
+		f.set(filter, "")
+		return
+	}
+	f.set(filter, row.value)
+}
+
+// view renders the sidebar for a filter, scrolled to keep the cursor in a
+// height of lines
+func (s *sidebar) view(filter packages.Filter, st *styles, height int) string {
+	rows := s.rows()
+	inner := sidebarWidth - 4
+
+	var lines []string
+	cursorLine := 0
+	for i, row := range rows {
+		f := facets[row.facet]
+		if row.value == "" {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, st.helpKey.Render(f.title))
+		}
+
+		// Count what the option would show with the other facets unchanged
+		counted := filter
+		f.set(&counted, row.value)
+		count := fmt.Sprint(len(s.manager.FilterPackages(counted)))
+
+		label := "All"
+		if row.value != "" {
+			label = row.value
+			if f.label != nil {
+				label = f.label(row.value)
+			}
+		}
+
+		marker := "○ "
+		if f.get(filter) == row.value {
+			marker = "● "
+		}
+
+		width := inner - lipgloss.Width(marker) - len(count) - 1
+		label = truncate.StringWithTail(label, uint(width), "…")
+		line := marker + label + strings.Repeat(" ", width-lipgloss.Width(label)+1) + count
+
+		switch {
+		case s.focused && i == s.cursor:
+			cursorLine = len(lines)
+			line = st.sidebarCursor.Render(line)
+		case row.value != "" && f.style != nil:
+			line = f.style(st, row.value).Render(line)
+		default:
+			line = st.sidebarRow.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	// Scroll so the cursor stays visible
+	visible := height - 2
+	if visible < 1 {
+		visible = 1
+	}
+	start := 0
+	if cursorLine >= visible {
+		start = cursorLine - visible + 1
+	}
+	end := start + visible
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	border := st.theme.Muted.Terminal()
+	if s.focused {
+		border = st.theme.Primary.Terminal()
+	}
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(border).
+		Padding(0, 1).
+		Width(sidebarWidth - 2).
+		Height(visible).
+		Render(strings.Join(lines[start:end], "\n"))
+}
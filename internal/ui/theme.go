@@ -0,0 +1,176 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/adb-cleaner/adb-cleaner/internal/theme"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/truncate"
+)
+
+// styles are the lipgloss styles of every part of the interface, derived
+// from a theme
+type styles struct {
+	theme *theme.Theme
+
+	title   lipgloss.Style
+	status  lipgloss.Style
+	success lipgloss.Style
+	err     lipgloss.Style
+	warning lipgloss.Style
+	info    lipgloss.Style
+	help    lipgloss.Style
+	helpKey lipgloss.Style
+	helpBox lipgloss.Style
+
+	sidebarRow    lipgloss.Style
+	sidebarCursor lipgloss.Style
+
+	safe   lipgloss.Style
+	risky  lipgloss.Style
+	danger lipgloss.Style
+
+	items list.DefaultItemStyles
+}
+
+// newStyles derives the styles of a theme
+func newStyles(t *theme.Theme) *styles {
+	p := t.Palette
+	fg := func(c theme.Color) lipgloss.Style {
+		return lipgloss.NewStyle().Foreground(c.Terminal())
+	}
+
+	s := &styles{
+		theme: t,
+		title: lipgloss.NewStyle().
+			Foreground(p.OnPrimary.Terminal()).
+			Background(p.Primary.Terminal()).
+			Padding(0, 1),
+		status: lipgloss.NewStyle().
+			Foreground(p.OnPrimary.Terminal()).
+			Background(p.Accent.Terminal()).
+			Padding(0, 1),
+		success: fg(p.Success),
+		err:     fg(p.Error),
+		warning: fg(p.Warning),
+		info:    fg(p.Info),
+		help:    fg(p.Muted),
+		helpKey: fg(p.Text).Bold(true),
+		helpBox: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(p.Primary.Terminal()).
+			Padding(1, 2),
+		sidebarRow:    fg(p.Text),
+		sidebarCursor: fg(p.Primary).Bold(true),
+		safe:          fg(p.Safe),
+		risky:         fg(p.Risky),
+		danger:        fg(p.Danger),
+	}
+
+	s.items = list.NewDefaultItemStyles()
+	s.items.NormalTitle = s.items.NormalTitle.Foreground(p.Text.Terminal())
+	s.items.NormalDesc = s.items.NormalDesc.Foreground(p.Muted.Terminal())
+	s.items.SelectedTitle = s.items.SelectedTitle.
+		Foreground(p.Primary.Terminal()).
+		BorderForeground(p.Primary.Terminal())
+	s.items.SelectedDesc = s.items.SelectedDesc.
+		Foreground(p.Primary.Terminal()).
+		BorderForeground(p.Primary.Terminal())
+	s.items.DimmedTitle = s.items.DimmedTitle.Foreground(p.Muted.Terminal())
+	s.items.DimmedDesc = s.items.DimmedDesc.Foreground(p.Muted.Terminal())
+
+	if t.Monochrome {
+		// Without colors, emphasis has to come from the text attributes
+		s.title = s.title.Reverse(true).Bold(true)
+		s.status = s.status.Reverse(true)
+		s.err = s.err.Bold(true)
+		s.warning = s.warning.Underline(true)
+		s.help = s.help.Faint(true)
+		s.risky = s.risky.Underline(true)
+		s.danger = s.danger.Bold(true)
+		s.items.SelectedTitle = s.items.SelectedTitle.Bold(true)
+		s.sidebarCursor = s.sidebarCursor.Reverse(true)
+		s.items.NormalDesc = s.items.NormalDesc.Faint(true)
+		s.items.DimmedTitle = s.items.DimmedTitle.Faint(true)
+		s.items.DimmedDesc = s.items.DimmedDesc.Faint(true)
+	}
+
+	return s
+}
+
+// risk returns the style of a risk level
+func (s *styles) risk(level string) lipgloss.Style {
+	switch level {
+	case "SAFE":
+		return s.safe
+	case "RISKY":
+		return s.risky
+	case "DANGER":
+		return s.danger
+	}
+	return s.items.NormalDesc
+}
+
+// list styles a list with the theme
+func (s *styles) list(l *list.Model) {
+	l.Styles.Title = s.title
+	l.Styles.NoItems = s.help
+	l.Styles.PaginationStyle = l.Styles.PaginationStyle.Foreground(s.theme.Muted.Terminal())
+	l.Styles.HelpStyle = l.Styles.HelpStyle.Foreground(s.theme.Muted.Terminal())
+}
+
+// delegate returns a list delegate drawing items with the theme
+func (s *styles) delegate() list.DefaultDelegate {
+	d := list.NewDefaultDelegate()
+	d.Styles = s.items
+	return d
+}
+
+// progress returns a progress bar in the theme colors
+func (s *styles) progress() progress.Model {
+	if s.theme.Monochrome {
+		return progress.New(progress.WithSolidFill(""))
+	}
+	return progress.New(progress.WithGradient(s.theme.Primary.Hex(), s.theme.Accent.Hex()))
+}
+
+// packageDelegate draws packages with their description in the color of
+// their risk level
+type packageDelegate struct {
+	list.DefaultDelegate
+	styles *styles
+}
+
+func newPackageDelegate(s *styles) packageDelegate {
+	return packageDelegate{DefaultDelegate: s.delegate(), styles: s}
+}
+
+func (d packageDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	p, ok := item.(PackageItem)
+	if !ok || m.Width() <= 0 {
+		d.DefaultDelegate.Render(w, m, index, item)
+		return
+	}
+
+	title, desc := d.styles.items.NormalTitle, d.styles.items.NormalDesc
+	if index == m.Index() {
+		title, desc = d.styles.items.SelectedTitle, d.styles.items.SelectedDesc
+	}
+	if risk := p.pkg.Risk(); risk != "" {
+		desc = desc.UnsetForeground().Inherit(d.styles.risk(risk))
+	}
+
+	width := uint(m.Width() - title.GetHorizontalFrameSize())
+	name := truncate.StringWithTail(p.Title(), width, "…")
+	if len(p.matched) > 0 {
+		// Highlight the characters the search matched
+		unmatched := title.Inline(true)
+		matched := unmatched.Copy().Inherit(d.styles.items.FilterMatch)
+		name = lipgloss.StyleRunes(name, p.matched, matched, unmatched)
+	}
+
+	fmt.Fprintf(w, "%s\n%s", title.Render(name), desc.Render(truncate.StringWithTail(p.Description(), width, "…")))
+}
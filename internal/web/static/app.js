@@ -0,0 +1,302 @@
+'use strict';
+
+const $ = (sel) => document.querySelector(sel);
+
+const state = {
+  packages: [],
+  inventory: {},
+  current: null,
+  history: [],
+};
+
+// api calls the JSON API and throws the server error message on failure
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  // The server only accepts changes sent as JSON, even without a body
+  if (method !== 'GET') {
+    opts.headers['Content-Type'] = 'application/json';
+  }
+  if (body !== undefined) {
+    opts.body = JSON.stringify(body);
+  }
+  const res = await fetch(path, opts);
+  const data = await res.json().catch(() => ({}));
+  if (!res.ok) {
+    const err = new Error(data.error || res.statusText);
+    err.force = data.force === true;
+    throw err;
+  }
+  return data;
+}
+
+function showError(err) {
+  alert(err.message || err);
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  Object.entries(attrs || {}).forEach(([k, v]) => {
+    if (k === 'class') node.className = v;
+    else if (k.startsWith('on')) node.addEventListener(k.slice(2), v);
+    else node.setAttribute(k, v);
+  });
+  children.flat().forEach((c) => node.append(c instanceof Node ? c : String(c ?? '')));
+  return node;
+}
+
+// Devices
+
+async function loadDevices() {
+  const devices = await api('GET', '/api/devices');
+  const select = $('#device');
+  select.replaceChildren(el('option', { value: '' }, devices.length ? 'Pick a device' : 'No device connected'));
+  devices.forEach((d) => {
+    const opt = el('option', { value: d.serial }, `${d.manufacturer} ${d.model} (${d.serial})`);
+    opt.selected = d.selected;
+    select.append(opt);
+  });
+}
+
+async function selectDevice(serial) {
+  if (!serial) return;
+  const device = await api('PUT', '/api/device', { serial });
+  showDevice(device);
+  await loadPackages();
+}
+
+function showDevice(device) {
+  const users = (device.users || [device.userId]).join(', ');
+  $('#device-info').textContent = `Android: ${device.androidVersion} | Profile: ${device.profile || '-'} | Users: ${users}`;
+}
+
+// Packages
+
+async function loadPackages() {
+  const [pkgs, inv] = await Promise.all([
+    api('GET', '/api/packages'),
+    api('GET', '/api/inventory'),
+  ]);
+  state.packages = pkgs;
+  state.inventory = Object.fromEntries(inv.packages.map((p) => [p.name, p]));
+  renderPackages();
+}
+
+function visiblePackages() {
+  const query = $('#search').value.toLowerCase();
+  const risk = $('#risk-filter').value;
+  const installedOnly = $('#installed-only').checked;
+  return state.packages.filter((p) =>
+    (!query || p.name.toLowerCase().includes(query) || p.description.toLowerCase().includes(query)) &&
+    (!risk || p.riskLevel === risk) &&
+    (!installedOnly || p.installed));
+}
+
+function renderPackages() {
+  const rows = visiblePackages().map((p) => {
+    const checkbox = el('input', { type: 'checkbox' });
+    checkbox.checked = p.selected;
+    checkbox.addEventListener('click', (e) => {
+      e.stopPropagation();
+      toggle(p, checkbox.checked).catch(showError);
+    });
+    const row = el('tr', { onclick: () => showDetail(p) },
+      el('td', {}, checkbox),
+      el('td', {}, p.name),
+      el('td', { class: `risk-${p.riskLevel}` }, p.riskLevel || '-'),
+      el('td', { title: p.score ? p.score.reasons.join('\n') : '' }, p.score ? p.score.value : '-'),
+      el('td', {}, p.category || '-'),
+      el('td', {}, p.installed ? '✓' : ''));
+    if (state.current === p.name) row.classList.add('current');
+    return row;
+  });
+  $('#packages').replaceChildren(...rows);
+
+  const selected = state.packages.filter((p) => p.selected).length;
+  $('#counts').textContent = `Selected: ${selected}/${state.packages.length}`;
+}
+
+function showDetail(p) {
+  state.current = p.name;
+  const rec = state.inventory[p.name];
+  const fields = [
+    ['Package', p.name],
+    ['Description', p.description || 'No description'],
+    ['Category', p.category || '-'],
+    ['Risk', p.riskLevel || '-'],
+    ['Score', p.score ? `${p.score.value} ${p.score.level}` : '-'],
+    ['Reasons', p.score ? p.score.reasons.join(', ') : '-'],
+    ['Source', p.source],
+    ['Action', p.action],
+    ['Installed', p.installed ? 'yes' : 'no'],
+  ];
+  if (rec) {
+    fields.push(['Path', rec.path], ['UID', rec.uid], ['Installer', rec.installer || '-'],
+      ['System', rec.system ? 'yes' : 'no'], ['Enabled', rec.enabled ? 'yes' : 'no']);
+  }
+  $('#detail').replaceChildren(
+    el('h2', { class: `risk-${p.riskLevel}` }, p.name),
+    el('dl', {}, fields.map(([k, v]) => [el('dt', {}, k), el('dd', {}, v)])));
+  renderPackages();
+}
+
+async function toggle(p, selected) {
+  await api('POST', '/api/selection', selected ? { select: [p.name] } : { deselect: [p.name] });
+  p.selected = selected;
+  renderPackages();
+}
+
+async function changeSelection(body) {
+  await api('POST', '/api/selection', body);
+  await loadPackages();
+}
+
+// Confirm and progress
+
+async function review() {
+  const plan = await api('POST', '/api/plan');
+  const remove = plan.filter((i) => i.result !== 'skipped').length;
+  $('#confirm-summary').textContent =
+    `You are about to remove ${remove} packages (${plan.length - remove} will be skipped).`;
+  $('#plan').replaceChildren(el('table', {}, plan.map((i) =>
+    el('tr', {},
+      el('td', {}, i.package),
+      el('td', { class: `risk-${i.riskLevel}` }, i.riskLevel || '-'),
+      el('td', {}, `user ${i.user}`),
+      el('td', { class: `result-${i.result}` }, i.result === 'skipped' ? `skip: ${i.reason}` : i.action)))));
+  $('#confirm').showModal();
+}
+
+async function startRun() {
+  $('#confirm').close();
+  const req = {
+    dryRun: $('#dry-run').checked,
+    backupApks: $('#backup-apks').checked,
+  };
+  let run;
+  try {
+    run = await api('POST', '/api/runs', req);
+  } catch (err) {
+    // Default apps without a replacement are only removed once confirmed
+    if (!err.force || !confirm(`${err.message}\n\nRemove them anyway?`)) throw err;
+    run = await api('POST', '/api/runs', { ...req, force: true });
+  }
+  watchRun(run, 'Removing Packages');
+}
+
+function watchRun(run, title) {
+  $('#progress-title').textContent = title;
+  $('#progress-log').textContent = '';
+  $('#progress-bar').value = 0;
+  $('#progress-close').disabled = true;
+  $('#progress').showModal();
+
+  const source = new EventSource(`/api/runs/${run.id}/events`);
+  const log = (line) => { $('#progress-log').textContent += line + '\n'; };
+  const onEvent = (e) => {
+    const ev = JSON.parse(e.data);
+    $('#progress-bar').max = Math.max(ev.total, 1);
+    $('#progress-bar').value = ev.done;
+    if (ev.package) {
+      log(`[${(ev.result || ev.type).toUpperCase()}] ${ev.package}${ev.message ? ': ' + ev.message : ''}`);
+    } else if (ev.message) {
+      log(ev.message);
+    }
+    if (ev.type === 'finished') {
+      source.close();
+      $('#progress-close').disabled = false;
+      loadPackages().catch(showError);
+    }
+  };
+  ['started', 'package', 'backup', 'restore', 'warning', 'finished'].forEach((t) =>
+    source.addEventListener(t, onEvent));
+  source.onerror = () => {
+    source.close();
+    $('#progress-close').disabled = false;
+  };
+}
+
+// History
+
+async function loadHistory() {
+  const all = $('#history-all').checked ? '?all=1' : '';
+  state.history = await api('GET', '/api/history' + all);
+  const rows = state.history.map((h) => el('tr', { onclick: () => showRun(h.name) },
+    el('td', {}, new Date(h.createdAt).toLocaleString() + (h.dryRun ? ' [DRY-RUN]' : '')),
+    el('td', {}, `${h.device.manufacturer} ${h.device.model}`),
+    el('td', {}, Object.entries(h.counts).map(([k, v]) => `${k}: ${v}`).join(', ')),
+    el('td', {}, (h.packs || []).map((p) => p.path).join(', '))));
+  $('#history').replaceChildren(...rows);
+}
+
+async function showRun(name) {
+  const run = await api('GET', `/api/history/${encodeURIComponent(name)}`);
+  const restore = el('button', { onclick: () => restoreRun(name).catch(showError) }, 'Restore everything');
+  const reapply = el('button', { onclick: () => reapplyRun(name).catch(showError) }, 'Re-apply to this device');
+  $('#run-detail').replaceChildren(
+    el('h2', {}, new Date(run.createdAt).toLocaleString()),
+    el('div', { class: 'toolbar' }, restore, reapply),
+    el('table', {}, (run.packages || []).map((p) =>
+      el('tr', {},
+        el('td', {}, p.package),
+        el('td', { class: `result-${p.result}` }, p.result),
+        el('td', {}, p.error || '')))));
+}
+
+async function restoreRun(name) {
+  const path = `/api/history/${encodeURIComponent(name)}/restore`;
+  let run;
+  try {
+    run = await api('POST', path);
+  } catch (err) {
+    // Backups of another device are only restored once confirmed
+    if (!err.force || !confirm(`${err.message}\n\nRestore onto this device anyway?`)) throw err;
+    run = await api('POST', path, { force: true });
+  }
+  watchRun(run, 'Restoring Packages');
+}
+
+async function reapplyRun(name) {
+  const res = await api('POST', `/api/history/${encodeURIComponent(name)}/reapply`);
+  if (res.unmatched.length > 0) {
+    alert(`Not in the loaded packs, left out: ${res.unmatched.join(', ')}`);
+  }
+  switchView('packages');
+  await loadPackages();
+  await review();
+}
+
+function switchView(view) {
+  document.querySelectorAll('nav button').forEach((b) => b.classList.toggle('active', b.dataset.view === view));
+  $('#view-packages').hidden = view !== 'packages';
+  $('#view-history').hidden = view !== 'history';
+  if (view === 'history') loadHistory().catch(showError);
+}
+
+// Wiring
+
+$('#device').addEventListener('change', (e) => selectDevice(e.target.value).catch(showError));
+['#search', '#risk-filter', '#installed-only'].forEach((s) => $(s).addEventListener('input', renderPackages));
+$('#select-visible').addEventListener('click', () =>
+  changeSelection({ select: visiblePackages().map((p) => p.name) }).catch(showError));
+$('#deselect-all').addEventListener('click', () => api('PUT', '/api/selection', { packages: [] }).then(loadPackages).catch(showError));
+$('#select-safe').addEventListener('click', () =>
+  changeSelection({ select: state.packages.filter((p) => p.riskLevel === 'SAFE').map((p) => p.name) }).catch(showError));
+$('#review').addEventListener('click', () => review().catch(showError));
+$('#confirm-cancel').addEventListener('click', () => $('#confirm').close());
+$('#confirm-run').addEventListener('click', () => startRun().catch(showError));
+$('#progress-close').addEventListener('click', () => {
+  $('#progress').close();
+  if (!$('#view-history').hidden) loadHistory().catch(showError);
+});
+$('#history-all').addEventListener('change', () => loadHistory().catch(showError));
+document.querySelectorAll('nav button').forEach((b) => b.addEventListener('click', () => switchView(b.dataset.view)));
+
+(async () => {
+  await loadDevices();
+  try {
+    showDevice(await api('GET', '/api/device'));
+    await loadPackages();
+  } catch (err) {
+    // No device selected yet, wait for the user to pick one
+  }
+})().catch(showError);